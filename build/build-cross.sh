@@ -1,5 +1,5 @@
 #!/bin/sh
 
-cd $GOPATH/src/github.com/kyokan/chaind
+cd /go/src/github.com/kyokan/chaind
 make deps
-GOOS=linux GOARCH=amd64 go build -ldflags '-w -extldflags "-static"' -o ./target/chaind ./cmd/chaind/main.go
\ No newline at end of file
+CGO_ENABLED=1 GOOS=linux GOARCH=amd64 go build -ldflags '-w -extldflags "-static"' -o ./target/chaind ./cmd/chaind/main.go
\ No newline at end of file
@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+	"github.com/spf13/cobra"
+	"github.com/kyokan/chaind/internal/audit"
+	"github.com/kyokan/chaind/pkg/config"
+)
+
+const (
+	outputCSV  = "csv"
+	outputJSON = "json"
+)
+
+var auditQuery audit.Query
+var auditDB string
+var auditSince string
+var auditUntil string
+var auditAggregate string
+var auditOutput string
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "inspects audited requests",
+}
+
+var auditQueryCmd = &cobra.Command{
+	Use:   "query",
+	Short: "prints or aggregates the requests recorded by a sqlite audit sink",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if auditOutput != outputCSV && auditOutput != outputJSON {
+			return fmt.Errorf("output must be %s or %s", outputCSV, outputJSON)
+		}
+
+		now := time.Now()
+		var err error
+		if auditQuery.Since, err = parseQueryTime(auditSince, now); err != nil {
+			return err
+		}
+		if auditQuery.Until, err = parseQueryTime(auditUntil, now); err != nil {
+			return err
+		}
+
+		path := auditDB
+		if path == "" {
+			path, err = sqliteSinkPath()
+			if err != nil {
+				return err
+			}
+		}
+		store, err := audit.OpenStore(path)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+
+		if auditAggregate != "" {
+			counts, err := store.Aggregate(&auditQuery, auditAggregate)
+			if err != nil {
+				return err
+			}
+			return printCounts(os.Stdout, auditAggregate, counts)
+		}
+
+		entries, err := store.Requests(&auditQuery)
+		if err != nil {
+			return err
+		}
+		return printEntries(os.Stdout, entries)
+	},
+}
+
+func init() {
+	auditQueryCmd.Flags().StringVar(&auditDB, "db", "", "path to the audit database, defaults to the sqlite sink's path in the config file")
+	auditQueryCmd.Flags().StringVar(&auditSince, "since", "", "only include requests at or after this RFC 3339 time, or this long ago, e.g. 1h")
+	auditQueryCmd.Flags().StringVar(&auditUntil, "until", "", "only include requests before this RFC 3339 time, or this long ago")
+	auditQueryCmd.Flags().StringVar(&auditQuery.RemoteAddr, "remote-addr", "", "only include requests from this remote address")
+	auditQueryCmd.Flags().StringVar(&auditQuery.UserAgent, "user-agent", "", "only include requests whose user agent contains this string")
+	auditQueryCmd.Flags().StringVar(&auditQuery.Method, "method", "", "only include requests for this method")
+	auditQueryCmd.Flags().StringVar(&auditQuery.APIKey, "api-key", "", "only include requests with this API key")
+	auditQueryCmd.Flags().StringVar(&auditAggregate, "aggregate", "", "count requests by methods, clients or per_minute instead of printing them")
+	auditQueryCmd.Flags().IntVar(&auditQuery.Limit, "limit", 1000, "maximum number of rows to print, or 0 for no limit")
+	auditQueryCmd.Flags().StringVar(&auditOutput, "output", outputCSV, "output format, csv or json")
+	auditCmd.AddCommand(auditQueryCmd)
+	rootCmd.AddCommand(auditCmd)
+}
+
+// parseQueryTime accepts RFC 3339 times and durations before now.
+func parseQueryTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if ago, err := time.ParseDuration(value); err == nil {
+		return now.Add(-ago), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %s: must be RFC 3339 or a duration", value)
+	}
+	return t, nil
+}
+
+func sqliteSinkPath() (string, error) {
+	cfg, err := config.ReadConfig(false)
+	if err != nil {
+		return "", err
+	}
+	if cfg.AuditConfig != nil {
+		for _, sink := range cfg.AuditConfig.Sinks {
+			if sink.Type == config.AuditSinkSQLite {
+				return sink.Path, nil
+			}
+		}
+	}
+	return "", errors.New("no sqlite audit sink found, pass --db")
+}
+
+func printEntries(w io.Writer, entries []*audit.Entry) error {
+	header := []string{"time"}
+	fields := (&audit.Entry{Kind: audit.KindRequest}).Fields()
+	for i := 0; i < len(fields); i += 2 {
+		header = append(header, fields[i].(string))
+	}
+
+	rows := make([][]interface{}, len(entries))
+	for i, entry := range entries {
+		fields := append([]interface{}{"time", entry.Time.UTC().Format(time.RFC3339Nano)}, entry.Fields()...)
+		row := make([]interface{}, 0, len(fields)/2)
+		for j := 1; j < len(fields); j += 2 {
+			row = append(row, fields[j])
+		}
+		rows[i] = row
+	}
+	return printRows(w, header, rows)
+}
+
+func printCounts(w io.Writer, by string, counts []audit.Count) error {
+	key := map[string]string{
+		audit.AggregateMethods:   "method",
+		audit.AggregateClients:   "remote_addr",
+		audit.AggregatePerMinute: "minute",
+	}[by]
+	rows := make([][]interface{}, len(counts))
+	for i, count := range counts {
+		rows[i] = []interface{}{count.Key, count.Count}
+	}
+	return printRows(w, []string{key, "count"}, rows)
+}
+
+func printRows(w io.Writer, header []string, rows [][]interface{}) error {
+	if auditOutput == outputJSON {
+		objs := make([]map[string]interface{}, len(rows))
+		for i, row := range rows {
+			objs[i] = make(map[string]interface{})
+			for j, value := range row {
+				objs[i][header[j]] = value
+			}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(objs)
+	}
+
+	out := csv.NewWriter(w)
+	out.Write(header)
+	for _, row := range rows {
+		record := make([]string, len(row))
+		for i, value := range row {
+			if f, ok := value.(float64); ok {
+				record[i] = strconv.FormatFloat(f, 'f', 3, 64)
+			} else {
+				record[i] = fmt.Sprint(value)
+			}
+		}
+		out.Write(record)
+	}
+	out.Flush()
+	return out.Error()
+}
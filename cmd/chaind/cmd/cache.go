@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"github.com/spf13/cobra"
+	"github.com/kyokan/chaind/internal/cache"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/kyokan/chaind/pkg/jsonrpc"
+)
+
+var includeBodies bool
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "inspects and purges cached responses",
+}
+
+var cacheGetCmd = &cobra.Command{
+	Use:   "get",
+	Short: "prints a cached response",
+}
+
+var cacheGetBlockCmd = &cobra.Command{
+	Use:   "block <number>",
+	Short: "prints a cached block",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		number, err := parseBlockNumber(args[0])
+		if err != nil {
+			return err
+		}
+
+		return withStore(func(store *cache.ETHStore) error {
+			return printCached(store.GetBlockByNumber(number, includeBodies))
+		})
+	},
+}
+
+var cacheGetReceiptCmd = &cobra.Command{
+	Use:   "receipt <tx hash>",
+	Short: "prints a cached transaction receipt",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withStore(func(store *cache.ETHStore) error {
+			return printCached(store.GetTransactionReceipt(args[0]))
+		})
+	},
+}
+
+var cacheGetBalanceCmd = &cobra.Command{
+	Use:   "balance <address>",
+	Short: "prints a cached balance and the block it was cached at",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withStore(func(store *cache.ETHStore) error {
+			balance, height, err := store.GetCachedBalance(args[0])
+			if err != nil {
+				return err
+			}
+			if balance == nil {
+				return errors.New("not cached")
+			}
+
+			fmt.Printf("%s (cached at block %d)\n", balance, height)
+			return nil
+		})
+	},
+}
+
+var cachePurgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "removes cached responses",
+}
+
+var cachePurgeBlocksCmd = &cobra.Command{
+	Use:   "blocks <from> [to]",
+	Short: "removes cached blocks in an inclusive range, along with their transaction receipts",
+	Args:  cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		from, err := parseBlockNumber(args[0])
+		if err != nil {
+			return err
+		}
+		to := from
+		if len(args) == 2 {
+			to, err = parseBlockNumber(args[1])
+			if err != nil {
+				return err
+			}
+		}
+
+		return withStore(func(store *cache.ETHStore) error {
+			return printPurged(store.PurgeBlocks(from, to))
+		})
+	},
+}
+
+var cachePurgeReceiptCmd = &cobra.Command{
+	Use:   "receipt <tx hash>",
+	Short: "removes a cached transaction receipt",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withStore(func(store *cache.ETHStore) error {
+			return printPurged(store.PurgeTransactionReceipt(args[0]))
+		})
+	},
+}
+
+var cachePurgeAddressCmd = &cobra.Command{
+	Use:   "address <address>",
+	Short: "removes the cached balance, nonce and code of an address",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withStore(func(store *cache.ETHStore) error {
+			return printPurged(store.PurgeAddress(args[0]))
+		})
+	},
+}
+
+var cachePurgeMethodCmd = &cobra.Command{
+	Use:   "method <method>",
+	Short: "removes every cached response to a method",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withStore(func(store *cache.ETHStore) error {
+			return printPurged(store.PurgeMethod(args[0]))
+		})
+	},
+}
+
+var cacheStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "prints key counts and hit/miss ratios for each cached method",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return withStore(func(store *cache.ETHStore) error {
+			stats, err := store.Stats()
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "METHOD\tKEYS\tHITS\tMISSES\tHIT RATIO")
+			for _, stat := range stats {
+				fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%.1f%%\n", stat.Method, stat.Keys, stat.Hits, stat.Misses, stat.HitRatio()*100)
+			}
+			return w.Flush()
+		})
+	},
+}
+
+func init() {
+	cacheGetBlockCmd.Flags().BoolVar(&includeBodies, "bodies", false, "get the block with full transaction bodies")
+	cacheGetCmd.AddCommand(cacheGetBlockCmd, cacheGetReceiptCmd, cacheGetBalanceCmd)
+	cachePurgeCmd.AddCommand(cachePurgeBlocksCmd, cachePurgeReceiptCmd, cachePurgeAddressCmd, cachePurgeMethodCmd)
+	cacheCmd.AddCommand(cacheGetCmd, cachePurgeCmd, cacheStatsCmd)
+	rootCmd.AddCommand(cacheCmd)
+}
+
+func withStore(fn func(store *cache.ETHStore) error) error {
+	cfg, err := config.ReadConfig(false)
+	if err != nil {
+		return err
+	}
+	if cfg.RedisConfig == nil {
+		return errors.New("no redis config found")
+	}
+
+	cacher := cache.NewRedisCacher(cfg.RedisConfig)
+	if err := cacher.Start(); err != nil {
+		return err
+	}
+	defer cacher.Stop()
+
+	return fn(cache.NewETHStore(cacher, nil))
+}
+
+// parseBlockNumber accepts both decimal and 0x-prefixed hex block numbers.
+func parseBlockNumber(number string) (uint64, error) {
+	if len(number) > 2 && number[:2] == "0x" {
+		return jsonrpc.Hex2Uint64(number)
+	}
+
+	return strconv.ParseUint(number, 10, 64)
+}
+
+func printCached(data []byte, err error) error {
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		return errors.New("not cached")
+	}
+
+	fmt.Println(string(data))
+	return nil
+}
+
+func printPurged(count int, err error) error {
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("purged %d keys\n", count)
+	return nil
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	"github.com/spf13/cobra"
+	"github.com/kyokan/chaind/pkg/mocknode"
+)
+
+var mockNodeCfg mocknode.Config
+var mockNodeFaults mocknode.Faults
+var mockNodeAddr string
+
+var mockNodeCmd = &cobra.Command{
+	Use:   "mocknode",
+	Short: "runs a simulated Ethereum node for local development and testing",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		node := mocknode.New(mockNodeCfg)
+		node.SetFaults(mockNodeFaults)
+		if err := node.Start(); err != nil {
+			return err
+		}
+		defer node.Stop()
+
+		srv := &http.Server{
+			Addr:    mockNodeAddr,
+			Handler: node,
+		}
+		errs := make(chan error, 1)
+		go func() {
+			errs <- srv.ListenAndServe()
+		}()
+		fmt.Printf("mock node listening on %s at block %d\n", mockNodeAddr, node.Head())
+
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+		select {
+		case err := <-errs:
+			return err
+		case <-sigs:
+			return srv.Close()
+		}
+	},
+}
+
+func init() {
+	mockNodeCmd.Flags().StringVar(&mockNodeAddr, "addr", ":8545", "address to serve JSON-RPC on")
+	mockNodeCmd.Flags().Uint64Var(&mockNodeCfg.ChainID, "chain-id", mocknode.DefaultChainID, "chain ID")
+	mockNodeCmd.Flags().Uint64Var(&mockNodeCfg.InitialHeight, "height", 0, "block height to start at")
+	mockNodeCmd.Flags().IntVar(&mockNodeCfg.TxsPerBlock, "txs", mocknode.DefaultTxsPerBlock, "number of generated transactions in each block")
+	mockNodeCmd.Flags().DurationVar(&mockNodeCfg.BlockTime, "block-time", 2*time.Second, "time between blocks, or 0 to only mine blocks with mock_mine")
+	mockNodeCmd.Flags().DurationVar(&mockNodeFaults.Latency, "latency", 0, "delay before every response")
+	mockNodeCmd.Flags().IntVar(&mockNodeFaults.HTTPStatus, "http-status", 0, "HTTP status code to answer every request with instead of a JSON-RPC response")
+	mockNodeCmd.Flags().StringSliceVar(&mockNodeFaults.ErrorMethods, "error-methods", nil, "methods to answer with a JSON-RPC error")
+	mockNodeCmd.Flags().BoolVar(&mockNodeFaults.Syncing, "syncing", false, "report that the node is syncing, and stop advancing the reported head")
+	rootCmd.AddCommand(mockNodeCmd)
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+	"github.com/spf13/cobra"
+	"github.com/kyokan/chaind/internal/recorder"
+)
+
+var replayOpts recorder.ReplayOptions
+var showMismatches bool
+
+var replayCmd = &cobra.Command{
+	Use:   "replay <capture file>",
+	Short: "replays recorded traffic against a chaind instance or backend, and reports latencies and response mismatches",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if replayOpts.Speed < 0 {
+			return errors.New("speed cannot be negative")
+		}
+
+		r, err := recorder.OpenReader(args[0])
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		report, err := recorder.Replay(r, replayOpts)
+		if err != nil {
+			return err
+		}
+		return printReport(report)
+	},
+}
+
+func init() {
+	replayCmd.Flags().StringVar(&replayOpts.URL, "target", "", "URL to send the requests to")
+	replayCmd.Flags().Float64Var(&replayOpts.Speed, "speed", 1, "multiple of the original request rate to replay at, or 0 to replay as fast as possible")
+	replayCmd.Flags().IntVar(&replayOpts.Concurrency, "concurrency", 10, "number of requests in flight when replaying as fast as possible")
+	replayCmd.Flags().DurationVar(&replayOpts.Timeout, "timeout", 30*time.Second, "timeout for each request")
+	replayCmd.Flags().BoolVar(&showMismatches, "show-mismatches", false, "print the requests whose responses differed from the recorded ones")
+	replayCmd.MarkFlagRequired("target")
+	rootCmd.AddCommand(replayCmd)
+}
+
+func printReport(report *recorder.Report) error {
+	fmt.Printf("replayed %d requests in %s\n\n", report.Total.Count, report.Elapsed.Round(time.Millisecond))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "METHOD\tCOUNT\tERRORS\tMISMATCHES\tP50\tP90\tP99\tMAX\tRECORDED P50\tRECORDED P99")
+	for _, stats := range append(report.Methods, report.Total) {
+		method := stats.Method
+		if stats == report.Total {
+			method = "total"
+		}
+		fmt.Fprintf(
+			w,
+			"%s\t%d\t%d\t%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			method,
+			stats.Count,
+			stats.Errors,
+			stats.Mismatches,
+			formatLatency(stats.Percentile(50)),
+			formatLatency(stats.Percentile(90)),
+			formatLatency(stats.Percentile(99)),
+			formatLatency(stats.Percentile(100)),
+			formatLatency(stats.OriginalPercentile(50)),
+			formatLatency(stats.OriginalPercentile(99)),
+		)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if !showMismatches {
+		return nil
+	}
+	for _, mismatch := range report.Mismatches {
+		fmt.Printf("\n%s\n  request:  %s\n  recorded: %s\n  replayed: %s\n", mismatch.Method, mismatch.Request, mismatch.Expected, mismatch.Actual)
+	}
+	return nil
+}
+
+func formatLatency(d time.Duration) string {
+	return fmt.Sprintf("%.1fms", float64(d)/float64(time.Millisecond))
+}
@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/kyokan/chaind/internal/backend"
+	"github.com/kyokan/chaind/internal/cache"
+	"github.com/kyokan/chaind/pkg/config"
+)
+
+var backfillOpts cache.BackfillOptions
+var backfillFrom string
+var backfillTo string
+
+var warmCmd = &cobra.Command{
+	Use:   "warm",
+	Short: "backfills the cache with the blocks, transactions and receipts in a range of finalized blocks",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		from, err := parseBlockNumber(backfillFrom)
+		if err != nil {
+			return err
+		}
+		backfillOpts.From = from
+
+		cfg, err := config.ReadConfig(false)
+		if err != nil {
+			return err
+		}
+		if cfg.RedisConfig == nil {
+			return errors.New("no redis config found")
+		}
+
+		sw := backend.NewSwitcher(cfg.Backends)
+		if err := sw.Start(); err != nil {
+			return err
+		}
+		defer sw.Stop()
+		hWatcher := cache.NewBlockHeightWatcher(sw)
+		if err := hWatcher.Start(); err != nil {
+			return err
+		}
+		defer hWatcher.Stop()
+		cacher := cache.NewRedisCacher(cfg.RedisConfig)
+		if err := cacher.Start(); err != nil {
+			return err
+		}
+		defer cacher.Stop()
+		store := cache.NewETHStore(cacher, hWatcher)
+		store.SetPolicy(cache.NewPolicy(cfg.CacheConfig))
+
+		if backfillTo == "" {
+			height, depth := hWatcher.BlockHeight(), hWatcher.FinalityDepth()
+			if height < depth {
+				return errors.New("no finalized blocks to backfill")
+			}
+			backfillOpts.To = height - depth
+		} else {
+			backfillOpts.To, err = parseBlockNumber(backfillTo)
+			if err != nil {
+				return err
+			}
+		}
+
+		backfillOpts.Progress = func(done uint64, total uint64) {
+			fmt.Printf("backfilled %d/%d blocks (%.1f%%)\n", done, total, float64(done)/float64(total)*100)
+		}
+		return cache.NewBackfiller(store, cacher, hWatcher, sw, backfillOpts).Run()
+	},
+}
+
+func init() {
+	warmCmd.Flags().StringVar(&backfillFrom, "from", "", "first block to backfill")
+	warmCmd.Flags().StringVar(&backfillTo, "to", "", "last block to backfill, defaults to the latest finalized block")
+	warmCmd.Flags().IntVar(&backfillOpts.Concurrency, "concurrency", cache.WarmUpConcurrency, "number of blocks to fetch concurrently")
+	warmCmd.Flags().IntVar(&backfillOpts.RequestsPerSec, "rate", 50, "maximum requests per second to the backend, or 0 for unlimited")
+	warmCmd.Flags().BoolVar(&backfillOpts.Restart, "restart", false, "ignore the checkpoint left by a previous run over the same range")
+	warmCmd.MarkFlagRequired("from")
+	rootCmd.AddCommand(warmCmd)
+}
@@ -0,0 +1,218 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+	"github.com/inconshreveable/log15"
+	"github.com/kyokan/chaind/internal/backend"
+	"github.com/kyokan/chaind/pkg"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/kyokan/chaind/pkg/log"
+	"github.com/pkg/errors"
+)
+
+// DefaultPoolName is how the default pool is referred to over the admin API.
+const DefaultPoolName = "default"
+
+type addBackendRequest struct {
+	Name string   `json:"name"`
+	URL  string   `json:"url"`
+	Tags []string `json:"tags"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// Server exposes an authenticated HTTP API for inspecting and changing
+// backends at runtime.
+type Server struct {
+	cfg      *config.AdminConfig
+	ctrl     backend.Controller
+	srv      *http.Server
+	quitChan chan bool
+	errChan  chan error
+	logger   log15.Logger
+}
+
+func NewServer(cfg *config.AdminConfig, ctrl backend.Controller) *Server {
+	return &Server{
+		cfg:      cfg,
+		ctrl:     ctrl,
+		quitChan: make(chan bool),
+		errChan:  make(chan error),
+		logger:   log.NewLog("admin"),
+	}
+}
+
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.cfg.ListenAddr)
+	if err != nil {
+		return errors.Wrapf(err, "failed to listen on %s", s.cfg.ListenAddr)
+	}
+
+	s.srv = &http.Server{
+		Addr:    s.cfg.ListenAddr,
+		Handler: s.Handler(),
+	}
+
+	go func() {
+		if err := s.srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+			s.logger.Error("admin server error", "addr", s.cfg.ListenAddr, "err", err)
+		}
+	}()
+
+	go func() {
+		<-s.quitChan
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.errChan <- s.srv.Shutdown(ctx)
+	}()
+
+	s.logger.Info("started", "addr", s.cfg.ListenAddr)
+	return nil
+}
+
+func (s *Server) Stop() error {
+	s.quitChan <- true
+	return <-s.errChan
+}
+
+// Handler returns the admin API's routes, wrapped in authentication.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/backends", s.handleBackends)
+	mux.HandleFunc("/backends/", s.handleBackend)
+	mux.HandleFunc("/selection", s.handleSelection)
+	return s.authenticate(mux)
+}
+
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	expected := []byte("Bearer " + s.cfg.AuthToken)
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		actual := []byte(req.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(expected, actual) != 1 {
+			s.logger.Warn("rejected unauthenticated admin request", "path", req.URL.Path, "remote_addr", req.RemoteAddr)
+			writeError(res, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+
+		next.ServeHTTP(res, req)
+	})
+}
+
+// handleBackends serves GET /backends and POST /backends.
+func (s *Server) handleBackends(res http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case "GET":
+		writeJSON(res, http.StatusOK, s.ctrl.Statuses())
+	case "POST":
+		var body addBackendRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			writeError(res, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		err := s.ctrl.AddBackend(config.Backend{
+			Type: pkg.EthBackend,
+			Name: body.Name,
+			URL:  body.URL,
+			Tags: body.Tags,
+		})
+		if err != nil {
+			writeError(res, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.logger.Info("added backend via admin API", "name", body.Name)
+		writeJSON(res, http.StatusCreated, s.ctrl.Statuses())
+	default:
+		writeError(res, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleBackend serves DELETE /backends/<name> and
+// POST /backends/<name>/<drain|disable|enable|select>.
+func (s *Server) handleBackend(res http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/backends/"), "/")
+	name := parts[0]
+	if name == "" || len(parts) > 2 {
+		writeError(res, http.StatusNotFound, "not found")
+		return
+	}
+
+	var err error
+	switch {
+	case len(parts) == 1 && req.Method == "DELETE":
+		err = s.ctrl.RemoveBackend(name)
+	case len(parts) == 2 && req.Method == "POST":
+		switch parts[1] {
+		case "drain":
+			err = s.ctrl.Drain(name)
+		case "disable":
+			err = s.ctrl.Disable(name)
+		case "enable":
+			err = s.ctrl.Enable(name)
+		case "select":
+			err = s.ctrl.SwitchTo(poolFromName(req.URL.Query().Get("pool")), name)
+		default:
+			writeError(res, http.StatusNotFound, "not found")
+			return
+		}
+	default:
+		writeError(res, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if err != nil {
+		writeError(res, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.logger.Info("changed backend via admin API", "name", name, "method", req.Method, "path", req.URL.Path)
+	writeJSON(res, http.StatusOK, s.ctrl.Statuses())
+}
+
+// handleSelection serves GET /selection.
+func (s *Server) handleSelection(res http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		writeError(res, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	out := make(map[string]string)
+	for pool, name := range s.ctrl.Selection() {
+		if pool == backend.DefaultPool {
+			pool = DefaultPoolName
+		}
+		out[pool] = name
+	}
+	writeJSON(res, http.StatusOK, out)
+}
+
+func poolFromName(name string) string {
+	if name == "" || name == DefaultPoolName {
+		return backend.DefaultPool
+	}
+
+	return name
+}
+
+func writeJSON(res http.ResponseWriter, code int, data interface{}) {
+	out, err := json.Marshal(data)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(code)
+	res.Write(out)
+}
+
+func writeError(res http.ResponseWriter, code int, msg string) {
+	writeJSON(res, code, &errorResponse{Error: msg})
+}
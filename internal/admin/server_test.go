@@ -0,0 +1,95 @@
+package admin
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"github.com/kyokan/chaind/internal/backend"
+	"github.com/kyokan/chaind/pkg"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/stretchr/testify/require"
+)
+
+func doRequest(t *testing.T, srv *httptest.Server, method string, path string, body string, token string) (int, []byte) {
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	require.NoError(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	out, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	return res.StatusCode, out
+}
+
+func TestServer(t *testing.T) {
+	sw := backend.NewSwitcher([]config.Backend{
+		{Name: "test-1", URL: "http://localhost:8545", Type: pkg.EthBackend},
+		{Name: "test-2", URL: "http://localhost:8546", Type: pkg.EthBackend},
+	})
+	admin := NewServer(&config.AdminConfig{AuthToken: "secret"}, sw)
+	srv := httptest.NewServer(admin.Handler())
+	defer srv.Close()
+
+	code, _ := doRequest(t, srv, "GET", "/backends", "", "")
+	require.Equal(t, http.StatusUnauthorized, code)
+	code, _ = doRequest(t, srv, "GET", "/backends", "", "wrong")
+	require.Equal(t, http.StatusUnauthorized, code)
+
+	code, body := doRequest(t, srv, "GET", "/backends", "", "secret")
+	require.Equal(t, http.StatusOK, code)
+	var statuses []backend.BackendStatus
+	require.NoError(t, json.Unmarshal(body, &statuses))
+	require.Len(t, statuses, 2)
+	require.Equal(t, backend.StateActive, statuses[0].State)
+
+	code, body = doRequest(t, srv, "GET", "/selection", "", "secret")
+	require.Equal(t, http.StatusOK, code)
+	require.JSONEq(t, "{\"default\":\"test-1\"}", string(body))
+
+	code, _ = doRequest(t, srv, "POST", "/backends/test-1/drain", "", "secret")
+	require.Equal(t, http.StatusOK, code)
+	_, body = doRequest(t, srv, "GET", "/selection", "", "secret")
+	require.JSONEq(t, "{\"default\":\"test-2\"}", string(body))
+
+	code, _ = doRequest(t, srv, "POST", "/backends/test-1/select?pool=default", "", "secret")
+	require.Equal(t, http.StatusBadRequest, code)
+	code, _ = doRequest(t, srv, "POST", "/backends/test-1/enable", "", "secret")
+	require.Equal(t, http.StatusOK, code)
+	code, _ = doRequest(t, srv, "POST", "/backends/test-1/select", "", "secret")
+	require.Equal(t, http.StatusOK, code)
+	_, body = doRequest(t, srv, "GET", "/selection", "", "secret")
+	require.JSONEq(t, "{\"default\":\"test-1\"}", string(body))
+
+	code, _ = doRequest(t, srv, "POST", "/backends", "{\"name\":\"archive-1\",\"url\":\"http://localhost:8547\",\"tags\":[\"archive\"]}", "secret")
+	require.Equal(t, http.StatusCreated, code)
+	code, _ = doRequest(t, srv, "POST", "/backends", "{\"name\":\"archive-1\",\"url\":\"http://localhost:8547\"}", "secret")
+	require.Equal(t, http.StatusBadRequest, code)
+	_, body = doRequest(t, srv, "GET", "/selection", "", "secret")
+	require.JSONEq(t, "{\"default\":\"test-1\",\"archive\":\"archive-1\"}", string(body))
+
+	code, _ = doRequest(t, srv, "DELETE", "/backends/test-2", "", "secret")
+	require.Equal(t, http.StatusOK, code)
+	code, _ = doRequest(t, srv, "DELETE", "/backends/test-1", "", "secret")
+	require.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = doRequest(t, srv, "POST", "/backends/test-1/reboot", "", "secret")
+	require.Equal(t, http.StatusNotFound, code)
+	code, _ = doRequest(t, srv, "PUT", "/selection", "", "secret")
+	require.Equal(t, http.StatusMethodNotAllowed, code)
+}
+
+func TestServer_StartListenError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	srv := NewServer(&config.AdminConfig{ListenAddr: listener.Addr().String(), AuthToken: "secret"}, nil)
+	require.Error(t, srv.Start())
+}
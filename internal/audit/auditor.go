@@ -1,10 +1,31 @@
 package audit
 
 import (
-	"github.com/kyokan/chaind/pkg"
+	"encoding/json"
 	"net/http"
+	"time"
 )
 
+type BroadcastResult struct {
+	Backend  string
+	Accepted bool
+	Error    string
+}
+
+// Record describes a JSON-RPC request once its response has been written.
+type Record struct {
+	RequestID    string
+	Method       string
+	Params       json.RawMessage
+	Backend      string
+	Cached       bool
+	ErrorCode    int
+	Error        string
+	ResponseSize int
+	Latency      time.Duration
+}
+
 type Auditor interface {
-	RecordRequest(req *http.Request, body []byte, reqType pkg.BackendType) error
+	RecordRequest(req *http.Request, record *Record) error
+	RecordBroadcast(req *http.Request, txHash string, results []BroadcastResult) error
 }
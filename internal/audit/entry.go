@@ -0,0 +1,135 @@
+package audit
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+	"github.com/inconshreveable/log15"
+	"github.com/kyokan/chaind/pkg/log"
+)
+
+const (
+	KindRequest   = "request"
+	KindBroadcast = "broadcast"
+)
+
+const (
+	requestMsg   = "completed JSON-RPC request"
+	broadcastMsg = "broadcast raw transaction"
+)
+
+// Entry is a request or broadcast record along with the details of the HTTP
+// request it came from, captured when it was recorded so that sinks can
+// write it later.
+type Entry struct {
+	Record
+	Time       time.Time
+	Kind       string
+	RemoteAddr string
+	UserAgent  string
+	APIKey     string
+	TxHash     string
+	Results    []BroadcastResult
+}
+
+func newRequestEntry(req *http.Request, record *Record, apiKeyHeader string, redactor *Redactor) *Entry {
+	entry := newEntry(req, KindRequest, apiKeyHeader)
+	entry.Record = *record
+	entry.Params = redactor.Redact(record.Method, record.Params)
+	return entry
+}
+
+func newBroadcastEntry(req *http.Request, txHash string, results []BroadcastResult, apiKeyHeader string) *Entry {
+	entry := newEntry(req, KindBroadcast, apiKeyHeader)
+	entry.RequestID, _ = req.Context().Value(log.RequestIDKey).(string)
+	entry.Method = "eth_sendRawTransaction"
+	entry.TxHash = txHash
+	entry.Results = results
+	return entry
+}
+
+func newEntry(req *http.Request, kind string, apiKeyHeader string) *Entry {
+	return &Entry{
+		Time:       time.Now(),
+		Kind:       kind,
+		RemoteAddr: remoteAddr(req),
+		UserAgent:  req.Header.Get("user-agent"),
+		APIKey:     req.Header.Get(apiKeyHeader),
+	}
+}
+
+// Message returns a human-readable description of the entry.
+func (e *Entry) Message() string {
+	if e.Kind == KindBroadcast {
+		return broadcastMsg
+	}
+	return requestMsg
+}
+
+// Fields returns the entry as alternating keys and values, in the order
+// they should be written.
+func (e *Entry) Fields() []interface{} {
+	if e.Kind == KindBroadcast {
+		fields := []interface{}{
+			"request_id", e.RequestID,
+			"remote_addr", e.RemoteAddr,
+			"user_agent", e.UserAgent,
+			"api_key", e.APIKey,
+			"tx_hash", e.TxHash,
+		}
+		var accepted int
+		for _, res := range e.Results {
+			status := "accepted"
+			if res.Accepted {
+				accepted++
+			} else {
+				status = fmt.Sprintf("rejected: %s", res.Error)
+			}
+			fields = append(fields, fmt.Sprintf("backend_%s", res.Backend), status)
+		}
+		return append(fields, "accepted_count", accepted, "backend_count", len(e.Results))
+	}
+
+	cache := "miss"
+	if e.Cached {
+		cache = "hit"
+	}
+	params := string(e.Params)
+	if params == "" {
+		params = "[]"
+	}
+	return []interface{}{
+		"request_id", e.RequestID,
+		"remote_addr", e.RemoteAddr,
+		"user_agent", e.UserAgent,
+		"api_key", e.APIKey,
+		"rpc_method", e.Method,
+		"rpc_params", params,
+		"backend", e.Backend,
+		"cache", cache,
+		"error_code", e.ErrorCode,
+		"error", e.Error,
+		"response_size", e.ResponseSize,
+		"latency_ms", e.LatencyMS(),
+	}
+}
+
+func (e *Entry) LatencyMS() float64 {
+	return float64(e.Latency) / float64(time.Millisecond)
+}
+
+// logRecord returns the entry as a log15 record stamped with the time it
+// was recorded rather than the time it is written.
+func (e *Entry) logRecord() *log15.Record {
+	return &log15.Record{
+		Time: e.Time,
+		Lvl:  log15.LvlInfo,
+		Msg:  e.Message(),
+		Ctx:  e.Fields(),
+		KeyNames: log15.RecordKeyNames{
+			Time: "t",
+			Msg:  "msg",
+			Lvl:  "lvl",
+		},
+	}
+}
@@ -0,0 +1,173 @@
+package audit
+
+import (
+	"net/http"
+	"github.com/inconshreveable/log15"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/kyokan/chaind/pkg/log"
+	"github.com/kyokan/chaind/pkg/metrics"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// DefaultBufferSize is the default number of entries buffered in memory
+// before new entries are dropped.
+const DefaultBufferSize = 10000
+
+// maxWriteBatch is the maximum number of entries passed to a sink at once.
+const maxWriteBatch = 100
+
+var droppedEntries = promauto.NewCounter(prometheus.CounterOpts{
+	Name:      "eth_audit_dropped_entries",
+	Subsystem: metrics.Subsystem,
+})
+
+var sinkErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name:      "eth_audit_sink_errors",
+	Subsystem: metrics.Subsystem,
+}, []string{"sink_type"})
+
+// FanOutAuditor writes audit entries to every configured sink. Entries are
+// buffered and written in the background, so recording never blocks the
+// request path; if the buffer fills up because a sink can't keep up, new
+// entries are dropped.
+type FanOutAuditor struct {
+	sinks        []Sink
+	sinkTypes    []string
+	apiKeyHeader string
+	redactor     *Redactor
+	entries      chan *Entry
+	quitChan     chan bool
+	doneChan     chan bool
+	logger       log15.Logger
+}
+
+// NewAuditor returns the auditor described by cfg, preferring the [audit]
+// sinks over the older [log_auditor] file.
+func NewAuditor(cfg *config.Config) (Auditor, error) {
+	redactor := NewRedactor(cfg.Redactions)
+	if cfg.AuditConfig == nil {
+		return NewLogAuditor(cfg.LogAuditorConfig, redactor)
+	}
+	if cfg.LogAuditorConfig != nil {
+		log.NewLog("audit").Warn("ignoring log_auditor config in favor of audit sinks")
+	}
+
+	sinks := make([]Sink, 0, len(cfg.AuditConfig.Sinks))
+	types := make([]string, 0, len(cfg.AuditConfig.Sinks))
+	for _, sinkCfg := range cfg.AuditConfig.Sinks {
+		sink, err := NewSink(sinkCfg)
+		if err != nil {
+			for _, s := range sinks {
+				s.Close()
+			}
+			return nil, errors.Wrapf(err, "failed to create %s audit sink", sinkCfg.Type)
+		}
+		sinks = append(sinks, sink)
+		types = append(types, sinkCfg.Type)
+	}
+
+	f := NewFanOutAuditor(sinks, cfg.AuditConfig.BufferSize, cfg.AuditConfig.APIKeyHeader, redactor)
+	f.sinkTypes = types
+	return f, nil
+}
+
+func NewFanOutAuditor(sinks []Sink, bufferSize int, apiKeyHeader string, redactor *Redactor) *FanOutAuditor {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+
+	types := make([]string, len(sinks))
+	for i := range types {
+		types[i] = "custom"
+	}
+
+	return &FanOutAuditor{
+		sinks:        sinks,
+		sinkTypes:    types,
+		apiKeyHeader: apiKeyHeaderOrDefault(apiKeyHeader),
+		redactor:     redactorOrDefault(redactor),
+		entries:      make(chan *Entry, bufferSize),
+		quitChan:     make(chan bool),
+		doneChan:     make(chan bool),
+		logger:       log.NewLog("audit"),
+	}
+}
+
+func (f *FanOutAuditor) Start() error {
+	go f.drain()
+	f.logger.Info("started", "sink_count", len(f.sinks))
+	return nil
+}
+
+// Stop writes any buffered entries and closes every sink.
+func (f *FanOutAuditor) Stop() error {
+	f.quitChan <- true
+	<-f.doneChan
+	return nil
+}
+
+func (f *FanOutAuditor) RecordRequest(req *http.Request, record *Record) error {
+	requestCount.WithLabelValues(record.Method).Add(1)
+	f.enqueue(newRequestEntry(req, record, f.apiKeyHeader, f.redactor))
+	return nil
+}
+
+func (f *FanOutAuditor) RecordBroadcast(req *http.Request, txHash string, results []BroadcastResult) error {
+	f.enqueue(newBroadcastEntry(req, txHash, results, f.apiKeyHeader))
+	return nil
+}
+
+func (f *FanOutAuditor) enqueue(entry *Entry) {
+	select {
+	case f.entries <- entry:
+	default:
+		droppedEntries.Inc()
+		f.logger.Warn("audit buffer full, dropping entry", "kind", entry.Kind, "request_id", entry.RequestID)
+	}
+}
+
+func (f *FanOutAuditor) drain() {
+	for {
+		select {
+		case entry := <-f.entries:
+			f.write(f.batch(entry))
+		case <-f.quitChan:
+			for len(f.entries) > 0 {
+				f.write(f.batch(<-f.entries))
+			}
+			for i, sink := range f.sinks {
+				if err := sink.Close(); err != nil {
+					f.logger.Error("failed to close audit sink", "sink_type", f.sinkTypes[i], "err", err)
+				}
+			}
+			f.doneChan <- true
+			return
+		}
+	}
+}
+
+// batch returns entry along with any other buffered entries, up to
+// maxWriteBatch.
+func (f *FanOutAuditor) batch(entry *Entry) []*Entry {
+	batch := []*Entry{entry}
+	for len(batch) < maxWriteBatch {
+		select {
+		case next := <-f.entries:
+			batch = append(batch, next)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+func (f *FanOutAuditor) write(batch []*Entry) {
+	for i, sink := range f.sinks {
+		if err := sink.Write(batch); err != nil {
+			sinkErrors.WithLabelValues(f.sinkTypes[i]).Inc()
+			f.logger.Error("failed to write audit entries", "sink_type", f.sinkTypes[i], "entry_count", len(batch), "err", err)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package audit
+
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"github.com/stretchr/testify/require"
+)
+
+type memSink struct {
+	entries []*Entry
+	batches int
+	closed  bool
+	started chan bool
+	block   chan bool
+	mtx     sync.Mutex
+}
+
+func (m *memSink) Write(entries []*Entry) error {
+	if m.block != nil {
+		select {
+		case m.started <- true:
+		default:
+		}
+		<-m.block
+	}
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.entries = append(m.entries, entries...)
+	m.batches++
+	return nil
+}
+
+func (m *memSink) Close() error {
+	m.closed = true
+	return nil
+}
+
+func TestFanOutAuditor(t *testing.T) {
+	a := &memSink{}
+	b := &memSink{started: make(chan bool), block: make(chan bool)}
+	auditor := NewFanOutAuditor([]Sink{a, b}, 3, "", nil)
+	require.NoError(t, auditor.Start())
+
+	req := httptest.NewRequest("POST", "/eth", nil)
+	req.Header.Set(DefaultAPIKeyHeader, "secret-key")
+	require.NoError(t, auditor.RecordRequest(req, &Record{Method: "eth_chainId"}))
+	// the second sink is now blocked, so only the next three entries fit
+	// in the buffer
+	<-b.started
+	require.NoError(t, auditor.RecordBroadcast(req, "0xabc", []BroadcastResult{{Backend: "local", Accepted: true}}))
+	for i := 0; i < 10; i++ {
+		require.NoError(t, auditor.RecordRequest(req, &Record{Method: "eth_blockNumber"}))
+	}
+	close(b.block)
+	require.NoError(t, auditor.Stop())
+
+	require.True(t, a.closed)
+	require.True(t, b.closed)
+	require.Equal(t, a.entries, b.entries)
+	require.Len(t, a.entries, 4)
+	require.Equal(t, 2, a.batches)
+	require.Equal(t, "eth_chainId", a.entries[0].Method)
+	require.Equal(t, "secret-key", a.entries[0].APIKey)
+	require.Equal(t, KindBroadcast, a.entries[1].Kind)
+	require.Equal(t, "0xabc", a.entries[1].TxHash)
+	require.Equal(t, "eth_blockNumber", a.entries[3].Method)
+}
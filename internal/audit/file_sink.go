@@ -0,0 +1,81 @@
+package audit
+
+import (
+	"time"
+	"github.com/inconshreveable/log15"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/kyokan/chaind/pkg/log"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// DefaultMaxSizeMB is the size a file sink's log grows to before it is
+// rotated, unless configured otherwise.
+const DefaultMaxSizeMB = 100
+
+// FileSink writes entries as logfmt or JSON lines to a file that is rotated
+// when it reaches a maximum size and, optionally, on a fixed interval.
+// Rotated files can be compressed and are deleted once they are older or
+// more numerous than the configured limits.
+type FileSink struct {
+	file     *lumberjack.Logger
+	handler  log15.Handler
+	quitChan chan bool
+	logger   log15.Logger
+}
+
+func NewFileSink(cfg config.AuditSink) (*FileSink, error) {
+	maxSize := cfg.MaxSizeMB
+	if maxSize == 0 {
+		maxSize = DefaultMaxSizeMB
+	}
+
+	file := &lumberjack.Logger{
+		Filename:   cfg.Path,
+		MaxSize:    maxSize,
+		MaxAge:     cfg.MaxAgeDays,
+		MaxBackups: cfg.MaxBackups,
+		Compress:   cfg.Compress,
+		LocalTime:  true,
+	}
+	s := &FileSink{
+		file:     file,
+		handler:  log15.StreamHandler(file, logFormat(cfg.Format)),
+		quitChan: make(chan bool),
+		logger:   log.NewLog("audit_file_sink"),
+	}
+
+	if cfg.RotateEverySecs > 0 {
+		go s.rotateEvery(time.Duration(cfg.RotateEverySecs) * time.Second)
+	}
+	return s, nil
+}
+
+func (s *FileSink) Write(entries []*Entry) error {
+	for _, entry := range entries {
+		if err := s.handler.Log(entry.logRecord()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *FileSink) Close() error {
+	close(s.quitChan)
+	return s.file.Close()
+}
+
+func (s *FileSink) rotateEvery(interval time.Duration) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-tick.C:
+			if err := s.file.Rotate(); err != nil {
+				s.logger.Error("failed to rotate audit log", "path", s.file.Filename, "err", err)
+			}
+		case <-s.quitChan:
+			return
+		}
+	}
+}
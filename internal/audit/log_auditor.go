@@ -3,76 +3,66 @@ package audit
 import (
 	"github.com/inconshreveable/log15"
 	"github.com/kyokan/chaind/pkg/config"
-	"github.com/kyokan/chaind/pkg"
-	"encoding/json"
 	"github.com/pkg/errors"
 	"net/http"
-	"github.com/kyokan/chaind/pkg/jsonrpc"
-	"github.com/kyokan/chaind/pkg/log"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/kyokan/chaind/pkg/metrics"
 )
 
-type LogAuditor struct {
-	logger log15.Logger
+const (
+	FormatLogfmt = "logfmt"
+	FormatJSON   = "json"
+)
 
-	requestCount *prometheus.CounterVec
-}
+const DefaultAPIKeyHeader = "X-Api-Key"
 
-func NewLogAuditor(cfg *config.LogAuditorConfig) (Auditor, error) {
+var requestCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name:      "eth_audit_rpc_request_count",
+	Subsystem: metrics.Subsystem,
+}, []string{"method_name"})
+
+// NewLogAuditor returns an auditor that writes to the [log_auditor] file. The
+// file is written through a file sink like any other, so that recording never
+// blocks the request path.
+func NewLogAuditor(cfg *config.LogAuditorConfig, redactor *Redactor) (Auditor, error) {
 	if cfg == nil {
 		return nil, errors.New("no log auditor config defined")
 	}
 
-	logger := log15.New()
-	hdlr, err := log15.FileHandler(cfg.LogFile, log15.LogfmtFormat())
+	sink, err := NewFileSink(config.AuditSink{
+		Type:   config.AuditSinkFile,
+		Path:   cfg.LogFile,
+		Format: cfg.Format,
+	})
 	if err != nil {
 		return nil, err
 	}
-	logger.SetHandler(hdlr)
 
-	return &LogAuditor{
-		logger: logger,
-		requestCount: promauto.NewCounterVec(prometheus.CounterOpts{
-			Name:      "eth_audit_rpc_request_count",
-			Subsystem: metrics.Subsystem,
-		}, []string{"method_name"}),
-	}, nil
+	f := NewFanOutAuditor([]Sink{sink}, 0, cfg.APIKeyHeader, redactor)
+	f.sinkTypes = []string{config.AuditSinkFile}
+	return f, nil
 }
 
-func (l *LogAuditor) RecordRequest(req *http.Request, body []byte, reqType pkg.BackendType) error {
-	if reqType == pkg.EthBackend {
-		return l.recordETHRequest(req, body)
+func logFormat(format string) log15.Format {
+	if format == FormatJSON {
+		return log15.JsonFormat()
 	}
-
-	return nil
+	return log15.LogfmtFormat()
 }
 
-func (l *LogAuditor) recordETHRequest(req *http.Request, body []byte) error {
-	logger := log.WithContext(l.logger.New("remote_addr", remoteAddr(req), "user_agent", req.Header.Get("user-agent")), req.Context())
-	var rpcReq jsonrpc.Request
-	err := json.Unmarshal(body, &rpcReq)
-	if err != nil {
-		logger.Error(
-			"received request with invalid JSON body",
-			"type",
-			pkg.EthBackend,
-		)
-		return nil
+func redactorOrDefault(redactor *Redactor) *Redactor {
+	if redactor == nil {
+		return NewRedactor(nil)
 	}
+	return redactor
+}
 
-	params, err := json.Marshal(rpcReq.Params)
-	if err != nil {
-		return err
+func apiKeyHeaderOrDefault(header string) string {
+	if header == "" {
+		return DefaultAPIKeyHeader
 	}
-	l.requestCount.WithLabelValues(rpcReq.Method).Add(1)
-	logger.Info(
-		"received JSON-RPC request",
-		"rpc_method", rpcReq.Method,
-		"rpc_params", string(params),
-	)
-	return nil
+	return header
 }
 
 func remoteAddr(req *http.Request) string {
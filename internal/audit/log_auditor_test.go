@@ -0,0 +1,69 @@
+package audit
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogAuditor_RecordRequest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chaind-audit")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, format := range []string{FormatLogfmt, FormatJSON} {
+		logFile := path.Join(dir, format+".log")
+		auditor, err := NewLogAuditor(&config.LogAuditorConfig{
+			LogFile: logFile,
+			Format:  format,
+		}, nil)
+		require.NoError(t, err)
+		require.NoError(t, auditor.(*FanOutAuditor).Start())
+
+		req := httptest.NewRequest("POST", "/eth", nil)
+		req.Header.Set(DefaultAPIKeyHeader, "secret-key")
+		require.NoError(t, auditor.RecordRequest(req, &Record{
+			RequestID:    "abc",
+			Method:       "eth_getBalance",
+			Params:       json.RawMessage(`["0x01","latest"]`),
+			Backend:      "local",
+			ErrorCode:    -32602,
+			Error:        "bad request",
+			ResponseSize: 72,
+			Latency:      1500 * time.Microsecond,
+		}))
+		// entries are written in the background, and flushed on stop
+		require.NoError(t, auditor.(*FanOutAuditor).Stop())
+
+		out, err := ioutil.ReadFile(logFile)
+		require.NoError(t, err)
+		if format == FormatLogfmt {
+			line := string(out)
+			require.True(t, strings.Contains(line, "request_id=abc"), line)
+			require.True(t, strings.Contains(line, "api_key=secret-key"), line)
+			require.True(t, strings.Contains(line, "cache=miss"), line)
+			require.True(t, strings.Contains(line, "latency_ms=1.500"), line)
+			continue
+		}
+
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal(out, &entry))
+		require.Equal(t, "abc", entry["request_id"])
+		require.Equal(t, "secret-key", entry["api_key"])
+		require.Equal(t, "eth_getBalance", entry["rpc_method"])
+		require.Equal(t, `["0x01","latest"]`, entry["rpc_params"])
+		require.Equal(t, "local", entry["backend"])
+		require.Equal(t, "miss", entry["cache"])
+		require.Equal(t, float64(-32602), entry["error_code"])
+		require.Equal(t, "bad request", entry["error"])
+		require.Equal(t, float64(72), entry["response_size"])
+		require.Equal(t, 1.5, entry["latency_ms"])
+	}
+}
@@ -0,0 +1,214 @@
+package audit
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	AggregateMethods   = "methods"
+	AggregateClients   = "clients"
+	AggregatePerMinute = "per_minute"
+)
+
+// Query filters the requests in an audit store. Zero values match
+// everything. UserAgent matches any user agent containing it.
+type Query struct {
+	Since      time.Time
+	Until      time.Time
+	RemoteAddr string
+	UserAgent  string
+	Method     string
+	APIKey     string
+	Limit      int
+}
+
+// Count is the number of requests for one key of an aggregation.
+type Count struct {
+	Key   string
+	Count int
+}
+
+// Store reads the requests written by a SQLite sink.
+type Store struct {
+	db *sql.DB
+}
+
+func OpenStore(path string) (*Store, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	db, err := OpenSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{
+		db: db,
+	}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Requests returns the requests matching q, oldest first. If q has a limit,
+// they are the most recent requests that match.
+func (s *Store) Requests(q *Query) ([]*Entry, error) {
+	where, args := q.where()
+	order := "time, id"
+	if q.Limit > 0 {
+		order = "time DESC, id DESC"
+	}
+	rows, err := s.db.Query(
+		`SELECT time, request_id, remote_addr, user_agent, api_key, method, params, backend, cached, error_code, error, response_size, latency_ms
+		FROM requests`+where+` ORDER BY `+order+q.limit(),
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*Entry
+	for rows.Next() {
+		var ts int64
+		var params string
+		var latency float64
+		entry := &Entry{
+			Kind: KindRequest,
+		}
+		err := rows.Scan(
+			&ts,
+			&entry.RequestID,
+			&entry.RemoteAddr,
+			&entry.UserAgent,
+			&entry.APIKey,
+			&entry.Method,
+			&params,
+			&entry.Backend,
+			&entry.Cached,
+			&entry.ErrorCode,
+			&entry.Error,
+			&entry.ResponseSize,
+			&latency,
+		)
+		if err != nil {
+			return nil, err
+		}
+		entry.Time = time.Unix(0, ts*int64(time.Millisecond))
+		entry.Params = []byte(params)
+		entry.Latency = time.Duration(latency * float64(time.Millisecond))
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if q.Limit > 0 {
+		for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+			entries[i], entries[j] = entries[j], entries[i]
+		}
+	}
+	return entries, nil
+}
+
+// Aggregate counts the requests matching q by method, by client or by
+// minute. Methods and clients are returned busiest first, and minutes in
+// chronological order as UTC timestamps. If q has a limit, they are the most
+// recent minutes.
+func (s *Store) Aggregate(q *Query, by string) ([]Count, error) {
+	var key, order string
+	switch by {
+	case AggregateMethods:
+		key, order = "method", "count DESC, key"
+	case AggregateClients:
+		key, order = "remote_addr", "count DESC, key"
+	case AggregatePerMinute:
+		key, order = "time / 60000", "key"
+		if q.Limit > 0 {
+			order = "key DESC"
+		}
+	default:
+		return nil, fmt.Errorf("invalid aggregation: %s", by)
+	}
+
+	where, args := q.where()
+	rows, err := s.db.Query(
+		fmt.Sprintf("SELECT %s AS key, COUNT(*) AS count FROM requests%s GROUP BY key ORDER BY %s%s", key, where, order, q.limit()),
+		args...,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []Count
+	for rows.Next() {
+		var count Count
+		if by == AggregatePerMinute {
+			var minute int64
+			if err := rows.Scan(&minute, &count.Count); err != nil {
+				return nil, err
+			}
+			count.Key = time.Unix(minute*60, 0).UTC().Format("2006-01-02T15:04Z")
+		} else if err := rows.Scan(&count.Key, &count.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if by == AggregatePerMinute && q.Limit > 0 {
+		for i, j := 0, len(counts)-1; i < j; i, j = i+1, j-1 {
+			counts[i], counts[j] = counts[j], counts[i]
+		}
+	}
+	return counts, nil
+}
+
+func (q *Query) where() (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	if !q.Since.IsZero() {
+		conds = append(conds, "time >= ?")
+		args = append(args, q.Since.UnixNano()/int64(time.Millisecond))
+	}
+	if !q.Until.IsZero() {
+		conds = append(conds, "time < ?")
+		args = append(args, q.Until.UnixNano()/int64(time.Millisecond))
+	}
+	if q.RemoteAddr != "" {
+		conds = append(conds, "remote_addr = ?")
+		args = append(args, q.RemoteAddr)
+	}
+	if q.UserAgent != "" {
+		conds = append(conds, "instr(user_agent, ?) > 0")
+		args = append(args, q.UserAgent)
+	}
+	if q.Method != "" {
+		conds = append(conds, "method = ?")
+		args = append(args, q.Method)
+	}
+	if q.APIKey != "" {
+		conds = append(conds, "api_key = ?")
+		args = append(args, q.APIKey)
+	}
+
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+func (q *Query) limit() string {
+	if q.Limit <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(" LIMIT %d", q.Limit)
+}
@@ -0,0 +1,98 @@
+package audit
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chaind-audit")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	dbPath := path.Join(dir, "audit.db")
+	_, err = OpenStore(dbPath)
+	require.Error(t, err)
+
+	sink, err := NewSQLiteSink(config.AuditSink{Type: config.AuditSinkSQLite, Path: dbPath})
+	require.NoError(t, err)
+	start := time.Date(2018, 10, 1, 12, 0, 0, 0, time.UTC)
+	var entries []*Entry
+	for i := 0; i < 6; i++ {
+		entry := &Entry{
+			Record: Record{
+				RequestID: "abcdef"[i : i+1],
+				Method:    "eth_blockNumber",
+				Latency:   time.Millisecond,
+			},
+			Time:       start.Add(time.Duration(i) * 30 * time.Second),
+			Kind:       KindRequest,
+			RemoteAddr: "10.0.0.1",
+			UserAgent:  "Go-http-client/1.1",
+		}
+		if i%3 == 0 {
+			entry.Method = "eth_call"
+			entry.RemoteAddr = "10.0.0.2"
+			entry.UserAgent = "web3.js/1.0"
+			entry.APIKey = "partner"
+		}
+		entries = append(entries, entry)
+	}
+	require.NoError(t, sink.Write(entries))
+	require.NoError(t, sink.Close())
+
+	store, err := OpenStore(dbPath)
+	require.NoError(t, err)
+	defer store.Close()
+
+	res, err := store.Requests(&Query{})
+	require.NoError(t, err)
+	require.Len(t, res, 6)
+	require.Equal(t, "a", res[0].RequestID)
+	require.True(t, start.Equal(res[0].Time))
+	require.Equal(t, time.Millisecond, res[0].Latency)
+
+	res, err = store.Requests(&Query{
+		Since:     start.Add(time.Minute),
+		UserAgent: "web3",
+	})
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	require.Equal(t, "d", res[0].RequestID)
+
+	res, err = store.Requests(&Query{APIKey: "partner", Until: start.Add(time.Minute), Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, res, 1)
+	require.Equal(t, "eth_call", res[0].Method)
+
+	// limits keep the most recent requests, still oldest first
+	res, err = store.Requests(&Query{Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, res, 2)
+	require.Equal(t, "e", res[0].RequestID)
+	require.Equal(t, "f", res[1].RequestID)
+
+	counts, err := store.Aggregate(&Query{}, AggregateMethods)
+	require.NoError(t, err)
+	require.Equal(t, []Count{{"eth_blockNumber", 4}, {"eth_call", 2}}, counts)
+
+	counts, err = store.Aggregate(&Query{Method: "eth_blockNumber", Limit: 1}, AggregateClients)
+	require.NoError(t, err)
+	require.Equal(t, []Count{{"10.0.0.1", 4}}, counts)
+
+	counts, err = store.Aggregate(&Query{RemoteAddr: "10.0.0.1"}, AggregatePerMinute)
+	require.NoError(t, err)
+	require.Equal(t, []Count{{"2018-10-01T12:00Z", 1}, {"2018-10-01T12:01Z", 1}, {"2018-10-01T12:02Z", 2}}, counts)
+
+	counts, err = store.Aggregate(&Query{RemoteAddr: "10.0.0.1", Limit: 2}, AggregatePerMinute)
+	require.NoError(t, err)
+	require.Equal(t, []Count{{"2018-10-01T12:01Z", 1}, {"2018-10-01T12:02Z", 2}}, counts)
+
+	_, err = store.Aggregate(&Query{}, "hours")
+	require.Error(t, err)
+}
@@ -0,0 +1,141 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"path"
+	"github.com/kyokan/chaind/pkg/config"
+)
+
+// RedactedValue replaces params removed by the drop action.
+const RedactedValue = `"[redacted]"`
+
+// DefaultRedactions keep passwords, private keys and signing payloads out of
+// audit records. Typed data is hashed as a whole, since wallets disagree on
+// the order of its params.
+var DefaultRedactions = []config.Redaction{
+	{Method: "personal_*", Action: config.RedactDrop},
+	{Method: "eth_sign", Params: []int{1}, Action: config.RedactHash},
+	{Method: "eth_signTransaction", Params: []int{0}, Action: config.RedactHash},
+	{Method: "eth_signTypedData*", Action: config.RedactHash},
+	{Method: "eth_sendTransaction", Params: []int{0}, Action: config.RedactHash},
+	{Method: "eth_sendRawTransaction", Params: []int{0}, Action: config.RedactTruncate, MaxLength: 66},
+}
+
+// Redactor rewrites the params of audited requests according to per-method
+// redactions. The defaults are applied first, followed by every configured
+// redaction matching the method, in order. A default is only replaced by
+// configured redactions that name the method itself or the default's own
+// pattern, so broad patterns like "*" can't turn the defaults off.
+type Redactor struct {
+	rules []config.Redaction
+}
+
+func NewRedactor(rules []config.Redaction) *Redactor {
+	return &Redactor{
+		rules: rules,
+	}
+}
+
+// Redact returns a copy of params with the method's redactions applied.
+func (r *Redactor) Redact(method string, params json.RawMessage) json.RawMessage {
+	configured := matchingRedactions(r.rules, method)
+	var rules []config.Redaction
+	for _, rule := range matchingRedactions(DefaultRedactions, method) {
+		if !overridesDefault(configured, method, rule) {
+			rules = append(rules, rule)
+		}
+	}
+	rules = append(rules, configured...)
+	if len(rules) == 0 || len(params) == 0 {
+		return params
+	}
+
+	var values []json.RawMessage
+	if err := json.Unmarshal(params, &values); err != nil {
+		// params that aren't positional are redacted as a whole
+		for _, rule := range rules {
+			params = redactValue(rule, params)
+		}
+		return params
+	}
+
+	for _, rule := range rules {
+		if len(rule.Params) == 0 {
+			for i := range values {
+				values[i] = redactValue(rule, values[i])
+			}
+			continue
+		}
+		for _, i := range rule.Params {
+			if i < len(values) {
+				values[i] = redactValue(rule, values[i])
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i, value := range values {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte(']')
+	return buf.Bytes()
+}
+
+func matchingRedactions(rules []config.Redaction, method string) []config.Redaction {
+	var matching []config.Redaction
+	for _, rule := range rules {
+		if ok, _ := path.Match(rule.Method, method); ok {
+			matching = append(matching, rule)
+		}
+	}
+	return matching
+}
+
+// overridesDefault returns whether a configured redaction replaces the default
+// for the method.
+func overridesDefault(configured []config.Redaction, method string, def config.Redaction) bool {
+	for _, rule := range configured {
+		if rule.Method == method || rule.Method == def.Method {
+			return true
+		}
+	}
+	return false
+}
+
+func redactValue(rule config.Redaction, value json.RawMessage) json.RawMessage {
+	switch rule.Action {
+	case config.RedactDrop:
+		return json.RawMessage(RedactedValue)
+	case config.RedactHash:
+		var compact bytes.Buffer
+		if err := json.Compact(&compact, value); err != nil {
+			compact.Reset()
+			compact.Write(value)
+		}
+		sum := sha256.Sum256(compact.Bytes())
+		return quote("sha256:" + hex.EncodeToString(sum[:]))
+	case config.RedactTruncate:
+		var str string
+		if err := json.Unmarshal(value, &str); err != nil {
+			str = string(value)
+		}
+		if len(str) <= rule.MaxLength {
+			return value
+		}
+		return quote(str[:rule.MaxLength] + "...")
+	default:
+		return value
+	}
+}
+
+func quote(str string) json.RawMessage {
+	out, _ := json.Marshal(str)
+	return out
+}
@@ -0,0 +1,72 @@
+package audit
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestRedactor_Defaults(t *testing.T) {
+	r := NewRedactor(nil)
+
+	out := r.Redact("personal_unlockAccount", json.RawMessage(`["0x9d8a62f656a8d1615c1294fd71e9cfb3e4855a4f", "hunter2", 300]`))
+	require.Equal(t, `["[redacted]","[redacted]","[redacted]"]`, string(out))
+
+	out = r.Redact("eth_sign", json.RawMessage(`["0x9d8a62f656a8d1615c1294fd71e9cfb3e4855a4f","0xdeadbeef"]`))
+	require.Equal(t, `["0x9d8a62f656a8d1615c1294fd71e9cfb3e4855a4f","sha256:cfac8c4906cac5a798fb1491b670782504c90f09b643760867e9697a57974a82"]`, string(out))
+
+	raw := `"0xf86b8085e8d4a510008227109413978aee95f38490e9769c39b2773ed763d9cd5f872386f26fc10000801ba0f9f0d6d2c9f5d4c5c4f4b0e3d3a5d0f0b6f0a9f6c7d1a5f0e4d2c9f5d4c5c4f4b"`
+	out = r.Redact("eth_sendRawTransaction", json.RawMessage("["+raw+"]"))
+	require.Equal(t, `["0xf86b8085e8d4a510008227109413978aee95f38490e9769c39b2773ed763d9cd..."]`, string(out))
+
+	out = r.Redact("eth_sendTransaction", json.RawMessage(`[{"from":"0x01","data":"0xdeadbeef"}]`))
+	require.True(t, strings.HasPrefix(gjson.GetBytes(out, "0").String(), "sha256:"), string(out))
+	out = r.Redact("eth_signTypedData_v4", json.RawMessage(`["0x01",{"message":"secret"}]`))
+	require.True(t, strings.HasPrefix(gjson.GetBytes(out, "1").String(), "sha256:"), string(out))
+
+	params := json.RawMessage(`["0x1", true]`)
+	require.Equal(t, params, r.Redact("eth_getBlockByNumber", params))
+}
+
+func TestRedactor_Wildcard(t *testing.T) {
+	r := NewRedactor([]config.Redaction{
+		{Method: "*", Action: config.RedactTruncate, MaxLength: 10},
+	})
+
+	// broad redactions are applied on top of the defaults
+	out := r.Redact("personal_unlockAccount", json.RawMessage(`["0x9d8a62f656a8d1615c1294fd71e9cfb3e4855a4f", "hunter2", 300]`))
+	require.Equal(t, `["[redacted]","[redacted]","[redacted]"]`, string(out))
+	out = r.Redact("eth_getBalance", json.RawMessage(`["0x9d8a62f656a8d1615c1294fd71e9cfb3e4855a4f","latest"]`))
+	require.Equal(t, `["0x9d8a62f6...","latest"]`, string(out))
+
+	// while naming the default's pattern replaces it
+	r = NewRedactor([]config.Redaction{{Method: "personal_*", Action: config.RedactKeep}})
+	params := json.RawMessage(`["0xdeadbeef","hunter2"]`)
+	require.Equal(t, params, r.Redact("personal_sign", params))
+}
+
+func TestRedactor_Configured(t *testing.T) {
+	r := NewRedactor([]config.Redaction{
+		{Method: "personal_ecRecover", Action: config.RedactKeep},
+		{Method: "eth_call", Params: []int{0, 5}, Action: config.RedactTruncate, MaxLength: 10},
+		{Method: "eth_call", Params: []int{1}, Action: config.RedactDrop},
+		{Method: "custom_*", Action: config.RedactHash},
+	})
+
+	params := json.RawMessage(`["0xdeadbeef","0xsignature"]`)
+	require.Equal(t, params, r.Redact("personal_ecRecover", params))
+	out := r.Redact("personal_sign", json.RawMessage(`["0xdeadbeef","0x9d8a62f656a8d1615c1294fd71e9cfb3e4855a4f","hunter2"]`))
+	require.Equal(t, `["[redacted]","[redacted]","[redacted]"]`, string(out))
+
+	out = r.Redact("eth_call", json.RawMessage(`[{"to": "0x9d8a62f656a8d1615c1294fd71e9cfb3e4855a4f"}, "latest"]`))
+	require.Equal(t, `["{\"to\": \"0x...","[redacted]"]`, string(out))
+
+	// params that aren't an array are redacted as a whole
+	out = r.Redact("custom_method", json.RawMessage(`{"a": 1}`))
+	require.Equal(t, `"sha256:015abd7f5cc57a2dd94b7590f04ad8084273905ee33ec5cebeae62276a97f862"`, string(out))
+	out = r.Redact("custom_method", json.RawMessage(`{"a":1}`))
+	require.Equal(t, `"sha256:015abd7f5cc57a2dd94b7590f04ad8084273905ee33ec5cebeae62276a97f862"`, string(out))
+}
@@ -0,0 +1,27 @@
+package audit
+
+import (
+	"fmt"
+	"github.com/kyokan/chaind/pkg/config"
+)
+
+// Sink writes audit entries to a destination. Sinks are only ever called
+// from a single goroutine, so they don't need to be safe for concurrent use.
+type Sink interface {
+	Write(entries []*Entry) error
+	Close() error
+}
+
+// NewSink returns the sink described by cfg.
+func NewSink(cfg config.AuditSink) (Sink, error) {
+	switch cfg.Type {
+	case config.AuditSinkFile:
+		return NewFileSink(cfg)
+	case config.AuditSinkSyslog:
+		return NewSyslogSink(cfg)
+	case config.AuditSinkSQLite:
+		return NewSQLiteSink(cfg)
+	default:
+		return nil, fmt.Errorf("invalid audit sink type: %s", cfg.Type)
+	}
+}
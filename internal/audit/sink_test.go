@@ -0,0 +1,182 @@
+package audit
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/stretchr/testify/require"
+)
+
+func testEntries() []*Entry {
+	ts := time.Date(2018, 10, 1, 12, 0, 0, 0, time.UTC)
+	return []*Entry{
+		{
+			Record: Record{
+				RequestID:    "abc",
+				Method:       "eth_getBalance",
+				Params:       json.RawMessage(`["0x01","latest"]`),
+				Backend:      "local",
+				Cached:       true,
+				ResponseSize: 72,
+				Latency:      1500 * time.Microsecond,
+			},
+			Time:       ts,
+			Kind:       KindRequest,
+			RemoteAddr: "10.0.0.1",
+			UserAgent:  "web3.js",
+			APIKey:     "secret-key",
+		},
+		{
+			Record: Record{
+				RequestID: "def",
+				Method:    "eth_sendRawTransaction",
+			},
+			Time:       ts.Add(time.Second),
+			Kind:       KindBroadcast,
+			RemoteAddr: "10.0.0.2",
+			TxHash:     "0x1234",
+			Results: []BroadcastResult{
+				{Backend: "local", Accepted: true},
+				{Backend: "infura", Error: "nonce too low"},
+			},
+		},
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chaind-audit")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	logFile := path.Join(dir, "audit.log")
+	sink, err := NewFileSink(config.AuditSink{
+		Type:   config.AuditSinkFile,
+		Path:   logFile,
+		Format: FormatJSON,
+	})
+	require.NoError(t, err)
+	require.NoError(t, sink.Write(testEntries()))
+	require.NoError(t, sink.file.Rotate())
+	require.NoError(t, sink.Write(testEntries()[:1]))
+	require.NoError(t, sink.Close())
+
+	out, err := ioutil.ReadFile(logFile)
+	require.NoError(t, err)
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(out, &entry))
+	require.Equal(t, "abc", entry["request_id"])
+	require.Equal(t, "hit", entry["cache"])
+	require.Equal(t, "2018-10-01T12:00:00Z", entry["t"])
+
+	rotated, err := filepath.Glob(path.Join(dir, "audit-*.log"))
+	require.NoError(t, err)
+	require.Len(t, rotated, 1)
+	out, err = ioutil.ReadFile(rotated[0])
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	require.Len(t, lines, 2)
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+	require.Equal(t, "0x1234", entry["tx_hash"])
+	require.Equal(t, "rejected: nonce too low", entry["backend_infura"])
+	require.Equal(t, float64(1), entry["accepted_count"])
+}
+
+func TestSyslogSink(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	sink, err := NewSyslogSink(config.AuditSink{
+		Type:     config.AuditSinkSyslog,
+		Network:  "udp",
+		Address:  conn.LocalAddr().String(),
+		Facility: "local1",
+	})
+	require.NoError(t, err)
+	defer sink.Close()
+	require.NoError(t, sink.Write(testEntries()))
+
+	buf := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	require.NoError(t, err)
+	msg := string(buf[:n])
+	require.True(t, strings.HasPrefix(msg, "<142>1 2018-10-01T12:00:00.000000Z "), msg)
+	require.True(t, strings.Contains(msg, " chaind "), msg)
+	require.True(t, strings.Contains(msg, ` request [audit@32473 request_id="abc" `), msg)
+	require.True(t, strings.Contains(msg, ` rpc_params="[\"0x01\",\"latest\"\]" `), msg)
+	require.True(t, strings.Contains(msg, ` latency_ms="1.500"] completed JSON-RPC request`), msg)
+
+	n, _, err = conn.ReadFrom(buf)
+	require.NoError(t, err)
+	msg = string(buf[:n])
+	require.True(t, strings.Contains(msg, ` broadcast [audit@32473 request_id="def" `), msg)
+	require.True(t, strings.Contains(msg, ` backend_infura="rejected: nonce too low" `), msg)
+	require.True(t, strings.HasSuffix(msg, "] broadcast raw transaction"), msg)
+}
+
+func TestSDName(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"backend_infura", "backend_infura"},
+		{"backend_my node", "backend_my_node"},
+		{`backend_a=b]"c`, "backend_a_b__c"},
+		{"backend_café", "backend_caf__"},
+		{"backend_" + strings.Repeat("a", 30), "backend_" + strings.Repeat("a", 24)},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.expected, sdName(tt.name), tt.name)
+	}
+}
+
+func TestSQLiteSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chaind-audit")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := config.AuditSink{
+		Type: config.AuditSinkSQLite,
+		Path: path.Join(dir, "audit.db"),
+	}
+	sink, err := NewSQLiteSink(cfg)
+	require.NoError(t, err)
+	require.NoError(t, sink.Write(testEntries()))
+	require.NoError(t, sink.Close())
+
+	// reopening the database keeps existing rows
+	sink, err = NewSQLiteSink(cfg)
+	require.NoError(t, err)
+	defer sink.Close()
+	require.NoError(t, sink.Write(testEntries()[:1]))
+
+	var count int
+	var method, apiKey string
+	var cached bool
+	var latency float64
+	var ts int64
+	require.NoError(t, sink.db.QueryRow("SELECT COUNT(*) FROM requests").Scan(&count))
+	require.Equal(t, 2, count)
+	row := sink.db.QueryRow("SELECT time, method, api_key, cached, latency_ms FROM requests WHERE request_id = ?", "abc")
+	require.NoError(t, row.Scan(&ts, &method, &apiKey, &cached, &latency))
+	require.Equal(t, int64(1538395200000), ts)
+	require.Equal(t, "eth_getBalance", method)
+	require.Equal(t, "secret-key", apiKey)
+	require.True(t, cached)
+	require.Equal(t, 1.5, latency)
+
+	var rejection string
+	require.NoError(t, sink.db.QueryRow("SELECT COUNT(*) FROM broadcasts WHERE tx_hash = ?", "0x1234").Scan(&count))
+	require.Equal(t, 2, count)
+	require.NoError(t, sink.db.QueryRow("SELECT error FROM broadcasts WHERE accepted = 0").Scan(&rejection))
+	require.Equal(t, "nonce too low", rejection)
+}
@@ -0,0 +1,162 @@
+package audit
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/kyokan/chaind/pkg/config"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteSchema stores requests one row per request and broadcasts one row
+// per backend the transaction was sent to. Times are Unix milliseconds.
+var sqliteSchema = []string{
+	`CREATE TABLE IF NOT EXISTS requests (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		time INTEGER NOT NULL,
+		request_id TEXT NOT NULL,
+		remote_addr TEXT NOT NULL,
+		user_agent TEXT NOT NULL,
+		api_key TEXT NOT NULL,
+		method TEXT NOT NULL,
+		params TEXT NOT NULL,
+		backend TEXT NOT NULL,
+		cached INTEGER NOT NULL,
+		error_code INTEGER NOT NULL,
+		error TEXT NOT NULL,
+		response_size INTEGER NOT NULL,
+		latency_ms REAL NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS requests_time ON requests (time)`,
+	`CREATE INDEX IF NOT EXISTS requests_request_id ON requests (request_id)`,
+	`CREATE INDEX IF NOT EXISTS requests_remote_addr ON requests (remote_addr, time)`,
+	`CREATE INDEX IF NOT EXISTS requests_api_key ON requests (api_key, time)`,
+	`CREATE INDEX IF NOT EXISTS requests_method ON requests (method, time)`,
+	`CREATE TABLE IF NOT EXISTS broadcasts (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		time INTEGER NOT NULL,
+		request_id TEXT NOT NULL,
+		remote_addr TEXT NOT NULL,
+		user_agent TEXT NOT NULL,
+		api_key TEXT NOT NULL,
+		tx_hash TEXT NOT NULL,
+		backend TEXT NOT NULL,
+		accepted INTEGER NOT NULL,
+		error TEXT NOT NULL
+	)`,
+	`CREATE INDEX IF NOT EXISTS broadcasts_time ON broadcasts (time)`,
+	`CREATE INDEX IF NOT EXISTS broadcasts_tx_hash ON broadcasts (tx_hash)`,
+}
+
+const insertRequestQuery = `INSERT INTO requests (
+	time, request_id, remote_addr, user_agent, api_key, method, params, backend, cached, error_code, error, response_size, latency_ms
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+const insertBroadcastQuery = `INSERT INTO broadcasts (
+	time, request_id, remote_addr, user_agent, api_key, tx_hash, backend, accepted, error
+) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+// SQLiteSink stores entries in a SQLite database, indexed so that they can
+// be queried by time, request ID, client, API key, method and transaction
+// hash. The database uses write-ahead logging so it can be read while
+// chaind is writing to it.
+type SQLiteSink struct {
+	db *sql.DB
+}
+
+func NewSQLiteSink(cfg config.AuditSink) (*SQLiteSink, error) {
+	db, err := OpenSQLite(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	for _, stmt := range sqliteSchema {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return &SQLiteSink{
+		db: db,
+	}, nil
+}
+
+// OpenSQLite opens the audit database at path.
+func OpenSQLite(path string) (*sql.DB, error) {
+	return sql.Open("sqlite3", fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=5000", path))
+}
+
+// Write inserts entries in a single transaction.
+func (s *SQLiteSink) Write(entries []*Entry) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	requestStmt, err := tx.Prepare(insertRequestQuery)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer requestStmt.Close()
+	broadcastStmt, err := tx.Prepare(insertBroadcastQuery)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer broadcastStmt.Close()
+
+	for _, entry := range entries {
+		if err := insertEntry(requestStmt, broadcastStmt, entry); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteSink) Close() error {
+	return s.db.Close()
+}
+
+func insertEntry(requestStmt *sql.Stmt, broadcastStmt *sql.Stmt, entry *Entry) error {
+	ts := entry.Time.UnixNano() / 1e6
+	if entry.Kind == KindBroadcast {
+		for _, res := range entry.Results {
+			_, err := broadcastStmt.Exec(
+				ts,
+				entry.RequestID,
+				entry.RemoteAddr,
+				entry.UserAgent,
+				entry.APIKey,
+				entry.TxHash,
+				res.Backend,
+				res.Accepted,
+				res.Error,
+			)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	params := string(entry.Params)
+	if params == "" {
+		params = "[]"
+	}
+	_, err := requestStmt.Exec(
+		ts,
+		entry.RequestID,
+		entry.RemoteAddr,
+		entry.UserAgent,
+		entry.APIKey,
+		entry.Method,
+		params,
+		entry.Backend,
+		entry.Cached,
+		entry.ErrorCode,
+		entry.Error,
+		entry.ResponseSize,
+		entry.LatencyMS(),
+	)
+	return err
+}
@@ -0,0 +1,193 @@
+package audit
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"github.com/kyokan/chaind/pkg/config"
+)
+
+const (
+	DefaultSyslogAppName  = "chaind"
+	DefaultSyslogFacility = "local0"
+)
+
+// syslogSDID identifies chaind's structured data element. 32473 is the
+// private enterprise number reserved for documentation by RFC 5612.
+const syslogSDID = "audit@32473"
+
+const syslogSeverityInfo = 6
+
+const syslogDialTimeout = 5 * time.Second
+
+var syslogFacilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+var sdValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// maxSDNameLen is the longest SD-PARAM name RFC 5424 allows.
+const maxSDNameLen = 32
+
+// SyslogSink sends entries to a syslog server as RFC 5424 messages, with
+// the entry's fields as structured data. Messages sent over stream sockets
+// are framed by octet counting as described in RFC 6587. The connection is
+// re-established if a write fails.
+type SyslogSink struct {
+	network  string
+	address  string
+	appName  string
+	hostname string
+	pid      string
+	priority int
+	conn     net.Conn
+}
+
+func NewSyslogSink(cfg config.AuditSink) (*SyslogSink, error) {
+	facility := cfg.Facility
+	if facility == "" {
+		facility = DefaultSyslogFacility
+	}
+	code, ok := syslogFacilities[facility]
+	if !ok {
+		return nil, fmt.Errorf("invalid syslog facility: %s", facility)
+	}
+	appName := cfg.AppName
+	if appName == "" {
+		appName = DefaultSyslogAppName
+	}
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	s := &SyslogSink{
+		network:  cfg.Network,
+		address:  cfg.Address,
+		appName:  appName,
+		hostname: hostname,
+		pid:      strconv.Itoa(os.Getpid()),
+		priority: code*8 + syslogSeverityInfo,
+	}
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SyslogSink) Write(entries []*Entry) error {
+	for _, entry := range entries {
+		msg := s.format(entry)
+		if s.isStream() {
+			msg = append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+		}
+		if err := s.send(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SyslogSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+// send writes msg, reconnecting and retrying once if the write fails.
+func (s *SyslogSink) send(msg []byte) error {
+	if s.conn != nil {
+		if _, err := s.conn.Write(msg); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+
+	if err := s.connect(); err != nil {
+		return err
+	}
+	_, err := s.conn.Write(msg)
+	return err
+}
+
+func (s *SyslogSink) connect() error {
+	conn, err := net.DialTimeout(s.network, s.address, syslogDialTimeout)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	return nil
+}
+
+func (s *SyslogSink) isStream() bool {
+	return s.network == "tcp" || s.network == "unix"
+}
+
+func (s *SyslogSink) format(entry *Entry) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(
+		&buf,
+		"<%d>1 %s %s %s %s %s [%s",
+		s.priority,
+		entry.Time.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		s.hostname,
+		s.appName,
+		s.pid,
+		entry.Kind,
+		syslogSDID,
+	)
+	fields := entry.Fields()
+	for i := 0; i+1 < len(fields); i += 2 {
+		fmt.Fprintf(&buf, " %s=\"%s\"", sdName(fmt.Sprint(fields[i])), sdValueEscaper.Replace(formatValue(fields[i+1])))
+	}
+	buf.WriteString("] ")
+	buf.WriteString(entry.Message())
+	return buf.Bytes()
+}
+
+// sdName makes a field name a valid SD-PARAM name. Names can include backend
+// names, which may contain characters that RFC 5424 doesn't allow in them.
+func sdName(name string) string {
+	out := []byte(name)
+	for i, c := range out {
+		if c <= ' ' || c > '~' || c == '=' || c == ']' || c == '"' {
+			out[i] = '_'
+		}
+	}
+	if len(out) > maxSDNameLen {
+		out = out[:maxSDNameLen]
+	}
+	return string(out)
+}
+
+func formatValue(value interface{}) string {
+	if f, ok := value.(float64); ok {
+		return strconv.FormatFloat(f, 'f', 3, 64)
+	}
+	return fmt.Sprint(value)
+}
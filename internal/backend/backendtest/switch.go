@@ -0,0 +1,120 @@
+// Package backendtest provides a backend.Switcher for tests that don't need
+// healthchecks or failover.
+package backendtest
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"github.com/kyokan/chaind/internal/backend"
+	"github.com/kyokan/chaind/pkg"
+	"github.com/kyokan/chaind/pkg/config"
+)
+
+// Switch is a backend.Switcher that treats every backend as healthy. Backends
+// are assigned to pools by their tags the same way as the real switcher, and
+// the first backend in each pool is the selected one.
+type Switch struct {
+	backends []config.Backend
+	mtx      sync.RWMutex
+}
+
+var _ backend.Switcher = (*Switch)(nil)
+
+// NewSwitch returns a Switch over the given backends. Backends without a type
+// are treated as Ethereum backends.
+func NewSwitch(backends ...config.Backend) *Switch {
+	s := new(Switch)
+	s.SetBackends(backends...)
+	return s
+}
+
+// NewURLSwitch returns a Switch with a single backend named "test" at url.
+func NewURLSwitch(url string) *Switch {
+	return NewSwitch(config.Backend{Name: "test", URL: url})
+}
+
+// SetBackends replaces the switch's backends, as if they had been added,
+// removed or failed their healthchecks.
+func (s *Switch) SetBackends(backends ...config.Backend) {
+	list := make([]config.Backend, len(backends))
+	for i, back := range backends {
+		if back.Type == "" {
+			back.Type = pkg.EthBackend
+		}
+		list[i] = back
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.backends = list
+}
+
+func (s *Switch) Start() error { return nil }
+
+func (s *Switch) Stop() error { return nil }
+
+func (s *Switch) BackendFor(t pkg.BackendType) (*config.Backend, error) {
+	return s.BackendForPool(t, backend.DefaultPool)
+}
+
+func (s *Switch) BackendForPool(t pkg.BackendType, pool string) (*config.Backend, error) {
+	backends := s.HealthyBackendsForPool(t, pool)
+	if len(backends) == 0 {
+		if pool == backend.DefaultPool {
+			return nil, errors.New("no backends available")
+		}
+		return nil, fmt.Errorf("no backends are tagged with %s", pool)
+	}
+	return &backends[0], nil
+}
+
+func (s *Switch) HealthyBackendsFor(t pkg.BackendType) []config.Backend {
+	return s.HealthyBackendsForPool(t, backend.DefaultPool)
+}
+
+func (s *Switch) HealthyBackendsForPool(t pkg.BackendType, pool string) []config.Backend {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	var out []config.Backend
+	for _, back := range s.backends {
+		if back.Type == t && inPool(&back, pool) {
+			out = append(out, back)
+		}
+	}
+	return out
+}
+
+func (s *Switch) HealthyBackends(t pkg.BackendType) []config.Backend {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	var out []config.Backend
+	for _, back := range s.backends {
+		if back.Type == t {
+			out = append(out, back)
+		}
+	}
+	return out
+}
+
+func (s *Switch) ETHClient() (*backend.ETHClient, error) {
+	back, err := s.BackendFor(pkg.EthBackend)
+	if err != nil {
+		return nil, err
+	}
+	return backend.NewETHClient(back.URL), nil
+}
+
+func inPool(back *config.Backend, pool string) bool {
+	if pool == backend.DefaultPool {
+		return back.InDefaultPool()
+	}
+	for _, tag := range back.Tags {
+		if tag == pool {
+			return true
+		}
+	}
+	return false
+}
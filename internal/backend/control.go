@@ -0,0 +1,365 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+	"github.com/kyokan/chaind/pkg"
+	"github.com/kyokan/chaind/pkg/config"
+)
+
+type BackendState string
+
+const (
+	// StateActive backends are eligible to serve requests.
+	StateActive BackendState = "active"
+	// StateDraining backends stop receiving new requests, but are still
+	// healthchecked so that they can be re-enabled safely.
+	StateDraining BackendState = "draining"
+	// StateDisabled backends receive no requests and are not healthchecked.
+	StateDisabled BackendState = "disabled"
+)
+
+type backendState struct {
+	state     BackendState
+	healthy   bool
+	height    uint64
+	latency   time.Duration
+	checkedAt time.Time
+}
+
+// BackendStatus is a point-in-time view of a backend.
+type BackendStatus struct {
+	Name      string       `json:"name"`
+	URL       string       `json:"url"`
+	Tags      []string     `json:"tags"`
+	State     BackendState `json:"state"`
+	Healthy   bool         `json:"healthy"`
+	Height    uint64       `json:"height"`
+	Lag       uint64       `json:"lag"`
+	LatencyMS int64        `json:"latencyMs"`
+	CheckedAt time.Time    `json:"checkedAt"`
+	Selected  []string     `json:"selectedIn"`
+}
+
+// Controller allows backends to be inspected and changed while chaind is
+// running.
+type Controller interface {
+	Statuses() []BackendStatus
+	Selection() map[string]string
+	Drain(name string) error
+	Disable(name string) error
+	Enable(name string) error
+	SwitchTo(pool string, name string) error
+	AddBackend(backend config.Backend) error
+	UpdateBackend(backend config.Backend) error
+	RemoveBackend(name string) error
+}
+
+// Statuses returns the status of every backend, sorted by name. Lag is
+// measured against the highest block seen on any backend.
+func (h *SwitcherImpl) Statuses() []BackendStatus {
+	h.mtx.RLock()
+	defer h.mtx.RUnlock()
+
+	var maxHeight uint64
+	for _, state := range h.states {
+		if state.height > maxHeight {
+			maxHeight = state.height
+		}
+	}
+
+	statuses := make(map[string]*BackendStatus)
+	for _, p := range h.ethPools {
+		for i, backend := range p.backends {
+			status := statuses[backend.Name]
+			if status == nil {
+				state := h.states[backend.Name]
+				status = &BackendStatus{
+					Name:      backend.Name,
+					URL:       backend.URL,
+					Tags:      backend.Tags,
+					State:     state.state,
+					Healthy:   state.healthy,
+					Height:    state.height,
+					LatencyMS: int64(state.latency / time.Millisecond),
+					CheckedAt: state.checkedAt,
+				}
+				if state.height != 0 {
+					status.Lag = maxHeight - state.height
+				}
+				statuses[backend.Name] = status
+			}
+			if int32(i) == p.curr {
+				status.Selected = append(status.Selected, p.name)
+			}
+		}
+	}
+
+	var out []BackendStatus
+	for _, status := range statuses {
+		sort.Strings(status.Selected)
+		out = append(out, *status)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// Selection returns the name of the backend currently selected in each pool,
+// or an empty string if the pool has no backends available.
+func (h *SwitcherImpl) Selection() map[string]string {
+	h.mtx.RLock()
+	defer h.mtx.RUnlock()
+
+	out := make(map[string]string)
+	for name, p := range h.ethPools {
+		out[name] = ""
+		if p.curr != -1 && len(p.backends) > 0 {
+			out[name] = p.backends[p.curr].Name
+		}
+	}
+	return out
+}
+
+// Drain stops sending new requests to the backend. Requests already in flight
+// are allowed to finish.
+func (h *SwitcherImpl) Drain(name string) error {
+	return h.setState(name, StateDraining)
+}
+
+// Disable stops sending requests to the backend and stops healthchecking it.
+func (h *SwitcherImpl) Disable(name string) error {
+	return h.setState(name, StateDisabled)
+}
+
+// Enable returns a drained or disabled backend to service. Pools that had run
+// out of backends will select it right away.
+func (h *SwitcherImpl) Enable(name string) error {
+	return h.setState(name, StateActive)
+}
+
+func (h *SwitcherImpl) setState(name string, newState BackendState) error {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	state := h.states[name]
+	if state == nil {
+		return fmt.Errorf("backend %s not found", name)
+	}
+	if state.state == newState {
+		return nil
+	}
+
+	h.logger.Info("changing backend state", "name", name, "from", state.state, "to", newState)
+	state.state = newState
+	if newState == StateDisabled {
+		state.healthy = false
+		recordCheck(name, state)
+	}
+	recordState(name, newState)
+
+	for _, p := range h.ethPools {
+		if newState == StateActive && p.curr == -1 {
+			h.reselect(p)
+		} else if newState != StateActive && p.curr != -1 && len(p.backends) > 0 && p.backends[p.curr].Name == name {
+			h.reselect(p)
+		}
+	}
+	return nil
+}
+
+// SwitchTo forces the pool to use the given backend. Regular failover still
+// applies if the backend later fails its healthcheck.
+func (h *SwitcherImpl) SwitchTo(poolName string, name string) error {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	p := h.ethPools[poolName]
+	if p == nil {
+		return fmt.Errorf("pool %s not found", poolName)
+	}
+	state := h.states[name]
+	if state != nil && state.state != StateActive {
+		return fmt.Errorf("backend %s is %s", name, state.state)
+	}
+
+	for i, backend := range p.backends {
+		if backend.Name == name {
+			h.logger.Info("forcing backend switch", "pool", poolName, "name", name)
+			p.curr = int32(i)
+			p.gen++
+			return nil
+		}
+	}
+
+	return fmt.Errorf("backend %s is not in pool %s", name, poolName)
+}
+
+// AddBackend adds a backend to the pools matching its tags. It becomes
+// eligible for fan-out once it passes a healthcheck, and only takes over
+// as the selected backend through failover or SwitchTo.
+func (h *SwitcherImpl) AddBackend(backend config.Backend) error {
+	if backend.Type != pkg.EthBackend {
+		return errors.New("only Ethereum backends are supported")
+	}
+	if backend.Name == "" {
+		return errors.New("backend name must be defined")
+	}
+	if _, err := url.ParseRequestURI(backend.URL); err != nil {
+		return fmt.Errorf("invalid url: %s", backend.URL)
+	}
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if h.states[backend.Name] != nil {
+		return fmt.Errorf("backend %s already exists", backend.Name)
+	}
+
+	// a runtime addition never displaces the configured main backend
+	backend.Main = false
+	h.states[backend.Name] = &backendState{state: StateActive}
+	h.addToPools(backend)
+	recordState(backend.Name, StateActive)
+	h.logger.Info("added backend", "name", backend.Name, "url", backend.URL, "tags", backend.Tags)
+	return nil
+}
+
+// UpdateBackend changes the URL and tags of an existing backend. The backend
+// keeps its state, but is healthchecked again before it is used for fan-out.
+func (h *SwitcherImpl) UpdateBackend(backend config.Backend) error {
+	if _, err := url.ParseRequestURI(backend.URL); err != nil {
+		return fmt.Errorf("invalid url: %s", backend.URL)
+	}
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	state := h.states[backend.Name]
+	if state == nil {
+		return fmt.Errorf("backend %s not found", backend.Name)
+	}
+	if !backend.InDefaultPool() && h.isLastInDefaultPool(backend.Name) {
+		return errors.New("cannot remove the last backend in the default pool")
+	}
+
+	backend.Type = pkg.EthBackend
+	backend.Main = false
+	state.healthy = false
+	state.height = 0
+	state.checkedAt = time.Time{}
+	h.removeFromPools(backend.Name)
+	h.addToPools(backend)
+	h.logger.Info("updated backend", "name", backend.Name, "url", backend.URL, "tags", backend.Tags)
+	return nil
+}
+
+// RemoveBackend removes a backend from every pool. The last backend in the
+// default pool can't be removed.
+func (h *SwitcherImpl) RemoveBackend(name string) error {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+
+	if h.states[name] == nil {
+		return fmt.Errorf("backend %s not found", name)
+	}
+	if h.isLastInDefaultPool(name) {
+		return errors.New("cannot remove the last backend in the default pool")
+	}
+
+	h.removeFromPools(name)
+	delete(h.states, name)
+	forgetBackend(name)
+	h.logger.Info("removed backend", "name", name)
+	return nil
+}
+
+func (h *SwitcherImpl) isLastInDefaultPool(name string) bool {
+	defaultPool := h.ethPools[DefaultPool]
+	return len(defaultPool.backends) == 1 && defaultPool.backends[0].Name == name
+}
+
+// removeFromPools removes the backend from every pool, selecting another
+// backend in pools where it was selected. It must be called with the lock
+// held.
+func (h *SwitcherImpl) removeFromPools(name string) {
+	for _, p := range h.ethPools {
+		idx := -1
+		for i, backend := range p.backends {
+			if backend.Name == name {
+				idx = i
+				break
+			}
+		}
+		if idx == -1 {
+			continue
+		}
+
+		// copy rather than modify in place, since healthchecks may be
+		// iterating over the old slice
+		backends := make([]config.Backend, 0, len(p.backends)-1)
+		backends = append(backends, p.backends[:idx]...)
+		backends = append(backends, p.backends[idx+1:]...)
+		wasSelected := p.curr == int32(idx)
+		if p.curr > int32(idx) {
+			p.curr--
+		}
+		p.backends = backends
+		p.gen++
+		if wasSelected {
+			h.reselect(p)
+		}
+	}
+}
+
+// addToPools adds the backend to its pools, creating them if necessary, and
+// selects it in pools that have run out of backends. The backend's state must
+// already be recorded, and the lock must be held.
+func (h *SwitcherImpl) addToPools(backend config.Backend) {
+	var poolNames []string
+	if backend.InDefaultPool() {
+		poolNames = append(poolNames, DefaultPool)
+	}
+	poolNames = append(poolNames, backend.Tags...)
+
+	for _, name := range poolNames {
+		p := h.ethPools[name]
+		if p == nil {
+			p = &pool{name: name}
+			h.ethPools[name] = p
+		}
+
+		backends := make([]config.Backend, 0, len(p.backends)+1)
+		if backend.Main {
+			backends = append(backends, backend)
+			backends = append(backends, p.backends...)
+		} else {
+			backends = append(backends, p.backends...)
+			backends = append(backends, backend)
+		}
+		p.backends = backends
+		p.gen++
+		if p.curr == -1 {
+			h.reselect(p)
+		}
+	}
+}
+
+// reselect points the pool at its first active backend that is healthy or
+// hasn't been checked yet. It must be called with the lock held.
+func (h *SwitcherImpl) reselect(p *pool) {
+	p.gen++
+	for i, backend := range p.backends {
+		state := h.states[backend.Name]
+		if state.state == StateActive && (state.healthy || state.checkedAt.IsZero()) {
+			p.curr = int32(i)
+			h.logger.Info("selected backend", "pool", p.name, "name", backend.Name)
+			return
+		}
+	}
+
+	h.logger.Error("no more backends to try", "pool", p.name)
+	p.curr = -1
+}
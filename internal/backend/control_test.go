@@ -0,0 +1,108 @@
+package backend
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"github.com/kyokan/chaind/pkg"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func newTestNode(height string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if gjson.GetBytes(body, "method").String() == "eth_blockNumber" {
+			w.Write([]byte("{\"jsonrpc\":\"2.0\",\"result\":\"" + height + "\",\"id\":1}"))
+			return
+		}
+		w.Write([]byte("{\"jsonrpc\":\"2.0\",\"result\":false,\"id\":1}"))
+	}))
+}
+
+func TestController(t *testing.T) {
+	srv1 := newTestNode("0x64")
+	defer srv1.Close()
+	srv2 := newTestNode("0x60")
+	defer srv2.Close()
+
+	sw := NewSwitcher([]config.Backend{
+		{Name: "test-1", URL: srv1.URL, Type: pkg.EthBackend},
+		{Name: "test-2", URL: srv2.URL, Type: pkg.EthBackend},
+	})
+	sw.checkStandbys(sw.uniqueBackends())
+
+	statuses := sw.Statuses()
+	require.Len(t, statuses, 2)
+	require.Equal(t, "test-1", statuses[0].Name)
+	require.True(t, statuses[0].Healthy)
+	require.Equal(t, uint64(100), statuses[0].Height)
+	require.Equal(t, uint64(0), statuses[0].Lag)
+	require.Equal(t, []string{DefaultPool}, statuses[0].Selected)
+	require.Equal(t, uint64(4), statuses[1].Lag)
+	require.Equal(t, map[string]string{DefaultPool: "test-1"}, sw.Selection())
+
+	require.NoError(t, sw.Drain("test-1"))
+	back, err := sw.BackendFor(pkg.EthBackend)
+	require.NoError(t, err)
+	require.Equal(t, "test-2", back.Name)
+	require.Len(t, sw.HealthyBackendsFor(pkg.EthBackend), 1)
+	require.Error(t, sw.SwitchTo(DefaultPool, "test-1"))
+
+	require.NoError(t, sw.Disable("test-2"))
+	_, err = sw.BackendFor(pkg.EthBackend)
+	require.Error(t, err)
+
+	require.NoError(t, sw.Enable("test-1"))
+	back, err = sw.BackendFor(pkg.EthBackend)
+	require.NoError(t, err)
+	require.Equal(t, "test-1", back.Name)
+
+	require.NoError(t, sw.Enable("test-2"))
+	require.NoError(t, sw.SwitchTo(DefaultPool, "test-2"))
+	back, err = sw.BackendFor(pkg.EthBackend)
+	require.NoError(t, err)
+	require.Equal(t, "test-2", back.Name)
+	require.Error(t, sw.SwitchTo("archive", "test-2"))
+	require.Error(t, sw.Drain("nope"))
+}
+
+func TestController_AddRemove(t *testing.T) {
+	srv := newTestNode("0x64")
+	defer srv.Close()
+
+	sw := NewSwitcher([]config.Backend{
+		{Name: "test-1", URL: srv.URL, Type: pkg.EthBackend},
+	})
+	require.Error(t, sw.RemoveBackend("test-1"))
+	require.Error(t, sw.AddBackend(config.Backend{Name: "test-1", URL: srv.URL, Type: pkg.EthBackend}))
+	require.Error(t, sw.AddBackend(config.Backend{Name: "test-2", URL: "not a url", Type: pkg.EthBackend}))
+
+	require.NoError(t, sw.AddBackend(config.Backend{Name: "archive-1", URL: srv.URL, Type: pkg.EthBackend, Tags: []string{"archive"}}))
+	back, err := sw.BackendForPool(pkg.EthBackend, "archive")
+	require.NoError(t, err)
+	require.Equal(t, "archive-1", back.Name)
+
+	require.NoError(t, sw.AddBackend(config.Backend{Name: "test-2", URL: srv.URL, Type: pkg.EthBackend, Main: true}))
+	back, err = sw.BackendFor(pkg.EthBackend)
+	require.NoError(t, err)
+	require.Equal(t, "test-1", back.Name)
+
+	require.NoError(t, sw.RemoveBackend("test-1"))
+	back, err = sw.BackendFor(pkg.EthBackend)
+	require.NoError(t, err)
+	require.Equal(t, "test-2", back.Name)
+
+	require.Error(t, sw.UpdateBackend(config.Backend{Name: "test-2", URL: srv.URL, Tags: []string{"archive"}}))
+	require.NoError(t, sw.UpdateBackend(config.Backend{Name: "archive-1", URL: srv.URL + "/v2", Tags: []string{"archive"}}))
+	back, err = sw.BackendForPool(pkg.EthBackend, "archive")
+	require.NoError(t, err)
+	require.Equal(t, srv.URL+"/v2", back.URL)
+
+	require.NoError(t, sw.RemoveBackend("archive-1"))
+	_, err = sw.BackendForPool(pkg.EthBackend, "archive")
+	require.Error(t, err)
+	require.Len(t, sw.Statuses(), 1)
+}
@@ -8,6 +8,9 @@ import (
 	"encoding/json"
 )
 
+// ErrMethodNotFound is returned when the backend doesn't support a method.
+var ErrMethodNotFound = errors.New("method not found")
+
 type ETHClient struct {
 	client *jsonrpc.Client
 }
@@ -41,6 +44,52 @@ func (c *ETHClient) GetTransactionReceipt(hash string) (json.RawMessage, error)
 	return res.Result, nil
 }
 
+// GetBlockReceipts fetches all receipts in a block with a single call to
+// method, which is either eth_getBlockReceipts or parity_getBlockReceipts.
+func (c *ETHClient) GetBlockReceipts(method string, number uint64) ([]json.RawMessage, error) {
+	res, err := c.client.Call(method, jsonrpc.Uint642Hex(number))
+	if err != nil {
+		return nil, err
+	}
+	if res.Error != nil {
+		if res.Error.Code == jsonrpc.MethodNotFoundCode {
+			return nil, ErrMethodNotFound
+		}
+		return nil, errors.New(res.Error.Message)
+	}
+
+	var receipts []json.RawMessage
+	if err := json.Unmarshal(res.Result, &receipts); err != nil {
+		return nil, err
+	}
+	return receipts, nil
+}
+
+// GetTransactionReceipts fetches the receipts of the transactions in a
+// single batch request.
+func (c *ETHClient) GetTransactionReceipts(hashes []string) ([]json.RawMessage, error) {
+	elems := make([]jsonrpc.BatchElem, len(hashes))
+	for i, hash := range hashes {
+		elems[i] = jsonrpc.BatchElem{
+			Method: "eth_getTransactionReceipt",
+			Params: []interface{}{hash},
+		}
+	}
+
+	res, err := c.client.BatchCall(elems)
+	if err != nil {
+		return nil, err
+	}
+	receipts := make([]json.RawMessage, len(res))
+	for i, r := range res {
+		if r.Error != nil {
+			return nil, errors.New(r.Error.Message)
+		}
+		receipts[i] = r.Result
+	}
+	return receipts, nil
+}
+
 func (c *ETHClient) GetBlockByNumber(number uint64, includeBodies bool) (json.RawMessage, error) {
 	res, err := c.client.Call("eth_getBlockByNumber", jsonrpc.Uint642Hex(number), includeBodies)
 	if err != nil {
@@ -48,4 +97,57 @@ func (c *ETHClient) GetBlockByNumber(number uint64, includeBodies bool) (json.Ra
 	}
 
 	return res.Result, nil
-}
\ No newline at end of file
+}
+
+func (c *ETHClient) GetBalance(address string, block string) (json.RawMessage, error) {
+	return c.call("eth_getBalance", address, block)
+}
+
+func (c *ETHClient) GetCode(address string, block string) (json.RawMessage, error) {
+	return c.call("eth_getCode", address, block)
+}
+
+// GetLogs fetches every log emitted in the block.
+func (c *ETHClient) GetLogs(number uint64) (json.RawMessage, error) {
+	block := jsonrpc.Uint642Hex(number)
+	return c.call("eth_getLogs", map[string]string{
+		"fromBlock": block,
+		"toBlock":   block,
+	})
+}
+
+func (c *ETHClient) SendRawTransaction(rawTx string) (string, error) {
+	res, err := c.client.Call("eth_sendRawTransaction", rawTx)
+	if err != nil {
+		return "", err
+	}
+	if res.Error != nil {
+		return "", errors.New(res.Error.Message)
+	}
+
+	return gjson.ParseBytes(res.Result).String(), nil
+}
+
+func (c *ETHClient) GetTransactionCount(address string, block string) (uint64, error) {
+	res, err := c.client.Call("eth_getTransactionCount", address, block)
+	if err != nil {
+		return 0, err
+	}
+	if res.Error != nil {
+		return 0, errors.New(res.Error.Message)
+	}
+
+	return jsonrpc.Hex2Uint64(gjson.ParseBytes(res.Result).String())
+}
+
+func (c *ETHClient) call(method string, params ...interface{}) (json.RawMessage, error) {
+	res, err := c.client.Call(method, params...)
+	if err != nil {
+		return nil, err
+	}
+	if res.Error != nil {
+		return nil, errors.New(res.Error.Message)
+	}
+
+	return res.Result, nil
+}
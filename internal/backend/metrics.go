@@ -0,0 +1,93 @@
+package backend
+
+import (
+	"context"
+	"net"
+	"github.com/kyokan/chaind/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Reasons a request to a backend failed, used to label error metrics.
+const (
+	ReasonTimeout = "timeout"
+	ReasonError   = "error"
+	ReasonStatus  = "status"
+	ReasonSyncing = "syncing"
+)
+
+var backendHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name:      "eth_backend_healthy",
+	Subsystem: metrics.Subsystem,
+	Help:      "Whether the backend passed its last healthcheck.",
+}, []string{"backend"})
+
+var backendStateGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name:      "eth_backend_state",
+	Subsystem: metrics.Subsystem,
+	Help:      "State of the backend, set to 1 for its current state and 0 for the others.",
+}, []string{"backend", "state"})
+
+var backendHeight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name:      "eth_backend_block_height",
+	Subsystem: metrics.Subsystem,
+	Help:      "Block height reported by the backend in its last healthcheck.",
+}, []string{"backend"})
+
+var backendLatency = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name:      "eth_backend_healthcheck_latency_seconds",
+	Subsystem: metrics.Subsystem,
+	Help:      "Duration of the backend's last healthcheck.",
+}, []string{"backend"})
+
+var healthcheckFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name:      "eth_backend_healthcheck_failures",
+	Subsystem: metrics.Subsystem,
+	Help:      "Number of failed healthchecks, by reason.",
+}, []string{"backend", "reason"})
+
+var backendStates = []BackendState{StateActive, StateDraining, StateDisabled}
+
+func recordState(name string, state BackendState) {
+	for _, s := range backendStates {
+		val := 0.0
+		if s == state {
+			val = 1
+		}
+		backendStateGauge.WithLabelValues(name, string(s)).Set(val)
+	}
+}
+
+func recordCheck(name string, state *backendState) {
+	healthy := 0.0
+	if state.healthy {
+		healthy = 1
+	}
+	backendHealthy.WithLabelValues(name).Set(healthy)
+	backendLatency.WithLabelValues(name).Set(state.latency.Seconds())
+	if state.height != 0 {
+		backendHeight.WithLabelValues(name).Set(float64(state.height))
+	}
+}
+
+// forgetBackend removes a backend's metrics once it has been removed.
+func forgetBackend(name string) {
+	backendHealthy.DeleteLabelValues(name)
+	backendHeight.DeleteLabelValues(name)
+	backendLatency.DeleteLabelValues(name)
+	for _, s := range backendStates {
+		backendStateGauge.DeleteLabelValues(name, string(s))
+	}
+}
+
+// ErrorReason returns ReasonTimeout if err is a timeout, and ReasonError
+// otherwise.
+func ErrorReason(err error) string {
+	if err == context.DeadlineExceeded {
+		return ReasonTimeout
+	}
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return ReasonTimeout
+	}
+	return ReasonError
+}
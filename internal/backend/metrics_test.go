@@ -0,0 +1,65 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"github.com/kyokan/chaind/pkg"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+func gaugeValue(t *testing.T, g prometheus.Gauge) float64 {
+	var m dto.Metric
+	require.NoError(t, g.Write(&m))
+	return m.GetGauge().GetValue()
+}
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	var m dto.Metric
+	require.NoError(t, c.Write(&m))
+	return m.GetCounter().GetValue()
+}
+
+func TestSwitcherMetrics(t *testing.T) {
+	srv := newTestNode("0x64")
+	defer srv.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+
+	sw := NewSwitcher([]config.Backend{
+		{Name: "metrics-1", URL: srv.URL, Type: pkg.EthBackend},
+		{Name: "metrics-2", URL: failing.URL, Type: pkg.EthBackend},
+	})
+	sw.checkStandbys(sw.uniqueBackends())
+
+	require.Equal(t, 1.0, gaugeValue(t, backendHealthy.WithLabelValues("metrics-1")))
+	require.Equal(t, 100.0, gaugeValue(t, backendHeight.WithLabelValues("metrics-1")))
+	require.Equal(t, 0.0, gaugeValue(t, backendHealthy.WithLabelValues("metrics-2")))
+	require.Equal(t, 1.0, counterValue(t, healthcheckFailures.WithLabelValues("metrics-2", ReasonStatus)))
+	require.Equal(t, 1.0, gaugeValue(t, backendStateGauge.WithLabelValues("metrics-2", string(StateActive))))
+
+	require.NoError(t, sw.Drain("metrics-2"))
+	require.Equal(t, 0.0, gaugeValue(t, backendStateGauge.WithLabelValues("metrics-2", string(StateActive))))
+	require.Equal(t, 1.0, gaugeValue(t, backendStateGauge.WithLabelValues("metrics-2", string(StateDraining))))
+}
+
+func TestErrorReason(t *testing.T) {
+	require.Equal(t, ReasonTimeout, ErrorReason(context.DeadlineExceeded))
+	require.Equal(t, ReasonError, ErrorReason(errors.New("connection refused")))
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer slow.Close()
+	_, err := pkg.NewHTTPClient(10 * time.Millisecond).Get(slow.URL)
+	require.Error(t, err)
+	require.Equal(t, ReasonTimeout, ErrorReason(err))
+}
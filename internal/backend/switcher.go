@@ -8,7 +8,6 @@ import (
 	"fmt"
 	"errors"
 	"strings"
-	"sync/atomic"
 	"github.com/kyokan/chaind/pkg/config"
 	"sync"
 	"io/ioutil"
@@ -20,36 +19,54 @@ const ethCheckBody = "{\"jsonrpc\":\"2.0\",\"method\":\"eth_syncing\",\"params\"
 type Switcher interface {
 	pkg.Service
 	BackendFor(t pkg.BackendType) (*config.Backend, error)
+	BackendForPool(t pkg.BackendType, pool string) (*config.Backend, error)
+	HealthyBackendsFor(t pkg.BackendType) []config.Backend
+	HealthyBackendsForPool(t pkg.BackendType, pool string) []config.Backend
+	HealthyBackends(t pkg.BackendType) []config.Backend
 	ETHClient() (*ETHClient, error)
 }
 
+// DefaultPool is the pool used for requests that aren't routed elsewhere.
+// It contains every backend that is either untagged or tagged as a full node.
+const DefaultPool = ""
+
+type pool struct {
+	name     string
+	backends []config.Backend
+	curr     int32
+	// gen is bumped whenever the pool is changed at runtime, so that
+	// in-flight healthchecks don't overwrite the new selection.
+	gen int
+}
+
 type SwitcherImpl struct {
-	ethBackends []config.Backend
-	currEth     int32
-	quitChan    chan bool
-	logger      log15.Logger
+	ethPools map[string]*pool
+	states   map[string]*backendState
+	mtx      sync.RWMutex
+	quitChan chan bool
+	logger   log15.Logger
 }
 
-func NewSwitcher(backendCfg []config.Backend) Switcher {
-	var ethBackends []config.Backend
+func NewSwitcher(backendCfg []config.Backend) *SwitcherImpl {
+	h := &SwitcherImpl{
+		ethPools: map[string]*pool{
+			DefaultPool: {name: DefaultPool},
+		},
+		states:   make(map[string]*backendState),
+		quitChan: make(chan bool),
+		logger:   log.NewLog("proxy/backend_switch"),
+	}
 
 	for _, backend := range backendCfg {
 		if backend.Type != pkg.EthBackend {
 			continue
 		}
-
-		if backend.Main {
-			ethBackends = append([]config.Backend{backend}, ethBackends...)
-		} else {
-			ethBackends = append(ethBackends, backend)
-		}
+		h.states[backend.Name] = &backendState{state: StateActive}
+		h.addToPools(backend)
+		recordState(backend.Name, StateActive)
 	}
 
-	return &SwitcherImpl{
-		ethBackends: ethBackends,
-		quitChan:    make(chan bool),
-		logger:      log.NewLog("proxy/backend_switch"),
-	}
+	return h
 }
 
 func (h *SwitcherImpl) Start() error {
@@ -77,18 +94,89 @@ func (h *SwitcherImpl) Stop() error {
 }
 
 func (h *SwitcherImpl) BackendFor(t pkg.BackendType) (*config.Backend, error) {
-	var idx int32
-	if t == pkg.EthBackend {
-		idx = atomic.LoadInt32(&h.currEth)
-	} else {
+	return h.BackendForPool(t, DefaultPool)
+}
+
+func (h *SwitcherImpl) BackendForPool(t pkg.BackendType, poolName string) (*config.Backend, error) {
+	if t != pkg.EthBackend {
 		return nil, errors.New("only Ethereum backends are supported")
 	}
 
-	if idx == -1 {
+	h.mtx.RLock()
+	defer h.mtx.RUnlock()
+	p := h.ethPools[poolName]
+	if p == nil {
+		return nil, fmt.Errorf("no backends are tagged with %s", poolName)
+	}
+
+	if p.curr == -1 || len(p.backends) == 0 {
 		return nil, errors.New("no backends available")
 	}
 
-	return &h.ethBackends[idx], nil
+	backend := p.backends[p.curr]
+	return &backend, nil
+}
+
+func (h *SwitcherImpl) HealthyBackendsFor(t pkg.BackendType) []config.Backend {
+	return h.HealthyBackendsForPool(t, DefaultPool)
+}
+
+// HealthyBackendsForPool returns every active backend in the pool that passed
+// its last healthcheck. The pool's currently selected backend is always first.
+func (h *SwitcherImpl) HealthyBackendsForPool(t pkg.BackendType, poolName string) []config.Backend {
+	if t != pkg.EthBackend {
+		return nil
+	}
+
+	h.mtx.RLock()
+	defer h.mtx.RUnlock()
+	p := h.ethPools[poolName]
+	if p == nil {
+		return nil
+	}
+
+	var out []config.Backend
+	var currName string
+	if p.curr != -1 && len(p.backends) > 0 {
+		out = append(out, p.backends[p.curr])
+		currName = p.backends[p.curr].Name
+	}
+
+	for _, backend := range p.backends {
+		if backend.Name == currName {
+			continue
+		}
+		state := h.states[backend.Name]
+		if state.healthy && state.state == StateActive {
+			out = append(out, backend)
+		}
+	}
+
+	return out
+}
+
+// HealthyBackends returns every active backend in any pool that passed its
+// last healthcheck. The default pool's backends come first, in the order
+// HealthyBackendsFor returns them.
+func (h *SwitcherImpl) HealthyBackends(t pkg.BackendType) []config.Backend {
+	out := h.HealthyBackendsFor(t)
+	if t != pkg.EthBackend {
+		return out
+	}
+
+	seen := make(map[string]bool)
+	for _, backend := range out {
+		seen[backend.Name] = true
+	}
+	h.mtx.RLock()
+	defer h.mtx.RUnlock()
+	for _, backend := range h.uniqueBackends() {
+		state := h.states[backend.Name]
+		if !seen[backend.Name] && state.healthy && state.state == StateActive {
+			out = append(out, backend)
+		}
+	}
+	return out
 }
 
 func (h *SwitcherImpl) ETHClient() (*ETHClient, error) {
@@ -101,25 +189,123 @@ func (h *SwitcherImpl) ETHClient() (*ETHClient, error) {
 }
 
 func (h *SwitcherImpl) performAllHealthchecks() {
+	type snapshot struct {
+		pool     *pool
+		curr     int32
+		gen      int
+		backends []config.Backend
+	}
+
+	h.mtx.RLock()
+	var snapshots []snapshot
+	for _, p := range h.ethPools {
+		if p.curr == -1 || len(p.backends) == 0 {
+			continue
+		}
+		snapshots = append(snapshots, snapshot{
+			pool:     p,
+			curr:     p.curr,
+			gen:      p.gen,
+			backends: p.backends,
+		})
+	}
+	standbys := h.uniqueBackends()
+	h.mtx.RUnlock()
+
 	// use waitgroup so we can add btc checks later
 	var wg sync.WaitGroup
-	if h.currEth != -1 {
+	for _, snap := range snapshots {
 		wg.Add(1)
-		go func() {
-			idx := h.doHealthcheck(atomic.LoadInt32(&h.currEth), h.ethBackends)
-			atomic.StoreInt32(&h.currEth, idx)
+		go func(snap snapshot) {
+			idx := h.doHealthcheck(snap.curr, snap.backends)
+			h.mtx.Lock()
+			if snap.pool.gen == snap.gen {
+				snap.pool.curr = idx
+			}
+			h.mtx.Unlock()
 			wg.Done()
-		}()
+		}(snap)
 	}
+	wg.Add(1)
+	go func() {
+		h.checkStandbys(standbys)
+		wg.Done()
+	}()
 	wg.Wait()
 }
 
+// uniqueBackends returns every backend that isn't disabled. It must be called
+// with the lock held.
+func (h *SwitcherImpl) uniqueBackends() []config.Backend {
+	var out []config.Backend
+	seen := make(map[string]bool)
+	for _, p := range h.ethPools {
+		for _, backend := range p.backends {
+			if seen[backend.Name] || h.states[backend.Name].state == StateDisabled {
+				continue
+			}
+			seen[backend.Name] = true
+			out = append(out, backend)
+		}
+	}
+
+	return out
+}
+
+// checkStandbys records the health, height and latency of every backend in
+// the list, including ones that aren't currently selected, so that callers can
+// fan requests out to them. Unlike the selected backend, these are checked
+// once without backoff.
+func (h *SwitcherImpl) checkStandbys(list []config.Backend) {
+	var wg sync.WaitGroup
+	results := make([]backendState, len(list))
+	for i := range list {
+		wg.Add(1)
+		go func(i int) {
+			start := time.Now()
+			results[i].healthy = NewChecker(&list[i]).Check()
+			results[i].latency = time.Since(start)
+			if results[i].healthy {
+				height, err := NewETHClient(list[i].URL).BlockNumber()
+				if err == nil {
+					results[i].height = height
+				}
+			}
+			results[i].checkedAt = time.Now()
+			wg.Done()
+		}(i)
+	}
+	wg.Wait()
+
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	for i, backend := range list {
+		state := h.states[backend.Name]
+		// the backend may have been removed or disabled while it was checked
+		if state == nil || state.state == StateDisabled {
+			continue
+		}
+		state.healthy = results[i].healthy
+		state.latency = results[i].latency
+		state.checkedAt = results[i].checkedAt
+		if results[i].height != 0 {
+			state.height = results[i].height
+		}
+		recordCheck(backend.Name, state)
+	}
+}
+
 func (h *SwitcherImpl) doHealthcheck(idx int32, list []config.Backend) int32 {
 	if idx == -1 {
 		return -1
 	}
 
 	backend := list[idx]
+	if !h.isActive(backend.Name) {
+		h.logger.Info("backend is no longer active, trying another", "type", backend.Type, "name", backend.Name)
+		return h.doHealthcheck(h.nextBackend(idx, list))
+	}
+
 	h.logger.Debug("performing healthcheck", "type", backend.Type, "name", backend.Name, "url", backend.URL)
 	checker := NewChecker(&backend)
 	ok := CheckWithBackoff(checker)
@@ -133,6 +319,13 @@ func (h *SwitcherImpl) doHealthcheck(idx int32, list []config.Backend) int32 {
 	return idx
 }
 
+func (h *SwitcherImpl) isActive(name string) bool {
+	h.mtx.RLock()
+	defer h.mtx.RUnlock()
+	state := h.states[name]
+	return state != nil && state.state == StateActive
+}
+
 func (h *SwitcherImpl) nextBackend(idx int32, list []config.Backend) (int32, []config.Backend) {
 	backend := list[idx]
 	if len(list) == 1 || idx == int32(len(list)-1) {
@@ -173,13 +366,15 @@ func (e *ETHChecker) Check() bool {
 	client := pkg.NewHTTPClient(5 * time.Second)
 	res, err := client.Post(e.backend.URL, "application/json", strings.NewReader(data))
 	if err != nil {
-		e.logger.Warn("backend returned non-200 response", "name", e.backend.Name, "url", e.backend.URL)
+		e.logger.Warn("backend healthcheck failed", "name", e.backend.Name, "url", e.backend.URL, "err", err)
+		healthcheckFailures.WithLabelValues(e.backend.Name, ErrorReason(err)).Inc()
 		return false
 	}
 	defer res.Body.Close()
 	body, err := ioutil.ReadAll(res.Body)
 	if err != nil {
 		e.logger.Error("backend returned unreadable request body", "err", err)
+		healthcheckFailures.WithLabelValues(e.backend.Name, ErrorReason(err)).Inc()
 		return false
 	}
 	syncRes := gjson.GetBytes(body, "result").String()
@@ -188,7 +383,15 @@ func (e *ETHChecker) Check() bool {
 	// can't be cast to a bool, which happens when the node is syncing since
 	// the response is a sync status object.
 	if syncRes != "false" {
+		// a non-200 response without a sync status is most likely an error
+		// page, so it is counted separately from syncing backends
+		if res.StatusCode != 200 {
+			e.logger.Warn("backend returned non-200 response", "name", e.backend.Name, "url", e.backend.URL, "status", res.StatusCode)
+			healthcheckFailures.WithLabelValues(e.backend.Name, ReasonStatus).Inc()
+			return false
+		}
 		e.logger.Warn("backend is either completing initial sync or has fallen behind", "name", e.backend.Name, "url", e.backend.URL)
+		healthcheckFailures.WithLabelValues(e.backend.Name, ReasonSyncing).Inc()
 		return false
 	}
 	return true
@@ -9,43 +9,23 @@ import (
 	"github.com/stretchr/testify/suite"
 	"github.com/stretchr/testify/require"
 	"time"
-	"sync"
+	"github.com/kyokan/chaind/pkg/mocknode"
 	)
 
 type BackendSwitchSuite struct {
 	suite.Suite
 	sw    Switcher
+	node1 *mocknode.Node
+	node2 *mocknode.Node
 	srv1  *httptest.Server
 	srv2  *httptest.Server
-	code1 int
-	body1 []byte
-	code2 int
-	body2 []byte
-	mtx sync.Mutex
 }
 
 func (b *BackendSwitchSuite) SetupSuite() {
-	b.srv1 = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		b.mtx.Lock()
-		defer b.mtx.Unlock()
-		if b.code1 != 0 {
-			w.WriteHeader(b.code1)
-		} else {
-			w.Write(b.body1)
-		}
-	}))
-	b.srv2 = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		b.mtx.Lock()
-		defer b.mtx.Unlock()
-		if b.code2 != 0 {
-			w.WriteHeader(b.code2)
-		} else {
-			w.Write(b.body2)
-		}
-	}))
-
-	b.body1 = []byte("{\"jsonrpc\":\"2.0\",\"result\":false,\"id\":1}")
-	b.body2 = []byte("{\"jsonrpc\":\"2.0\",\"result\":false,\"id\":1}")
+	b.node1 = mocknode.New(mocknode.Config{InitialHeight: 10})
+	b.node2 = mocknode.New(mocknode.Config{InitialHeight: 10})
+	b.srv1 = httptest.NewServer(b.node1)
+	b.srv2 = httptest.NewServer(b.node2)
 
 	b.sw = NewSwitcher([]config.Backend{
 		{
@@ -74,28 +54,83 @@ func (b *BackendSwitchSuite) TestBackendFor_A_InitialSuccess() {
 	backend, err := b.sw.BackendFor(pkg.EthBackend)
 	require.NoError(b.T(), err)
 	require.Equal(b.T(), b.srv2.URL, backend.URL)
+
+	healthy := b.sw.HealthyBackendsFor(pkg.EthBackend)
+	require.Len(b.T(), healthy, 2)
+	require.Equal(b.T(), b.srv2.URL, healthy[0].URL)
+	require.Equal(b.T(), b.srv1.URL, healthy[1].URL)
 }
 
 func (b *BackendSwitchSuite) TestBackendFor_B_AfterFailedHealthcheck() {
-	b.mtx.Lock()
-	b.code2 = http.StatusInternalServerError
-	b.mtx.Unlock()
+	b.node2.SetFaults(mocknode.Faults{HTTPStatus: http.StatusInternalServerError})
 	time.Sleep(5000 * time.Millisecond)
 	backend, err := b.sw.BackendFor(pkg.EthBackend)
 	require.NoError(b.T(), err)
 	require.Equal(b.T(), b.srv1.URL, backend.URL)
+
+	healthy := b.sw.HealthyBackendsFor(pkg.EthBackend)
+	require.Len(b.T(), healthy, 1)
+	require.Equal(b.T(), b.srv1.URL, healthy[0].URL)
 }
 
 func (b *BackendSwitchSuite) TestBackendFor_C_NoMoreBackends() {
-	b.mtx.Lock()
-	b.code1 = http.StatusInternalServerError
-	b.mtx.Unlock()
+	// syncing nodes fail their healthchecks too
+	b.node1.SetFaults(mocknode.Faults{Syncing: true})
 	time.Sleep(5000*time.Millisecond)
 	backend, err := b.sw.BackendFor(pkg.EthBackend)
 	require.Error(b.T(), err)
 	require.Nil(b.T(), backend)
 }
 
+func TestETHChecker_Check(t *testing.T) {
+	node := mocknode.New(mocknode.Config{InitialHeight: 10})
+	srv := httptest.NewServer(node)
+	defer srv.Close()
+	// a node behind a proxy that errors but still passes the body through
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("{\"jsonrpc\":\"2.0\",\"result\":false,\"id\":1}"))
+	}))
+	defer unavailable.Close()
+
+	checker := NewChecker(&config.Backend{Name: "checker-1", URL: srv.URL, Type: pkg.EthBackend})
+	require.True(t, checker.Check())
+	node.SetFaults(mocknode.Faults{Syncing: true})
+	require.False(t, checker.Check())
+	require.Equal(t, 1.0, counterValue(t, healthcheckFailures.WithLabelValues("checker-1", ReasonSyncing)))
+	node.SetFaults(mocknode.Faults{HTTPStatus: http.StatusBadGateway})
+	require.False(t, checker.Check())
+	require.Equal(t, 1.0, counterValue(t, healthcheckFailures.WithLabelValues("checker-1", ReasonStatus)))
+
+	// only the response body decides whether a backend is healthy
+	checker = NewChecker(&config.Backend{Name: "checker-2", URL: unavailable.URL, Type: pkg.EthBackend})
+	require.True(t, checker.Check())
+}
+
+func TestHealthyBackends(t *testing.T) {
+	full := httptest.NewServer(mocknode.New(mocknode.Config{InitialHeight: 10}))
+	defer full.Close()
+	archiveNode := mocknode.New(mocknode.Config{InitialHeight: 10})
+	archive := httptest.NewServer(archiveNode)
+	defer archive.Close()
+
+	sw := NewSwitcher([]config.Backend{
+		{Name: "archive-1", URL: archive.URL, Type: pkg.EthBackend, Tags: []string{"archive"}},
+		{Name: "full-1", URL: full.URL, Type: pkg.EthBackend},
+	})
+	sw.checkStandbys(sw.uniqueBackends())
+	healthy := sw.HealthyBackends(pkg.EthBackend)
+	require.Len(t, healthy, 2)
+	require.Equal(t, "full-1", healthy[0].Name)
+	require.Equal(t, "archive-1", healthy[1].Name)
+
+	archiveNode.SetFaults(mocknode.Faults{Syncing: true})
+	sw.checkStandbys(sw.uniqueBackends())
+	healthy = sw.HealthyBackends(pkg.EthBackend)
+	require.Len(t, healthy, 1)
+	require.Equal(t, "full-1", healthy[0].Name)
+}
+
 func TestBackendSwitchSuite(t *testing.T) {
 	suite.Run(t, new(BackendSwitchSuite))
 }
@@ -0,0 +1,185 @@
+package cache
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+	"github.com/inconshreveable/log15"
+	"github.com/kyokan/chaind/internal/backend"
+	"github.com/kyokan/chaind/pkg/concurrent"
+	"github.com/kyokan/chaind/pkg/log"
+	"github.com/tidwall/gjson"
+)
+
+// BackfillBatchSize is the number of blocks fetched between checkpoints.
+const BackfillBatchSize = 100
+
+type BackfillOptions struct {
+	From        uint64
+	To          uint64
+	Concurrency int
+	// RequestsPerSec limits the rate of requests to the backend. Zero means
+	// unlimited.
+	RequestsPerSec int
+	// Restart ignores any checkpoint left by a previous run over the same
+	// range.
+	Restart bool
+	// Progress is called after every checkpoint with the number of blocks
+	// backfilled so far.
+	Progress func(done uint64, total uint64)
+}
+
+// Backfiller caches the blocks, transactions and receipts in an arbitrary
+// range of finalized blocks. Progress is checkpointed in the cache, so an
+// interrupted backfill resumes where it left off.
+type Backfiller struct {
+	store    *ETHStore
+	cacher   Cacher
+	hWatcher *BlockHeightWatcher
+	switcher backend.Switcher
+	receipts *receiptFetcher
+	opts     BackfillOptions
+	ticker   *time.Ticker
+	logger   log15.Logger
+}
+
+func NewBackfiller(store *ETHStore, cacher Cacher, hWatcher *BlockHeightWatcher, switcher backend.Switcher, opts BackfillOptions) *Backfiller {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = WarmUpConcurrency
+	}
+
+	b := &Backfiller{
+		store:    store,
+		cacher:   cacher,
+		hWatcher: hWatcher,
+		switcher: switcher,
+		opts:     opts,
+		logger:   log.NewLog("backfiller"),
+	}
+	b.receipts = newReceiptFetcher(switcher, b.wait, opts.Concurrency)
+	return b
+}
+
+// Run backfills the configured range, returning the first error
+// encountered. Blocks are only checkpointed once every block before them has
+// been cached.
+func (b *Backfiller) Run() error {
+	from, to := b.opts.From, b.opts.To
+	if from > to {
+		return fmt.Errorf("invalid block range %d-%d", from, to)
+	}
+	if !b.hWatcher.IsFinalized(to) {
+		return fmt.Errorf("block %d is not finalized yet", to)
+	}
+
+	if b.opts.RequestsPerSec > 0 {
+		b.ticker = time.NewTicker(time.Second / time.Duration(b.opts.RequestsPerSec))
+		defer b.ticker.Stop()
+	}
+
+	next := from
+	if !b.opts.Restart {
+		checkpoint, err := b.checkpoint()
+		if err != nil {
+			return err
+		}
+		if checkpoint > next {
+			b.logger.Info("resuming backfill from checkpoint", "from", from, "to", to, "checkpoint", checkpoint)
+			next = checkpoint
+		}
+	}
+
+	total := to - from + 1
+	for next <= to {
+		end := next + BackfillBatchSize - 1
+		if end > to {
+			end = to
+		}
+		if err := b.backfillBatch(next, end); err != nil {
+			return err
+		}
+
+		next = end + 1
+		if err := b.cacher.Set(b.checkpointKey(), []byte(strconv.FormatUint(next, 10))); err != nil {
+			return err
+		}
+		if b.opts.Progress != nil {
+			b.opts.Progress(next-from, total)
+		}
+	}
+
+	b.logger.Info("completed backfill", "from", from, "to", to)
+	return nil
+}
+
+func (b *Backfiller) backfillBatch(start uint64, end uint64) error {
+	blocks := make([]uint64, 0, end-start+1)
+	for number := start; number <= end; number++ {
+		blocks = append(blocks, number)
+	}
+
+	var errMtx sync.Mutex
+	var firstErr error
+	concurrent.ConsumeUint64s(blocks, func(number uint64) {
+		if err := b.backfillBlock(number); err != nil {
+			b.logger.Error("failed to backfill block", "number", number, "err", err)
+			errMtx.Lock()
+			if firstErr == nil {
+				firstErr = err
+			}
+			errMtx.Unlock()
+		}
+	}, b.opts.Concurrency)
+
+	return firstErr
+}
+
+func (b *Backfiller) backfillBlock(number uint64) error {
+	client, err := b.switcher.ETHClient()
+	if err != nil {
+		return err
+	}
+
+	b.wait()
+	blockRes, err := client.GetBlockByNumber(number, true)
+	if err != nil {
+		return err
+	}
+	// a finalized block should always exist, so a null block means the
+	// backend is behind or pruned
+	if !gjson.GetBytes(blockRes, "number").Exists() {
+		return fmt.Errorf("backend returned no block %d", number)
+	}
+	if err := b.store.CacheBlockByNumber(blockRes, true); err != nil {
+		return err
+	}
+
+	if err := b.receipts.cacheBlockReceipts(b.store, blockRes); err != nil {
+		return err
+	}
+
+	b.logger.Debug("backfilled block", "number", number)
+	return nil
+}
+
+func (b *Backfiller) wait() {
+	if b.ticker != nil {
+		<-b.ticker.C
+	}
+}
+
+// checkpoint returns the first block of the range that has not been
+// backfilled yet, or zero if there is no checkpoint.
+func (b *Backfiller) checkpoint() (uint64, error) {
+	cached, err := b.cacher.Get(b.checkpointKey())
+	if err != nil || cached == nil {
+		return 0, err
+	}
+
+	return strconv.ParseUint(string(cached), 10, 64)
+}
+
+func (b *Backfiller) checkpointKey() string {
+	return fmt.Sprintf("backfill:%d:%d", b.opts.From, b.opts.To)
+}
@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"sync/atomic"
+	"testing"
+	"github.com/kyokan/chaind/internal/backend"
+	"github.com/kyokan/chaind/pkg"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackfiller(t *testing.T) {
+	failAt := uint64(15)
+	srv := newTestChain(300, &failAt, false)
+	defer srv.Close()
+
+	sw := backend.NewSwitcher([]config.Backend{{Name: "test", URL: srv.URL, Type: pkg.EthBackend, Main: true}})
+	hWatcher := NewBlockHeightWatcher(sw)
+	hWatcher.updateBlockHeight()
+	cacher := newMemCacher()
+	store := NewETHStore(cacher, hWatcher)
+
+	opts := BackfillOptions{From: 10, To: 299}
+	require.Error(t, NewBackfiller(store, cacher, hWatcher, sw, opts).Run())
+
+	opts.To = 250
+	err := NewBackfiller(store, cacher, hWatcher, sw, opts).Run()
+	require.Error(t, err)
+	has, _ := cacher.Has(blockNumCacheKey(14, true))
+	require.True(t, has)
+	has, _ = cacher.Has(txReceiptCacheKey("0xe"))
+	require.True(t, has)
+	checkpoint, _ := cacher.Get("backfill:10:250")
+	require.Nil(t, checkpoint)
+
+	atomic.StoreUint64(&failAt, 120)
+	var done, total uint64
+	opts.Progress = func(d uint64, t uint64) {
+		done, total = d, t
+	}
+	require.Error(t, NewBackfiller(store, cacher, hWatcher, sw, opts).Run())
+	checkpoint, _ = cacher.Get("backfill:10:250")
+	require.Equal(t, "110", string(checkpoint))
+	require.Equal(t, uint64(100), done)
+	require.Equal(t, uint64(241), total)
+
+	// the resumed run starts at the checkpoint
+	atomic.StoreUint64(&failAt, 1000)
+	cacher.Del(blockNumCacheKey(50, true))
+	require.NoError(t, NewBackfiller(store, cacher, hWatcher, sw, opts).Run())
+	require.Equal(t, uint64(241), done)
+	has, _ = cacher.Has(blockNumCacheKey(50, true))
+	require.False(t, has)
+	has, _ = cacher.Has(blockNumCacheKey(250, true))
+	require.True(t, has)
+	has, _ = cacher.Has(blockNumCacheKey(251, true))
+	require.False(t, has)
+
+	opts.Restart = true
+	opts.RequestsPerSec = 1000
+	require.NoError(t, NewBackfiller(store, cacher, hWatcher, sw, opts).Run())
+	has, _ = cacher.Has(blockNumCacheKey(50, true))
+	require.True(t, has)
+}
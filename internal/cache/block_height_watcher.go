@@ -11,30 +11,59 @@ import (
 	"github.com/kyokan/chaind/pkg/jsonrpc"
 			"sync"
 	"github.com/kyokan/chaind/internal/backend"
+	"github.com/kyokan/chaind/pkg/config"
+	"sort"
 )
 
+// FinalityDepth is the default number of blocks after which a block is
+// considered final.
 const FinalityDepth = 7
 
+// MaxHeadLead is how far ahead of the median height a backend's height can
+// be before it's ignored as an outlier.
+const MaxHeadLead = 128
+
 type BlockSub func(number uint64)
 
+// Head is a new network head, along with the backend that reported it.
+type Head struct {
+	Number  uint64
+	Backend string
+}
+
+type HeadSub func(head Head)
+
+// BlockHeightWatcher polls every healthy backend for its block height. The
+// network head is the highest height reported by any of them, ignoring
+// heights more than MaxHeadLead blocks ahead of the median. It never moves
+// backwards when a backend falls behind or the switcher fails over, but does
+// once the backend that reported it becomes an outlier.
 type BlockHeightWatcher struct {
-	blockNumber uint64
+	blockNumber   uint64
+	headBackend   string
+	// headAt is when the network head last advanced, in unix nanoseconds
+	headAt        int64
+	finalityDepth uint64
 	sw          backend.Switcher
 	quitChan    chan bool
 	logger      log15.Logger
 	client      *http.Client
-	subs        map[int]BlockSub
+	heights     map[string]uint64
+	heightsMu   sync.RWMutex
+	subs        map[int]HeadSub
 	lastSub     int
 	subMu       sync.Mutex
 }
 
 func NewBlockHeightWatcher(sw backend.Switcher) *BlockHeightWatcher {
 	return &BlockHeightWatcher{
+		finalityDepth: FinalityDepth,
 		sw:       sw,
 		quitChan: make(chan bool),
 		logger:   log.NewLog("proxy/block_number_watcher"),
 		client: pkg.NewHTTPClient(5 * time.Second),
-		subs: make(map[int]BlockSub),
+		heights: make(map[string]uint64),
+		subs: make(map[int]HeadSub),
 	}
 }
 
@@ -64,14 +93,62 @@ func (b *BlockHeightWatcher) Stop() error {
 
 func (b *BlockHeightWatcher) IsFinalized(blockNum uint64) bool {
 	height := atomic.LoadUint64(&b.blockNumber)
-	return height - FinalityDepth >= blockNum
+	depth := b.FinalityDepth()
+	return height >= depth && height - depth >= blockNum
+}
+
+func (b *BlockHeightWatcher) FinalityDepth() uint64 {
+	return atomic.LoadUint64(&b.finalityDepth)
+}
+
+func (b *BlockHeightWatcher) SetFinalityDepth(depth uint64) {
+	atomic.StoreUint64(&b.finalityDepth, depth)
 }
 
+// BlockHeight returns the network head.
 func (b *BlockHeightWatcher) BlockHeight() uint64 {
 	return atomic.LoadUint64(&b.blockNumber)
 }
 
+// HeadUpdatedAt returns when the network head last advanced, or the zero time
+// if no head has been seen yet.
+func (b *BlockHeightWatcher) HeadUpdatedAt() time.Time {
+	headAt := atomic.LoadInt64(&b.headAt)
+	if headAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, headAt)
+}
+
+// BackendHeight returns the height last reported by the named backend.
+func (b *BlockHeightWatcher) BackendHeight(name string) (uint64, bool) {
+	b.heightsMu.RLock()
+	defer b.heightsMu.RUnlock()
+	height, ok := b.heights[name]
+	return height, ok
+}
+
+// Heights returns the heights last reported by each healthy backend.
+func (b *BlockHeightWatcher) Heights() map[string]uint64 {
+	b.heightsMu.RLock()
+	defer b.heightsMu.RUnlock()
+	out := make(map[string]uint64)
+	for name, height := range b.heights {
+		out[name] = height
+	}
+	return out
+}
+
+// Subscribe calls cb with the number of every new network head.
 func (b *BlockHeightWatcher) Subscribe(cb BlockSub) int {
+	return b.SubscribeHeads(func(head Head) {
+		cb(head.Number)
+	})
+}
+
+// SubscribeHeads calls cb with every new network head and the backend that
+// reported it.
+func (b *BlockHeightWatcher) SubscribeHeads(cb HeadSub) int {
 	b.subMu.Lock()
 	defer b.subMu.Unlock()
 
@@ -87,37 +164,142 @@ func (b *BlockHeightWatcher) Unsubscribe(hdl int) {
 }
 
 func (b *BlockHeightWatcher) updateBlockHeight() {
-	back, err := b.sw.BackendFor(pkg.EthBackend)
-	if err != nil {
-		b.logger.Error("no backend available", "err", err)
+	// backends outside the default pool are polled too, so that their
+	// heights are known, but don't contribute to the network head
+	backs := b.sw.HealthyBackends(pkg.EthBackend)
+	if len(backs) == 0 {
+		b.logger.Error("no backend available")
+		return
+	}
+
+	heights := make([]uint64, len(backs))
+	var wg sync.WaitGroup
+	wg.Add(len(backs))
+	for i, back := range backs {
+		go func(i int, back config.Backend) {
+			defer wg.Done()
+			height, err := b.fetchBlockHeight(back.URL)
+			if err != nil {
+				b.logger.Warn("failed to fetch block height", "backend", back.Name, "err", err)
+				return
+			}
+			heights[i] = height
+		}(i, back)
+	}
+	wg.Wait()
+
+	// backends are ordered with the current one first, so it wins ties
+	var head Head
+	latest := make(map[string]uint64)
+	outliers := make(map[string]bool)
+	defaultHeights := make([]uint64, len(backs))
+	for i, back := range backs {
+		if back.InDefaultPool() {
+			defaultHeights[i] = heights[i]
+		}
+	}
+	median := medianHeight(defaultHeights)
+	for i, back := range backs {
+		if heights[i] == 0 {
+			continue
+		}
+		latest[back.Name] = heights[i]
+		if !back.InDefaultPool() {
+			continue
+		}
+		if heights[i] > median+MaxHeadLead {
+			b.logger.Warn("ignoring outlying block height", "backend", back.Name, "height", heights[i], "median", median)
+			outliers[back.Name] = true
+			continue
+		}
+		if heights[i] > head.Number {
+			head = Head{Number: heights[i], Backend: back.Name}
+		}
+	}
+	b.heightsMu.Lock()
+	b.heights = latest
+	prevBackend := b.headBackend
+	b.heightsMu.Unlock()
+	if head.Number == 0 {
+		return
+	}
+
+	prev := atomic.LoadUint64(&b.blockNumber)
+	if head.Number <= prev {
+		// the head can't be trusted once the backend that reported it turns
+		// out to be an outlier, or stops reporting and the remaining
+		// backends would consider it one
+		_, reporting := latest[prevBackend]
+		if !outliers[prevBackend] && (reporting || prev <= median+MaxHeadLead) {
+			if head.Number < prev {
+				b.logger.Debug("ignoring block height regression", "head", prev, "height", head.Number, "backend", head.Backend)
+			}
+			return
+		}
+		b.setHead(head)
+		if head.Number == prev {
+			return
+		}
+		b.logger.Warn("lowering block height", "from", prev, "to", head.Number, "head_backend", prevBackend, "backend", head.Backend)
+		atomic.StoreInt64(&b.headAt, time.Now().UnixNano())
+		go b.notifySubs(head)
+		return
+	}
+
+	b.logger.Debug("updated block height", "from", prev, "to", head.Number, "backend", head.Backend)
+	b.setHead(head)
+	atomic.StoreInt64(&b.headAt, time.Now().UnixNano())
+	go b.notifySubs(head)
+}
+
+// medianHeight returns the lower median of the heights that were reported.
+// With two backends, that's the lower of their heights, so neither can pull
+// the head far ahead of the other on its own.
+func medianHeight(heights []uint64) uint64 {
+	var reported []uint64
+	for _, height := range heights {
+		if height != 0 {
+			reported = append(reported, height)
+		}
+	}
+	if len(reported) == 0 {
+		return 0
 	}
+	sort.Slice(reported, func(i, j int) bool {
+		return reported[i] < reported[j]
+	})
+	return reported[(len(reported)-1)/2]
+}
+
+func (b *BlockHeightWatcher) setHead(head Head) {
+	b.heightsMu.Lock()
+	b.headBackend = head.Backend
+	b.heightsMu.Unlock()
+	atomic.StoreUint64(&b.blockNumber, head.Number)
+}
 
-	client := jsonrpc.NewClient(back.URL, time.Second)
+func (b *BlockHeightWatcher) fetchBlockHeight(url string) (uint64, error) {
+	client := jsonrpc.NewClient(url, time.Second)
 	res, err := client.Call("eth_blockNumber")
 	if err != nil {
-		b.logger.Error("failed to fetch block height", "err", err)
-		return
+		return 0, err
 	}
 	var heightStr string
-	err = json.Unmarshal(res.Result, &heightStr)
-	if err != nil {
-		b.logger.Error("failed to unmarshal RPC result", "err", err)
-		return
+	if err := json.Unmarshal(res.Result, &heightStr); err != nil {
+		return 0, err
 	}
 	heightBig, err := jsonrpc.Hex2Big(heightStr)
 	if err != nil {
-		b.logger.Error("failed to create big num from response body", "err", err, "height", heightStr)
-		return
+		return 0, err
 	}
 
-	b.logger.Debug("updated block height", "from", atomic.LoadUint64(&b.blockNumber), "to", heightBig.Uint64())
-	height := heightBig.Uint64()
-	atomic.StoreUint64(&b.blockNumber, height)
-	go b.notifySubs(height)
+	return heightBig.Uint64(), nil
 }
 
-func (b *BlockHeightWatcher) notifySubs(height uint64) {
+func (b *BlockHeightWatcher) notifySubs(head Head) {
+	b.subMu.Lock()
+	defer b.subMu.Unlock()
 	for _, sub := range b.subs {
-		go sub(height)
+		go sub(head)
 	}
-}
\ No newline at end of file
+}
@@ -1,68 +1,36 @@
 package cache
 
 import (
-	"github.com/stretchr/testify/mock"
-	"github.com/kyokan/chaind/pkg"
 	"github.com/kyokan/chaind/pkg/config"
 	"net/http"
 	"net/http/httptest"
 	"github.com/stretchr/testify/suite"
 	"github.com/stretchr/testify/require"
-		"testing"
+	"testing"
+	"fmt"
+	"sync/atomic"
+	"time"
+	"github.com/kyokan/chaind/pkg/jsonrpc"
+	"github.com/kyokan/chaind/internal/backend/backendtest"
+	"github.com/kyokan/chaind/pkg"
+	"github.com/kyokan/chaind/pkg/mocknode"
 	"github.com/kyokan/chaind/internal/backend"
 )
 
-type MockBackendSwitch struct {
-	mock.Mock
-	srv  *httptest.Server
-	body []byte
-}
-
-func (m *MockBackendSwitch) SetBody(body []byte) {
-	m.body = body
-}
-
-func (m *MockBackendSwitch) Start() error {
-	m.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Write(m.body)
-	}))
-	return nil
-}
-
-func (m *MockBackendSwitch) Stop() error {
-	m.srv.Close()
-	return nil
-}
-
-func (m *MockBackendSwitch) BackendFor(t pkg.BackendType) (*config.Backend, error) {
-	return &config.Backend{
-		Type: pkg.EthBackend,
-		URL:  m.srv.URL,
-		Name: "test",
-		Main: false,
-	}, nil
-}
-
-func (m *MockBackendSwitch) ETHClient() (*backend.ETHClient, error) {
-	return nil, nil
-}
-
 type BlockHeightWatcherSuite struct {
 	suite.Suite
-	sw *MockBackendSwitch
+	srv     *httptest.Server
 	watcher *BlockHeightWatcher
 }
 
 func (s *BlockHeightWatcherSuite) SetupSuite() {
-	s.sw = new(MockBackendSwitch)
-	s.sw.SetBody([]byte("{\"jsonrpc\":\"2.0\",\"result\":\"0x123\",\"id\":1}"))
-	require.NoError(s.T(), s.sw.Start())
-	s.watcher = NewBlockHeightWatcher(s.sw)
+	s.srv = httptest.NewServer(mocknode.New(mocknode.Config{InitialHeight: 291}))
+	s.watcher = NewBlockHeightWatcher(backendtest.NewURLSwitch(s.srv.URL))
 	require.NoError(s.T(), s.watcher.Start())
 }
 
 func (s *BlockHeightWatcherSuite) TearDownSuite() {
-	require.NoError(s.T(), s.sw.Stop())
+	s.srv.Close()
 	require.NoError(s.T(), s.watcher.Stop())
 }
 
@@ -76,6 +44,198 @@ func (s *BlockHeightWatcherSuite) TestIsFinalized() {
 	require.False(s.T(), s.watcher.IsFinalized(285))
 }
 
+func (s *BlockHeightWatcherSuite) TestSetFinalityDepth() {
+	s.watcher.SetFinalityDepth(100)
+	defer s.watcher.SetFinalityDepth(FinalityDepth)
+	require.True(s.T(), s.watcher.IsFinalized(191))
+	require.False(s.T(), s.watcher.IsFinalized(192))
+
+	s.watcher.SetFinalityDepth(300)
+	require.False(s.T(), s.watcher.IsFinalized(0))
+}
+
+// heightSwitch serves a node per height, in the order given.
+type heightSwitch struct {
+	*backendtest.Switch
+	srvs []*httptest.Server
+}
+
+func newHeightSwitch(heights ...*uint64) *heightSwitch {
+	sw := &heightSwitch{Switch: backendtest.NewSwitch()}
+	var backends []config.Backend
+	for i, height := range heights {
+		height := height
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("{\"jsonrpc\":\"2.0\",\"result\":\"" + jsonrpc.Uint642Hex(atomic.LoadUint64(height)) + "\",\"id\":1}"))
+		}))
+		backends = append(backends, config.Backend{Name: fmt.Sprintf("test-%d", i), URL: srv.URL})
+		sw.srvs = append(sw.srvs, srv)
+	}
+	sw.SetBackends(backends...)
+	return sw
+}
+
+func (h *heightSwitch) Stop() error {
+	for _, srv := range h.srvs {
+		srv.Close()
+	}
+	return nil
+}
+
+func TestBlockHeightWatcher_Heads(t *testing.T) {
+	height1, height2 := uint64(3), uint64(5)
+	sw := newHeightSwitch(&height1, &height2)
+	defer sw.Stop()
+	watcher := NewBlockHeightWatcher(sw)
+	heads := make(chan Head, 10)
+	watcher.SubscribeHeads(func(head Head) {
+		heads <- head
+	})
+
+	// heights below the finality depth don't underflow
+	watcher.updateBlockHeight()
+	require.Equal(t, uint64(5), watcher.BlockHeight())
+	require.Equal(t, Head{Number: 5, Backend: "test-1"}, <-heads)
+	require.False(t, watcher.IsFinalized(0))
+	height, ok := watcher.BackendHeight("test-0")
+	require.True(t, ok)
+	require.Equal(t, uint64(3), height)
+	require.Equal(t, map[string]uint64{"test-0": 3, "test-1": 5}, watcher.Heights())
+
+	// the current backend wins ties
+	atomic.StoreUint64(&height1, 10)
+	atomic.StoreUint64(&height2, 10)
+	watcher.updateBlockHeight()
+	require.Equal(t, Head{Number: 10, Backend: "test-0"}, <-heads)
+
+	// the head never moves backwards, and isn't re-announced
+	atomic.StoreUint64(&height1, 8)
+	atomic.StoreUint64(&height2, 9)
+	watcher.updateBlockHeight()
+	require.Equal(t, uint64(10), watcher.BlockHeight())
+	require.Equal(t, map[string]uint64{"test-0": 8, "test-1": 9}, watcher.Heights())
+	select {
+	case head := <-heads:
+		t.Fatalf("unexpected head %d", head.Number)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestBlockHeightWatcher_Outliers(t *testing.T) {
+	height1, height2, height3 := uint64(100), uint64(105), uint64(100000)
+	sw := newHeightSwitch(&height1, &height2, &height3)
+	defer sw.Stop()
+	backends := sw.HealthyBackendsFor(pkg.EthBackend)
+	watcher := NewBlockHeightWatcher(sw)
+	heads := make(chan Head, 1)
+	watcher.SubscribeHeads(func(head Head) {
+		heads <- head
+	})
+
+	// heights too far ahead of the median are ignored
+	watcher.updateBlockHeight()
+	require.Equal(t, Head{Number: 105, Backend: "test-1"}, <-heads)
+	height, ok := watcher.BackendHeight("test-2")
+	require.True(t, ok)
+	require.Equal(t, uint64(100000), height)
+
+	// the head doesn't come down when the backend that reported it fails
+	sw.SetBackends(backends[0], backends[2])
+	watcher.updateBlockHeight()
+	require.Equal(t, uint64(105), watcher.BlockHeight())
+
+	// or falls behind
+	sw.SetBackends(backends...)
+	atomic.StoreUint64(&height2, 110)
+	watcher.updateBlockHeight()
+	require.Equal(t, Head{Number: 110, Backend: "test-1"}, <-heads)
+	atomic.StoreUint64(&height2, 90)
+	watcher.updateBlockHeight()
+	require.Equal(t, uint64(110), watcher.BlockHeight())
+
+	// but does once that backend turns out to be an outlier
+	atomic.StoreUint64(&height2, 300)
+	atomic.StoreUint64(&height3, 300)
+	watcher.updateBlockHeight()
+	require.Equal(t, Head{Number: 300, Backend: "test-1"}, <-heads)
+	atomic.StoreUint64(&height2, 120+MaxHeadLead+1)
+	atomic.StoreUint64(&height3, 120)
+	watcher.updateBlockHeight()
+	require.Equal(t, Head{Number: 120, Backend: "test-2"}, <-heads)
+	require.Equal(t, uint64(120), watcher.BlockHeight())
+
+	// or when it stops reporting, and the head is too far ahead of the
+	// remaining backends
+	sw.SetBackends(backends[1])
+	atomic.StoreUint64(&height2, 5000)
+	watcher.updateBlockHeight()
+	require.Equal(t, Head{Number: 5000, Backend: "test-1"}, <-heads)
+	advanced := watcher.HeadUpdatedAt()
+	sw.SetBackends(backends[0], backends[2])
+	watcher.updateBlockHeight()
+	require.Equal(t, Head{Number: 120, Backend: "test-2"}, <-heads)
+	require.Equal(t, uint64(120), watcher.BlockHeight())
+	require.False(t, watcher.HeadUpdatedAt().Before(advanced))
+}
+
+func TestBlockHeightWatcher_TaggedPools(t *testing.T) {
+	height1, height2 := uint64(100), uint64(90)
+	sw := newHeightSwitch(&height1, &height2)
+	defer sw.Stop()
+	backends := sw.HealthyBackendsFor(pkg.EthBackend)
+	backends[1].Tags = []string{"archive"}
+	sw.SetBackends(backends...)
+	watcher := NewBlockHeightWatcher(sw)
+
+	// backends that are only in other pools have their heights tracked, but
+	// don't move the head
+	watcher.updateBlockHeight()
+	height, ok := watcher.BackendHeight("test-1")
+	require.True(t, ok)
+	require.Equal(t, uint64(90), height)
+	atomic.StoreUint64(&height2, 110)
+	watcher.updateBlockHeight()
+	require.Equal(t, uint64(100), watcher.BlockHeight())
+	height, _ = watcher.BackendHeight("test-1")
+	require.Equal(t, uint64(110), height)
+}
+
+func TestBlockHeightWatcher_Failover(t *testing.T) {
+	main := mocknode.New(mocknode.Config{InitialHeight: 20})
+	backup := mocknode.New(mocknode.Config{InitialHeight: 15})
+	mainSrv := httptest.NewServer(main)
+	defer mainSrv.Close()
+	backupSrv := httptest.NewServer(backup)
+	defer backupSrv.Close()
+
+	sw := backend.NewSwitcher([]config.Backend{
+		{Name: "main", URL: mainSrv.URL, Type: pkg.EthBackend, Main: true},
+		{Name: "backup", URL: backupSrv.URL, Type: pkg.EthBackend},
+	})
+	require.NoError(t, sw.Start())
+	defer sw.Stop()
+	watcher := NewBlockHeightWatcher(sw)
+	watcher.updateBlockHeight()
+	require.Equal(t, uint64(20), watcher.BlockHeight())
+
+	main.SetFaults(mocknode.Faults{HTTPStatus: http.StatusBadGateway})
+	require.Eventually(t, func() bool {
+		back, err := sw.BackendFor(pkg.EthBackend)
+		return err == nil && back.Name == "backup"
+	}, 10*time.Second, 100*time.Millisecond)
+
+	// failing over to a backend that is behind doesn't move the head back
+	watcher.updateBlockHeight()
+	require.Equal(t, uint64(20), watcher.BlockHeight())
+	require.True(t, watcher.IsFinalized(13))
+	_, ok := watcher.BackendHeight("main")
+	require.False(t, ok)
+
+	backup.Mine(10)
+	watcher.updateBlockHeight()
+	require.Equal(t, uint64(25), watcher.BlockHeight())
+}
+
 func TestBlockHeightWatcherSuite(t *testing.T) {
 	suite.Run(t, new(BlockHeightWatcherSuite))
-}
\ No newline at end of file
+}
@@ -7,6 +7,8 @@ import (
 
 type CacheableMap map[string][]byte
 
+// Cacher stores cached responses. An expiration of NeverExpire keeps the
+// entry until it is evicted.
 type Cacher interface {
 	pkg.Service
 	Get(key string) ([]byte, error)
@@ -16,4 +18,6 @@ type Cacher interface {
 	MapGet(key string, field string) ([]byte, error)
 	MapSetEx(key string, vals CacheableMap, expiration time.Duration) error
 	Del(key string) error
+	IncrBy(key string, n int64) error
+	Scan(pattern string) ([]string, error)
 }
\ No newline at end of file
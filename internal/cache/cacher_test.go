@@ -104,6 +104,30 @@ func (c *CacherSuite) TestMapGetSetEx() {
 	require.False(c.T(), has)
 }
 
+func (c *CacherSuite) TestIncrBy() {
+	key := randStr()
+	require.NoError(c.T(), c.cacher.IncrBy(key, 1))
+	require.NoError(c.T(), c.cacher.IncrBy(key, 2))
+
+	val, err := c.cacher.Get(key)
+	require.NoError(c.T(), err)
+	require.Equal(c.T(), []byte("3"), val)
+}
+
+func (c *CacherSuite) TestScan() {
+	prefix := randStr()
+	require.NoError(c.T(), c.cacher.Set(prefix+":1", []byte("1")))
+	require.NoError(c.T(), c.cacher.Set(prefix+":2", []byte("2")))
+
+	keys, err := c.cacher.Scan(prefix + ":*")
+	require.NoError(c.T(), err)
+	require.ElementsMatch(c.T(), []string{prefix + ":1", prefix + ":2"}, keys)
+
+	keys, err = c.cacher.Scan(randStr() + ":*")
+	require.NoError(c.T(), err)
+	require.Empty(c.T(), keys)
+}
+
 func randStr() string {
 	return uuid.NewV4().String()
 }
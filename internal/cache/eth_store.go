@@ -11,22 +11,77 @@ import (
 	"strconv"
 	"encoding/binary"
 	"github.com/kyokan/chaind/pkg/log"
+	"sync"
 )
 
 type ETHStore struct {
-	cacher   Cacher
-	hWatcher *BlockHeightWatcher
-	logger   log15.Logger
+	cacher    Cacher
+	hWatcher  *BlockHeightWatcher
+	policy    *Policy
+	policyMtx sync.RWMutex
+	lookups   map[string]*lookupCounts
+	quitChan  chan bool
+	logger    log15.Logger
 }
 
 func NewETHStore(cacher Cacher, hWatcher *BlockHeightWatcher) *ETHStore {
+	lookups := make(map[string]*lookupCounts)
+	for method := range methodKeyPatterns {
+		lookups[method] = new(lookupCounts)
+	}
+
 	return &ETHStore{
 		cacher:   cacher,
 		hWatcher: hWatcher,
+		policy:   NewPolicy(nil),
+		lookups:  lookups,
+		quitChan: make(chan bool),
 		logger: log.NewLog("eth_store"),
 	}
 }
 
+// Start periodically flushes cache lookup counts to the cache.
+func (e *ETHStore) Start() error {
+	go func() {
+		ticker := time.NewTicker(statsFlushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				e.flushLookups()
+			case <-e.quitChan:
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Stop flushes any lookup counts that haven't been written yet.
+func (e *ETHStore) Stop() error {
+	e.quitChan <- true
+	e.flushLookups()
+	return nil
+}
+
+// SetPolicy replaces the store's cache policy, including the finality depth
+// used by the block height watcher. Entries that are already cached keep
+// their original TTL.
+func (e *ETHStore) SetPolicy(policy *Policy) {
+	e.policyMtx.Lock()
+	e.policy = policy
+	e.policyMtx.Unlock()
+	e.hWatcher.SetFinalityDepth(policy.FinalityDepth)
+}
+
+func (e *ETHStore) ttl(method string) time.Duration {
+	e.policyMtx.RLock()
+	defer e.policyMtx.RUnlock()
+	return e.policy.TTL(method)
+}
+
 func (e *ETHStore) GetBlockByNumber(number uint64, includeBodies bool) ([]byte, error) {
 	return e.cacher.Get(blockNumCacheKey(number, includeBodies))
 }
@@ -44,15 +99,12 @@ func (e *ETHStore) CacheBlockByNumber(data []byte, includeBodies bool) error {
 		return nil
 	}
 
-	var expiry time.Duration
-	if e.hWatcher.IsFinalized(blockNum) {
-		expiry = time.Hour
-	} else {
+	if !e.hWatcher.IsFinalized(blockNum) {
 		e.logger.Debug("not caching un-finalized block", "number", blockNum)
 		return nil
 	}
 
-	return e.cacher.SetEx(blockNumCacheKey(blockNum, includeBodies), data, expiry)
+	return e.cacher.SetEx(blockNumCacheKey(blockNum, includeBodies), data, e.ttl("eth_getBlockByNumber"))
 }
 
 func (e *ETHStore) GetTransactionReceipt(hash string) ([]byte, error) {
@@ -76,19 +128,56 @@ func (e *ETHStore) CacheTransactionReceipt(data []byte) error {
 		return errors.New("failed to parse block number")
 	}
 
-	var expiry time.Duration
-	if e.hWatcher.IsFinalized(blockNum) {
-		expiry = time.Hour
-	} else {
+	if !e.hWatcher.IsFinalized(blockNum) {
 		e.logger.Debug("not caching un-finalized tx receipt", "hash", txHash, "number", blockNum)
 		return nil
 	}
 
-	return e.cacher.SetEx(txReceiptCacheKey(txHash), data, expiry)
+	return e.cacher.SetEx(txReceiptCacheKey(txHash), data, e.ttl("eth_getTransactionReceipt"))
+}
+
+// accountState describes how an account state method answered at the latest
+// block is cached.
+type accountState struct {
+	prefix string
+	field  string
+}
+
+var accountStates = map[string]accountState{
+	"eth_getBalance":          {"balance", "balance"},
+	"eth_getTransactionCount": {"nonce", "count"},
+	"eth_getCode":             {"code", "code"},
 }
 
 func (e *ETHStore) GetBalance(address string) ([]byte, error) {
-	ck := balanceCacheKey(address)
+	return e.getAccountState("eth_getBalance", address)
+}
+
+func (e *ETHStore) CacheBalance(address string, data []byte) error {
+	return e.cacheAccountState("eth_getBalance", address, e.hWatcher.BlockHeight(), data)
+}
+
+func (e *ETHStore) GetTransactionCount(address string) ([]byte, error) {
+	return e.getAccountState("eth_getTransactionCount", address)
+}
+
+func (e *ETHStore) CacheTransactionCount(address string, data []byte) error {
+	return e.cacheAccountState("eth_getTransactionCount", address, e.hWatcher.BlockHeight(), data)
+}
+
+func (e *ETHStore) GetCode(address string) ([]byte, error) {
+	return e.getAccountState("eth_getCode", address)
+}
+
+func (e *ETHStore) CacheCode(address string, data []byte) error {
+	return e.cacheAccountState("eth_getCode", address, e.hWatcher.BlockHeight(), data)
+}
+
+// getAccountState returns the cached response to the method, as long as it
+// was cached at the current block height.
+func (e *ETHStore) getAccountState(method string, address string) ([]byte, error) {
+	state := accountStates[method]
+	ck := accountStateCacheKey(state.prefix, address)
 	heightBytes, err := e.cacher.MapGet(ck, "blockNumber")
 	if err != nil {
 		return nil, err
@@ -98,26 +187,46 @@ func (e *ETHStore) GetBalance(address string) ([]byte, error) {
 		return nil, nil
 	}
 
-	cached, err := e.cacher.MapGet(ck, "balance")
+	cached, err := e.cacher.MapGet(ck, state.field)
 	if err != nil {
 		return nil, err
 	}
 	if cached == nil {
-		return nil, errors.New("balance is nil, but blockNum isn't")
+		return nil, fmt.Errorf("%s is nil, but blockNum isn't", state.field)
 	}
 
 	return cached, nil
 }
 
-func (e *ETHStore) CacheBalance(address string, data []byte) error {
-	height := e.hWatcher.BlockHeight()
+func (e *ETHStore) cacheAccountState(method string, address string, height uint64, data []byte) error {
+	state := accountStates[method]
 	var blockNumBytes [8]byte
 	binary.PutUvarint(blockNumBytes[:], height)
 
-	return e.cacher.MapSetEx(balanceCacheKey(address), map[string][]byte{
-		"balance": data,
+	return e.cacher.MapSetEx(accountStateCacheKey(state.prefix, address), map[string][]byte{
+		state.field: data,
 		"blockNumber": blockNumBytes[:],
-	}, time.Minute)
+	}, e.ttl(method))
+}
+
+// GetLogs returns every log emitted in the block.
+func (e *ETHStore) GetLogs(blockNum uint64) ([]byte, error) {
+	return e.cacher.Get(logsCacheKey(blockNum))
+}
+
+// CacheLogs caches every log emitted in the block. data must be the response
+// to an eth_getLogs call without address or topic filters.
+func (e *ETHStore) CacheLogs(blockNum uint64, data []byte) error {
+	if !gjson.ParseBytes(data).IsArray() {
+		e.logger.Debug("skipping post-processing for invalid logs")
+		return nil
+	}
+	if !e.hWatcher.IsFinalized(blockNum) {
+		e.logger.Debug("not caching un-finalized logs", "number", blockNum)
+		return nil
+	}
+
+	return e.cacher.SetEx(logsCacheKey(blockNum), data, e.ttl("eth_getLogs"))
 }
 
 func blockNumCacheKey(blockNum uint64, includeBodies bool) string {
@@ -129,5 +238,13 @@ func txReceiptCacheKey(hash string) string {
 }
 
 func balanceCacheKey(addr string) string {
-	return fmt.Sprintf("balance:%s:latest", strings.ToLower(addr))
+	return accountStateCacheKey("balance", addr)
+}
+
+func accountStateCacheKey(prefix string, addr string) string {
+	return fmt.Sprintf("%s:%s:latest", prefix, strings.ToLower(addr))
+}
+
+func logsCacheKey(blockNum uint64) string {
+	return fmt.Sprintf("logs:%d", blockNum)
 }
\ No newline at end of file
@@ -0,0 +1,226 @@
+package cache
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"github.com/tidwall/gjson"
+	"sync/atomic"
+	"time"
+)
+
+// statsFlushInterval is how often lookup counts are written to the cache.
+const statsFlushInterval = 10 * time.Second
+
+// methodKeyPatterns maps each cached method to the keys its responses are
+// stored under.
+var methodKeyPatterns = map[string]string{
+	"eth_getBalance":            "balance:*",
+	"eth_getBlockByNumber":      "block:*",
+	"eth_getCode":               "code:*",
+	"eth_getLogs":               "logs:*",
+	"eth_getTransactionCount":   "nonce:*",
+	"eth_getTransactionReceipt": "txreceipt:*",
+}
+
+type MethodStats struct {
+	Method string
+	Keys   int
+	Hits   uint64
+	Misses uint64
+}
+
+// HitRatio returns the fraction of lookups that were served from the cache.
+func (m *MethodStats) HitRatio() float64 {
+	total := m.Hits + m.Misses
+	if total == 0 {
+		return 0
+	}
+
+	return float64(m.Hits) / float64(total)
+}
+
+// lookupCounts holds the hits and misses of a method that haven't been
+// flushed to the cache yet.
+type lookupCounts struct {
+	hits   uint64
+	misses uint64
+}
+
+// RecordLookup counts a cache hit or miss for the method. Counts are kept in
+// memory and flushed to the cache periodically, so that they can be read from
+// other processes without a cache write on every request.
+func (e *ETHStore) RecordLookup(method string, hit bool) {
+	counts, ok := e.lookups[method]
+	if !ok {
+		return
+	}
+
+	if hit {
+		atomic.AddUint64(&counts.hits, 1)
+	} else {
+		atomic.AddUint64(&counts.misses, 1)
+	}
+}
+
+func (e *ETHStore) flushLookups() {
+	for method, counts := range e.lookups {
+		e.flushCounter(method, true, &counts.hits)
+		e.flushCounter(method, false, &counts.misses)
+	}
+}
+
+func (e *ETHStore) flushCounter(method string, hit bool, counter *uint64) {
+	n := atomic.SwapUint64(counter, 0)
+	if n == 0 {
+		return
+	}
+	if err := e.cacher.IncrBy(statsKey(method, hit), int64(n)); err != nil {
+		// keep the counts so that the next flush can retry them
+		atomic.AddUint64(counter, n)
+		e.logger.Warn("failed to record cache lookups", "method", method, "err", err)
+	}
+}
+
+// Stats returns key counts and hit/miss counts for every cached method.
+func (e *ETHStore) Stats() ([]MethodStats, error) {
+	var out []MethodStats
+	for method, pattern := range methodKeyPatterns {
+		keys, err := e.cacher.Scan(pattern)
+		if err != nil {
+			return nil, err
+		}
+		hits, err := e.readCounter(statsKey(method, true))
+		if err != nil {
+			return nil, err
+		}
+		misses, err := e.readCounter(statsKey(method, false))
+		if err != nil {
+			return nil, err
+		}
+
+		// include lookups that haven't been flushed yet
+		if counts, ok := e.lookups[method]; ok {
+			hits += atomic.LoadUint64(&counts.hits)
+			misses += atomic.LoadUint64(&counts.misses)
+		}
+
+		out = append(out, MethodStats{
+			Method: method,
+			Keys:   len(keys),
+			Hits:   hits,
+			Misses: misses,
+		})
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Method < out[j].Method
+	})
+	return out, nil
+}
+
+// GetCachedBalance returns the cached balance of the address along with the
+// block height it was cached at, regardless of whether it is still current.
+func (e *ETHStore) GetCachedBalance(address string) ([]byte, uint64, error) {
+	ck := balanceCacheKey(address)
+	balance, err := e.cacher.MapGet(ck, "balance")
+	if err != nil || balance == nil {
+		return nil, 0, err
+	}
+	heightBytes, err := e.cacher.MapGet(ck, "blockNumber")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	height, _ := binary.Uvarint(heightBytes)
+	return balance, height, nil
+}
+
+// PurgeBlocks removes the cached blocks in the inclusive range, along with
+// the receipts of any transactions found in them. It returns the number of
+// keys removed.
+func (e *ETHStore) PurgeBlocks(from uint64, to uint64) (int, error) {
+	if from > to {
+		return 0, fmt.Errorf("invalid block range %d-%d", from, to)
+	}
+
+	var keys []string
+	for number := from; number <= to; number++ {
+		block, err := e.cacher.Get(blockNumCacheKey(number, true))
+		if err != nil {
+			return 0, err
+		}
+		for _, hash := range gjson.GetBytes(block, "transactions.#.hash").Array() {
+			keys = append(keys, txReceiptCacheKey(hash.String()))
+		}
+		keys = append(keys, blockNumCacheKey(number, true), blockNumCacheKey(number, false))
+	}
+
+	return e.purge(keys)
+}
+
+// PurgeTransactionReceipt removes the cached receipt of the transaction.
+func (e *ETHStore) PurgeTransactionReceipt(hash string) (int, error) {
+	return e.purge([]string{txReceiptCacheKey(hash)})
+}
+
+// PurgeAddress removes the cached balance, nonce and code of the address.
+func (e *ETHStore) PurgeAddress(address string) (int, error) {
+	var keys []string
+	for _, state := range accountStates {
+		keys = append(keys, accountStateCacheKey(state.prefix, address))
+	}
+	return e.purge(keys)
+}
+
+// PurgeMethod removes every cached response to the method.
+func (e *ETHStore) PurgeMethod(method string) (int, error) {
+	pattern, ok := methodKeyPatterns[method]
+	if !ok {
+		return 0, fmt.Errorf("%s responses are not cached", method)
+	}
+
+	keys, err := e.cacher.Scan(pattern)
+	if err != nil {
+		return 0, err
+	}
+	return e.purge(keys)
+}
+
+func (e *ETHStore) purge(keys []string) (int, error) {
+	var count int
+	for _, key := range keys {
+		has, err := e.cacher.Has(key)
+		if err != nil {
+			return count, err
+		}
+		if !has {
+			continue
+		}
+		if err := e.cacher.Del(key); err != nil {
+			return count, err
+		}
+		e.logger.Debug("purged cache key", "key", key)
+		count++
+	}
+
+	return count, nil
+}
+
+func (e *ETHStore) readCounter(key string) (uint64, error) {
+	val, err := e.cacher.Get(key)
+	if err != nil || val == nil {
+		return 0, err
+	}
+
+	return strconv.ParseUint(string(val), 10, 64)
+}
+
+func statsKey(method string, hit bool) string {
+	if hit {
+		return fmt.Sprintf("stats:hits:%s", method)
+	}
+
+	return fmt.Sprintf("stats:misses:%s", method)
+}
@@ -0,0 +1,201 @@
+package cache
+
+import (
+	"path"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+	"github.com/stretchr/testify/require"
+)
+
+// memCacher is an in-memory Cacher that ignores expirations.
+type memCacher struct {
+	vals map[string][]byte
+	maps map[string]CacheableMap
+	mtx  sync.Mutex
+}
+
+func newMemCacher() *memCacher {
+	return &memCacher{
+		vals: make(map[string][]byte),
+		maps: make(map[string]CacheableMap),
+	}
+}
+
+func (m *memCacher) Start() error { return nil }
+
+func (m *memCacher) Stop() error { return nil }
+
+func (m *memCacher) Get(key string) ([]byte, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return m.vals[key], nil
+}
+
+func (m *memCacher) Set(key string, value []byte) error {
+	return m.SetEx(key, value, NeverExpire)
+}
+
+func (m *memCacher) SetEx(key string, value []byte, expiration time.Duration) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.vals[key] = value
+	return nil
+}
+
+func (m *memCacher) Has(key string) (bool, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	_, ok := m.vals[key]
+	_, mapOk := m.maps[key]
+	return ok || mapOk, nil
+}
+
+func (m *memCacher) MapGet(key string, field string) ([]byte, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	return m.maps[key][field], nil
+}
+
+func (m *memCacher) MapSetEx(key string, vals CacheableMap, expiration time.Duration) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.maps[key] = vals
+	return nil
+}
+
+func (m *memCacher) Del(key string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	delete(m.vals, key)
+	delete(m.maps, key)
+	return nil
+}
+
+func (m *memCacher) IncrBy(key string, n int64) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	val, _ := strconv.ParseInt(string(m.vals[key]), 10, 64)
+	m.vals[key] = []byte(strconv.FormatInt(val+n, 10))
+	return nil
+}
+
+func (m *memCacher) Scan(pattern string) ([]string, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	var keys []string
+	for key := range m.vals {
+		if ok, _ := path.Match(pattern, key); ok {
+			keys = append(keys, key)
+		}
+	}
+	for key := range m.maps {
+		if ok, _ := path.Match(pattern, key); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func TestETHStore_Purge(t *testing.T) {
+	cacher := newMemCacher()
+	store := NewETHStore(cacher, nil)
+	cacher.Set(blockNumCacheKey(10, true), []byte("{\"number\":\"0xa\",\"transactions\":[{\"hash\":\"0xAB\"}]}"))
+	cacher.Set(blockNumCacheKey(10, false), []byte("{\"number\":\"0xa\"}"))
+	cacher.Set(blockNumCacheKey(11, false), []byte("{\"number\":\"0xb\"}"))
+	cacher.Set(blockNumCacheKey(12, false), []byte("{\"number\":\"0xc\"}"))
+	cacher.Set(txReceiptCacheKey("0xab"), []byte("{}"))
+	cacher.Set(txReceiptCacheKey("0xcd"), []byte("{}"))
+	cacher.MapSetEx(balanceCacheKey("0x01"), CacheableMap{"balance": []byte("\"0x1\"")}, time.Minute)
+	cacher.MapSetEx(accountStateCacheKey("nonce", "0x01"), CacheableMap{"count": []byte("\"0x2\"")}, time.Minute)
+	cacher.MapSetEx(accountStateCacheKey("code", "0x01"), CacheableMap{"code": []byte("\"0x\"")}, time.Minute)
+
+	_, err := store.PurgeBlocks(11, 10)
+	require.Error(t, err)
+	count, err := store.PurgeBlocks(10, 11)
+	require.NoError(t, err)
+	require.Equal(t, 4, count)
+	has, _ := cacher.Has(blockNumCacheKey(12, false))
+	require.True(t, has)
+
+	count, err = store.PurgeTransactionReceipt("0xCD")
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+
+	balance, _, err := store.GetCachedBalance("0x01")
+	require.NoError(t, err)
+	require.Equal(t, []byte("\"0x1\""), balance)
+	count, err = store.PurgeAddress("0x01")
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+	balance, _, err = store.GetCachedBalance("0x01")
+	require.NoError(t, err)
+	require.Nil(t, balance)
+	has, _ = cacher.Has(accountStateCacheKey("nonce", "0x01"))
+	require.False(t, has)
+
+	count, err = store.PurgeMethod("eth_getBlockByNumber")
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+	_, err = store.PurgeMethod("eth_call")
+	require.Error(t, err)
+}
+
+func TestETHStore_Stats(t *testing.T) {
+	cacher := newMemCacher()
+	store := NewETHStore(cacher, nil)
+	cacher.Set(blockNumCacheKey(10, true), []byte("{}"))
+	cacher.Set(blockNumCacheKey(11, true), []byte("{}"))
+	cacher.IncrBy(statsKey("eth_getBlockByNumber", true), 2)
+	cacher.IncrBy(statsKey("eth_getBlockByNumber", false), 1)
+	// lookups that haven't been flushed yet are counted too
+	store.RecordLookup("eth_getBlockByNumber", true)
+
+	stats, err := store.Stats()
+	require.NoError(t, err)
+	require.Len(t, stats, 6)
+	require.Equal(t, "eth_getBlockByNumber", stats[1].Method)
+	require.Equal(t, 2, stats[1].Keys)
+	require.Equal(t, uint64(3), stats[1].Hits)
+	require.Equal(t, uint64(1), stats[1].Misses)
+	require.Equal(t, 0.75, stats[1].HitRatio())
+	require.Equal(t, 0.0, stats[0].HitRatio())
+}
+
+func TestETHStore_FlushLookups(t *testing.T) {
+	cacher := newMemCacher()
+	store := NewETHStore(cacher, nil)
+	for i := 0; i < 3; i++ {
+		store.RecordLookup("eth_getTransactionReceipt", true)
+	}
+	store.RecordLookup("eth_getTransactionReceipt", false)
+	store.RecordLookup("eth_call", true)
+
+	// nothing is written until the counts are flushed
+	val, err := cacher.Get(statsKey("eth_getTransactionReceipt", true))
+	require.NoError(t, err)
+	require.Nil(t, val)
+
+	require.NoError(t, store.Start())
+	require.NoError(t, store.Stop())
+	val, err = cacher.Get(statsKey("eth_getTransactionReceipt", true))
+	require.NoError(t, err)
+	require.Equal(t, []byte("3"), val)
+	val, err = cacher.Get(statsKey("eth_getTransactionReceipt", false))
+	require.NoError(t, err)
+	require.Equal(t, []byte("1"), val)
+	has, err := cacher.Has(statsKey("eth_call", true))
+	require.NoError(t, err)
+	require.False(t, has)
+
+	// flushed counts aren't counted twice
+	stats, err := store.Stats()
+	require.NoError(t, err)
+	for _, stat := range stats {
+		if stat.Method == "eth_getTransactionReceipt" {
+			require.Equal(t, uint64(3), stat.Hits)
+			require.Equal(t, uint64(1), stat.Misses)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package cache
+
+import (
+	"time"
+	"github.com/kyokan/chaind/pkg/config"
+)
+
+// NeverExpire is the TTL of cache entries that are kept until evicted.
+const NeverExpire time.Duration = 0
+
+// DefaultTTLs are used for methods without a configured TTL.
+var DefaultTTLs = map[string]time.Duration{
+	"eth_getBalance":            time.Minute,
+	"eth_getBlockByNumber":      time.Hour,
+	"eth_getCode":               time.Minute,
+	"eth_getLogs":               time.Hour,
+	"eth_getTransactionCount":   time.Minute,
+	"eth_getTransactionReceipt": time.Hour,
+}
+
+// Policy decides when blocks are final enough to cache, and how long cached
+// responses live.
+type Policy struct {
+	FinalityDepth uint64
+	TTLs          map[string]time.Duration
+}
+
+func NewPolicy(cfg *config.CacheConfig) *Policy {
+	p := &Policy{
+		FinalityDepth: FinalityDepth,
+		TTLs:          make(map[string]time.Duration),
+	}
+	for method, ttl := range DefaultTTLs {
+		p.TTLs[method] = ttl
+	}
+	if cfg == nil {
+		return p
+	}
+
+	if cfg.FinalityDepth > 0 {
+		p.FinalityDepth = cfg.FinalityDepth
+	}
+	for _, ttl := range cfg.TTLs {
+		if ttl.NeverExpire {
+			p.TTLs[ttl.Method] = NeverExpire
+		} else {
+			p.TTLs[ttl.Method] = time.Duration(ttl.TTLSecs) * time.Second
+		}
+	}
+	return p
+}
+
+// TTL returns how long responses to the given method are cached for.
+func (p *Policy) TTL(method string) time.Duration {
+	return p.TTLs[method]
+}
@@ -0,0 +1,30 @@
+package cache
+
+import (
+	"testing"
+	"time"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewPolicy(t *testing.T) {
+	p := NewPolicy(nil)
+	require.Equal(t, uint64(FinalityDepth), p.FinalityDepth)
+	require.Equal(t, time.Hour, p.TTL("eth_getBlockByNumber"))
+	require.Equal(t, time.Minute, p.TTL("eth_getBalance"))
+
+	p = NewPolicy(&config.CacheConfig{
+		FinalityDepth: 64,
+		TTLs: []config.CacheTTL{
+			{Method: "eth_getBlockByNumber", NeverExpire: true},
+			{Method: "eth_getBalance", TTLSecs: 5},
+		},
+	})
+	require.Equal(t, uint64(64), p.FinalityDepth)
+	require.Equal(t, NeverExpire, p.TTL("eth_getBlockByNumber"))
+	require.Equal(t, time.Hour, p.TTL("eth_getTransactionReceipt"))
+	require.Equal(t, 5*time.Second, p.TTL("eth_getBalance"))
+
+	// the defaults aren't modified
+	require.Equal(t, time.Hour, DefaultTTLs["eth_getBlockByNumber"])
+}
@@ -0,0 +1,193 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"github.com/inconshreveable/log15"
+	"github.com/kyokan/chaind/internal/backend"
+	"github.com/kyokan/chaind/pkg"
+	"github.com/kyokan/chaind/pkg/jsonrpc"
+	"github.com/kyokan/chaind/pkg/log"
+	"github.com/kyokan/chaind/pkg/concurrent"
+	"github.com/tidwall/gjson"
+)
+
+// ReceiptBatchSize is the maximum number of receipts requested in a single
+// batch request.
+const ReceiptBatchSize = 100
+
+// blockReceiptMethods return every receipt in a block in one call. They are
+// tried in order before falling back to batched eth_getTransactionReceipt
+// calls.
+var blockReceiptMethods = []string{"eth_getBlockReceipts", "parity_getBlockReceipts"}
+
+// batchMethod is the key used to remember backends that reject batch
+// requests. Receipts are fetched from them one call at a time.
+const batchMethod = "batch"
+
+// receiptFetcher fetches the receipts in a block with as few requests as the
+// backend supports, and remembers which backends don't support the block
+// receipt methods or batch requests.
+type receiptFetcher struct {
+	switcher    backend.Switcher
+	wait        func()
+	concurrency int
+	unsupported map[string]bool
+	mtx         sync.Mutex
+	logger      log15.Logger
+}
+
+// newReceiptFetcher returns a receiptFetcher that calls wait, if set, before
+// every request to the backend. When receipts have to be fetched one at a
+// time, at most concurrency of them are requested at once.
+func newReceiptFetcher(switcher backend.Switcher, wait func(), concurrency int) *receiptFetcher {
+	if wait == nil {
+		wait = func() {}
+	}
+	if concurrency <= 0 {
+		concurrency = WarmUpConcurrency
+	}
+
+	return &receiptFetcher{
+		switcher:    switcher,
+		wait:        wait,
+		concurrency: concurrency,
+		unsupported: make(map[string]bool),
+		logger:      log.NewLog("receipt_fetcher"),
+	}
+}
+
+// cacheBlockReceipts fetches and caches the receipts of every transaction in
+// the block, returning an error unless all of them were stored.
+func (r *receiptFetcher) cacheBlockReceipts(store *ETHStore, block []byte) error {
+	number, err := jsonrpc.Hex2Uint64(gjson.GetBytes(block, "number").String())
+	if err != nil {
+		return err
+	}
+	hashRes := gjson.GetBytes(block, "transactions.#.hash").Array()
+	if len(hashRes) == 0 {
+		return nil
+	}
+	hashes := make([]string, len(hashRes))
+	for i, hash := range hashRes {
+		hashes[i] = hash.String()
+	}
+
+	receipts, err := r.fetch(number, hashes)
+	if err != nil {
+		return err
+	}
+	for i, receipt := range receipts {
+		if !gjson.ParseBytes(receipt).Exists() {
+			return fmt.Errorf("backend returned no receipt for transaction %s", hashes[i])
+		}
+		if err := store.CacheTransactionReceipt(receipt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *receiptFetcher) fetch(number uint64, hashes []string) ([]json.RawMessage, error) {
+	back, err := r.switcher.BackendFor(pkg.EthBackend)
+	if err != nil {
+		return nil, err
+	}
+	client := backend.NewETHClient(back.URL)
+
+	for _, method := range blockReceiptMethods {
+		if r.isUnsupported(back.Name, method) {
+			continue
+		}
+
+		r.wait()
+		receipts, err := client.GetBlockReceipts(method, number)
+		if err == backend.ErrMethodNotFound {
+			r.logger.Info("backend does not support block receipts method", "backend", back.Name, "method", method)
+			r.setUnsupported(back.Name, method)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(receipts) != len(hashes) {
+			return nil, fmt.Errorf("expected %d receipts in block %d, got %d", len(hashes), number, len(receipts))
+		}
+		return receipts, nil
+	}
+
+	if !r.isUnsupported(back.Name, batchMethod) {
+		receipts, err := r.fetchBatches(client, hashes)
+		if err != jsonrpc.ErrBatchUnsupported {
+			return receipts, err
+		}
+		r.logger.Info("backend does not support batch requests", "backend", back.Name)
+		r.setUnsupported(back.Name, batchMethod)
+	}
+
+	return r.fetchEach(client, hashes)
+}
+
+func (r *receiptFetcher) fetchBatches(client *backend.ETHClient, hashes []string) ([]json.RawMessage, error) {
+	receipts := make([]json.RawMessage, 0, len(hashes))
+	for start := 0; start < len(hashes); start += ReceiptBatchSize {
+		end := start + ReceiptBatchSize
+		if end > len(hashes) {
+			end = len(hashes)
+		}
+
+		r.wait()
+		batch, err := client.GetTransactionReceipts(hashes[start:end])
+		if err != nil {
+			return nil, err
+		}
+		receipts = append(receipts, batch...)
+	}
+	return receipts, nil
+}
+
+// fetchEach fetches the receipts with individual eth_getTransactionReceipt
+// calls, returning the first error encountered.
+func (r *receiptFetcher) fetchEach(client *backend.ETHClient, hashes []string) ([]json.RawMessage, error) {
+	receipts := make([]json.RawMessage, len(hashes))
+	indices := make([]interface{}, len(hashes))
+	for i := range hashes {
+		indices[i] = i
+	}
+
+	var firstErr error
+	var errMtx sync.Mutex
+	concurrent.Consume(indices, func(item interface{}) {
+		i := item.(int)
+		r.wait()
+		receipt, err := client.GetTransactionReceipt(hashes[i])
+		if err != nil {
+			errMtx.Lock()
+			if firstErr == nil {
+				firstErr = err
+			}
+			errMtx.Unlock()
+			return
+		}
+		receipts[i] = receipt
+	}, r.concurrency)
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return receipts, nil
+}
+
+func (r *receiptFetcher) isUnsupported(backendName string, method string) bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.unsupported[backendName+":"+method]
+}
+
+func (r *receiptFetcher) setUnsupported(backendName string, method string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	r.unsupported[backendName+":"+method] = true
+}
@@ -0,0 +1,163 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"github.com/kyokan/chaind/internal/backend"
+	"github.com/kyokan/chaind/pkg"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/kyokan/chaind/pkg/jsonrpc"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+// newTestChain serves a chain of the given height where every block contains
+// a single transaction whose hash is the block number, and every account has
+// the same state. Requests for blocks at
+// or above failAt fail. Batch requests are supported, and
+// eth_getBlockReceipts is only supported if blockReceipts is true.
+func newTestChain(height uint64, failAt *uint64, blockReceipts bool) *httptest.Server {
+	receipt := func(hash string) string {
+		return fmt.Sprintf("{\"transactionHash\":\"%s\",\"blockNumber\":\"%s\"}", hash, hash)
+	}
+	respond := func(req gjson.Result) string {
+		var result string
+		switch req.Get("method").String() {
+		case "eth_blockNumber":
+			result = fmt.Sprintf("\"%s\"", jsonrpc.Uint642Hex(height))
+		case "eth_getBlockByNumber":
+			number, _ := jsonrpc.Hex2Uint64(req.Get("params.0").String())
+			if number >= atomic.LoadUint64(failAt) {
+				return ""
+			}
+			result = fmt.Sprintf("{\"number\":\"%s\",\"transactions\":[{\"hash\":\"0x%x\"}]}", jsonrpc.Uint642Hex(number), number)
+		case "eth_getBlockReceipts":
+			if !blockReceipts {
+				return fmt.Sprintf("{\"jsonrpc\":\"2.0\",\"error\":{\"code\":%d,\"message\":\"method not found\"},\"id\":%s}", jsonrpc.MethodNotFoundCode, req.Get("id").Raw)
+			}
+			result = "[" + receipt(req.Get("params.0").String()) + "]"
+		case "eth_getTransactionReceipt":
+			result = receipt(req.Get("params.0").String())
+		case "eth_getLogs":
+			result = fmt.Sprintf("[{\"blockNumber\":\"%s\"}]", req.Get("params.0.fromBlock").String())
+		case "eth_getBalance":
+			result = "\"0x1\""
+		case "eth_getTransactionCount":
+			result = "\"0x2\""
+		case "eth_getCode":
+			result = "\"0x\""
+		default:
+			return fmt.Sprintf("{\"jsonrpc\":\"2.0\",\"error\":{\"code\":%d,\"message\":\"method not found\"},\"id\":%s}", jsonrpc.MethodNotFoundCode, req.Get("id").Raw)
+		}
+		return "{\"jsonrpc\":\"2.0\",\"result\":" + result + ",\"id\":" + req.Get("id").Raw + "}"
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := gjson.ParseBytes(mustReadAll(r))
+		if !body.IsArray() {
+			w.Write([]byte(respond(body)))
+			return
+		}
+
+		var out []json.RawMessage
+		for _, req := range body.Array() {
+			out = append(out, json.RawMessage(respond(req)))
+		}
+		res, _ := json.Marshal(out)
+		w.Write(res)
+	}))
+}
+
+func mustReadAll(r *http.Request) []byte {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		panic(err)
+	}
+	return body
+}
+
+func TestReceiptFetcher(t *testing.T) {
+	failAt := uint64(1000)
+	for _, blockReceipts := range []bool{true, false} {
+		srv := newTestChain(100, &failAt, blockReceipts)
+		sw := backend.NewSwitcher([]config.Backend{{Name: "test", URL: srv.URL, Type: pkg.EthBackend, Main: true}})
+		fetcher := newReceiptFetcher(sw, nil, 0)
+
+		receipts, err := fetcher.fetch(10, []string{"0xa"})
+		require.NoError(t, err)
+		require.Len(t, receipts, 1)
+		require.Equal(t, "0xa", gjson.GetBytes(receipts[0], "transactionHash").String())
+		require.Equal(t, !blockReceipts, fetcher.isUnsupported("test", "eth_getBlockReceipts"))
+		require.Equal(t, !blockReceipts, fetcher.isUnsupported("test", "parity_getBlockReceipts"))
+
+		hashes := make([]string, ReceiptBatchSize+1)
+		for i := range hashes {
+			hashes[i] = fmt.Sprintf("0x%x", i)
+		}
+		if blockReceipts {
+			_, err = fetcher.fetch(10, hashes)
+			require.Error(t, err)
+		} else {
+			receipts, err = fetcher.fetch(10, hashes)
+			require.NoError(t, err)
+			require.Len(t, receipts, len(hashes))
+			require.Equal(t, "0x64", gjson.GetBytes(receipts[100], "transactionHash").String())
+		}
+		srv.Close()
+	}
+}
+
+func TestReceiptFetcher_BatchUnsupported(t *testing.T) {
+	failAt := uint64(1000)
+	chain := newTestChain(100, &failAt, false)
+	defer chain.Close()
+
+	// rejects batches with a single error object, like backends that don't
+	// support them
+	var batches, calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := mustReadAll(r)
+		if gjson.ParseBytes(body).IsArray() {
+			atomic.AddInt32(&batches, 1)
+			w.Write([]byte("{\"jsonrpc\":\"2.0\",\"error\":{\"code\":-32600,\"message\":\"batch requests are not supported\"},\"id\":null}"))
+			return
+		}
+		if gjson.GetBytes(body, "method").String() == "eth_getTransactionReceipt" {
+			atomic.AddInt32(&calls, 1)
+		}
+		res, err := http.Post(chain.URL, "application/json", bytes.NewReader(body))
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer res.Body.Close()
+		io.Copy(w, res.Body)
+	}))
+	defer srv.Close()
+
+	sw := backend.NewSwitcher([]config.Backend{{Name: "test", URL: srv.URL, Type: pkg.EthBackend, Main: true}})
+	fetcher := newReceiptFetcher(sw, nil, 2)
+	hashes := []string{"0x1", "0x2", "0x3"}
+	receipts, err := fetcher.fetch(10, hashes)
+	require.NoError(t, err)
+	require.Len(t, receipts, len(hashes))
+	for i, receipt := range receipts {
+		require.Equal(t, hashes[i], gjson.GetBytes(receipt, "transactionHash").String())
+	}
+	require.True(t, fetcher.isUnsupported("test", batchMethod))
+	require.Equal(t, int32(1), atomic.LoadInt32(&batches))
+	require.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	// batches aren't tried again once the backend has rejected one
+	_, err = fetcher.fetch(11, hashes)
+	require.NoError(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&batches))
+	require.Equal(t, int32(6), atomic.LoadInt32(&calls))
+}
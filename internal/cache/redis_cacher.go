@@ -4,8 +4,22 @@ import (
 	"time"
 	"github.com/go-redis/redis"
 	"github.com/kyokan/chaind/pkg/config"
+	"github.com/kyokan/chaind/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+var cacheOpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:      "eth_cache_operation_duration_seconds",
+	Subsystem: metrics.Subsystem,
+	Help:      "Duration of cache operations, by operation.",
+	Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 16),
+}, []string{"operation"})
+
+func observeOp(op string, start time.Time) {
+	cacheOpDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+}
+
 type RedisCacher struct {
 	client *redis.Client
 }
@@ -30,6 +44,7 @@ func (r *RedisCacher) Stop() error {
 }
 
 func (r *RedisCacher) Get(key string) ([]byte, error) {
+	defer observeOp("get", time.Now())
 	res, err := r.client.Get(key).Result()
 	if err == redis.Nil {
 		return nil, nil
@@ -42,14 +57,17 @@ func (r *RedisCacher) Get(key string) ([]byte, error) {
 }
 
 func (r *RedisCacher) Set(key string, value []byte) error {
+	defer observeOp("set", time.Now())
 	return r.client.Set(key, value, 0).Err()
 }
 
 func (r *RedisCacher) SetEx(key string, value []byte, expiration time.Duration) error {
+	defer observeOp("set_ex", time.Now())
 	return r.client.Set(key, value, expiration).Err()
 }
 
 func (r *RedisCacher) Has(key string) (bool, error) {
+	defer observeOp("has", time.Now())
 	res, err := r.client.Exists(key).Result()
 	if err != nil {
 		return false, err
@@ -59,6 +77,7 @@ func (r *RedisCacher) Has(key string) (bool, error) {
 }
 
 func (r *RedisCacher) MapGet(key string, field string) ([]byte, error) {
+	defer observeOp("map_get", time.Now())
 	res, err := r.client.HGet(key, field).Result()
 	if err == redis.Nil {
 		return nil, nil
@@ -71,6 +90,7 @@ func (r *RedisCacher) MapGet(key string, field string) ([]byte, error) {
 }
 
 func (r *RedisCacher) MapSetEx(key string, vals CacheableMap, expiration time.Duration) error {
+	defer observeOp("map_set_ex", time.Now())
 	_, err := r.client.TxPipelined(func(pipeliner redis.Pipeliner) error {
 		for k, v := range vals {
 			if err := pipeliner.HSet(key, k, string(v)).Err(); err != nil {
@@ -78,6 +98,9 @@ func (r *RedisCacher) MapSetEx(key string, vals CacheableMap, expiration time.Du
 			}
 		}
 
+		if expiration == 0 {
+			return pipeliner.Persist(key).Err()
+		}
 		if expiration < time.Second || expiration & time.Second != 0 {
 			return pipeliner.PExpire(key, expiration).Err()
 		}
@@ -88,5 +111,27 @@ func (r *RedisCacher) MapSetEx(key string, vals CacheableMap, expiration time.Du
 }
 
 func (r *RedisCacher) Del(key string) error {
+	defer observeOp("del", time.Now())
 	return r.client.Del(key).Err()
-}
\ No newline at end of file
+}
+
+func (r *RedisCacher) IncrBy(key string, n int64) error {
+	defer observeOp("incrby", time.Now())
+	return r.client.IncrBy(key, n).Err()
+}
+
+// Scan returns every key matching the glob-style pattern. It uses SCAN rather
+// than KEYS so that Redis isn't blocked while large keyspaces are iterated.
+func (r *RedisCacher) Scan(pattern string) ([]string, error) {
+	defer observeOp("scan", time.Now())
+	var keys []string
+	iter := r.client.Scan(0, pattern, 1000).Iterator()
+	for iter.Next() {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
@@ -8,30 +8,90 @@ import (
 	"github.com/kyokan/chaind/pkg/concurrent"
 	"sync/atomic"
 	"strconv"
+	"fmt"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/kyokan/chaind/pkg/jsonrpc"
+	"github.com/kyokan/chaind/pkg/sets"
+	"github.com/kyokan/chaind/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
+// EagerlyLoadedBlocks and WarmUpConcurrency are the defaults for the
+// warmer's depth and concurrency.
 const EagerlyLoadedBlocks = 200
 const WarmUpConcurrency = 5
 const LastSeenKey = "lastseenblock"
 
+var warmerLastSeenBlock = promauto.NewGauge(prometheus.GaugeOpts{
+	Name:      "eth_warmer_last_seen_block",
+	Subsystem: metrics.Subsystem,
+	Help:      "First block the warmer has not cached yet.",
+})
+
+var warmerTargetBlock = promauto.NewGauge(prometheus.GaugeOpts{
+	Name:      "eth_warmer_target_block",
+	Subsystem: metrics.Subsystem,
+	Help:      "Last finalized block the warmer is caching up to.",
+})
+
+var warmerBlocks = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name:      "eth_warmer_blocks",
+	Subsystem: metrics.Subsystem,
+	Help:      "Number of blocks the warmer tried to cache, by outcome.",
+}, []string{"outcome"})
+
+var warmerAddressErrors = promauto.NewCounter(prometheus.CounterOpts{
+	Name:      "eth_warmer_watched_address_errors",
+	Subsystem: metrics.Subsystem,
+	Help:      "Number of times a watched address failed to be cached.",
+})
+
 type Warmer struct {
-	store         *ETHStore
-	cacher        Cacher
-	hWatcher      *BlockHeightWatcher
-	switcher      backend.Switcher
-	hdl           int
-	logger        log15.Logger
-	lastSeenBlock uint64
+	store          *ETHStore
+	cacher         Cacher
+	hWatcher       *BlockHeightWatcher
+	switcher       backend.Switcher
+	receipts       *receiptFetcher
+	depth          uint64
+	concurrency    int
+	data           *sets.StringSet
+	watchAddresses []string
+	hdl            int
+	warming        int32
+	warmingAddrs   int32
+	logger         log15.Logger
+	lastSeenBlock  uint64
 }
 
-func NewWarmer(store *ETHStore, cacher Cacher, hWatcher *BlockHeightWatcher, switcher backend.Switcher) *Warmer {
-	return &Warmer{
-		store:    store,
-		cacher:   cacher,
-		hWatcher: hWatcher,
-		switcher: switcher,
-		logger:   log.NewLog("warmer"),
+func NewWarmer(store *ETHStore, cacher Cacher, hWatcher *BlockHeightWatcher, switcher backend.Switcher, cfg *config.WarmerConfig) *Warmer {
+	w := &Warmer{
+		store:       store,
+		cacher:      cacher,
+		hWatcher:    hWatcher,
+		switcher:    switcher,
+		depth:       EagerlyLoadedBlocks,
+		concurrency: WarmUpConcurrency,
+		data:        sets.NewStringSet([]string{config.WarmBlocks, config.WarmReceipts}),
+		logger:      log.NewLog("warmer"),
+	}
+	if cfg != nil {
+		if cfg.Depth > 0 {
+			w.depth = cfg.Depth
+		}
+		if cfg.Concurrency > 0 {
+			w.concurrency = cfg.Concurrency
+		}
+		if len(cfg.Data) > 0 {
+			w.data = sets.NewStringSet(cfg.Data)
+		}
+		w.watchAddresses = cfg.WatchAddresses
 	}
+
+	// receipts of backends that reject batches are fetched with the warmer's
+	// concurrency
+	w.receipts = newReceiptFetcher(switcher, nil, w.concurrency)
+	return w
 }
 
 func (w *Warmer) Start() error {
@@ -77,105 +137,200 @@ func (w *Warmer) warm() error {
 		return err
 	}
 
-	end := height - FinalityDepth
+	depth := w.hWatcher.FinalityDepth()
+	if height < depth {
+		w.logger.Info("no finalized blocks to warm up", "height", height)
+		return nil
+	}
+	end := height - depth
 
 	var start uint64
-	if lastSeenInCache > end-EagerlyLoadedBlocks {
+	if end > w.depth {
+		start = end - w.depth
+	}
+	if lastSeenInCache > start {
 		start = lastSeenInCache
-	} else {
-		start = end - EagerlyLoadedBlocks
 	}
 
 	if start > end {
 		atomic.StoreUint64(&w.lastSeenBlock, start)
+		warmerLastSeenBlock.Set(float64(start))
 		w.logger.Info("cache already warm")
 		return nil
 	}
 
-	w.cacheBlocksBetween(start, end)
-	w.logger.Info("successfully warmed up cache", "start_block", start, "end_block", end)
-	atomic.StoreUint64(&w.lastSeenBlock, end)
-	if err := w.cacher.Set(LastSeenKey, []byte(strconv.FormatUint(end, 10))); err != nil {
-		w.logger.Error("failed to store last seen block in cache", "err", err)
+	next := w.cacheBlocksBetween(start, end)
+	w.setLastSeenBlock(next)
+	if next < end {
+		w.logger.Warn("partially warmed up cache", "start_block", start, "end_block", end, "next_block", next)
+		return nil
 	}
+	w.logger.Info("successfully warmed up cache", "start_block", start, "end_block", end)
 	return nil
 }
 
 func (w *Warmer) onBlock(number uint64) {
 	w.logger.Debug("got new block", "number", number)
+	// addresses have their own guard so that a long block warm-up doesn't
+	// hold up their state at the new head
+	w.warmAddresses(number)
+
+	// the block height watcher notifies subscribers concurrently, so skip
+	// this block if the previous one is still being warmed up. the next
+	// block will pick up where it left off.
+	if !atomic.CompareAndSwapInt32(&w.warming, 0, 1) {
+		w.logger.Debug("skipping block while warm-up is in progress", "number", number)
+		return
+	}
+	defer atomic.StoreInt32(&w.warming, 0)
+
 	lastSeenBlock := atomic.LoadUint64(&w.lastSeenBlock)
-	lastFinalized := number - FinalityDepth
+	depth := w.hWatcher.FinalityDepth()
+	if number < depth {
+		return
+	}
+	lastFinalized := number - depth
 	if lastFinalized < lastSeenBlock {
 		w.logger.Debug("skipping non-finalized block", "number", number, "last_seen", lastSeenBlock)
 		return
 	}
 
-	w.cacheBlocksBetween(lastSeenBlock, lastFinalized)
-	atomic.StoreUint64(&w.lastSeenBlock, lastFinalized)
+	w.setLastSeenBlock(w.cacheBlocksBetween(lastSeenBlock, lastFinalized))
+}
+
+func (w *Warmer) setLastSeenBlock(number uint64) {
+	atomic.StoreUint64(&w.lastSeenBlock, number)
+	warmerLastSeenBlock.Set(float64(number))
 	if err := w.cacher.Set(LastSeenKey, []byte(strconv.FormatUint(number, 10))); err != nil {
 		w.logger.Error("failed to store last seen block in cache", "err", err)
 	}
 }
 
-func (w *Warmer) cacheBlocksBetween(start uint64, end uint64) {
+// cacheBlocksBetween caches the blocks from start up to but excluding end,
+// and returns the first block that, along with its receipts, could not be
+// cached. Blocks after it are cached but will be fetched again next time.
+func (w *Warmer) cacheBlocksBetween(start uint64, end uint64) uint64 {
+	warmerTargetBlock.Set(float64(end))
 	l := end - start
 	if l == 0 {
-		return
+		return end
 	}
 
 	blocks := make([]uint64, l)
 	for i := 0; i < int(l); i++ {
 		blocks[i] = start + uint64(i)
 	}
-	concurrent.ConsumeUint64s(blocks, w.cacheBlock, WarmUpConcurrency)
+	cached := make([]bool, l)
+	concurrent.ConsumeUint64s(blocks, func(number uint64) {
+		if err := w.cacheBlock(number); err != nil {
+			w.logger.Error("failed to warm up cache with block", "number", number, "err", err)
+			warmerBlocks.WithLabelValues("failed").Inc()
+			return
+		}
+		cached[number-start] = true
+		warmerBlocks.WithLabelValues("cached").Inc()
+	}, w.concurrency)
+
+	next := start
+	for next < end && cached[next-start] {
+		next++
+	}
+	return next
 }
 
-func (w *Warmer) cacheBlock(number uint64) {
+func (w *Warmer) cacheBlock(number uint64) error {
 	client, err := w.switcher.ETHClient()
 	if err != nil {
-		w.logger.Error("failed to get Ethereum client", "err", err)
-		return
+		return err
 	}
 
-	blockRes, err := client.GetBlockByNumber(number, true)
-	if err != nil {
-		w.logger.Error("failed to get block by number", "err", err)
-		return
-	}
+	if w.data.Contains(config.WarmBlocks) || w.data.Contains(config.WarmReceipts) {
+		blockRes, err := client.GetBlockByNumber(number, true)
+		if err != nil {
+			return err
+		}
+		if !gjson.GetBytes(blockRes, "number").Exists() {
+			return fmt.Errorf("backend returned no block %d", number)
+		}
 
-	if err := w.store.CacheBlockByNumber(blockRes, true); err != nil {
-		w.logger.Error("failed to store block in cache", "err", err)
+		if w.data.Contains(config.WarmBlocks) {
+			if err := w.store.CacheBlockByNumber(blockRes, true); err != nil {
+				return err
+			}
+		}
+		if w.data.Contains(config.WarmReceipts) {
+			if err := w.receipts.cacheBlockReceipts(w.store, blockRes); err != nil {
+				return err
+			}
+		}
 	}
 
-	hashRes := gjson.GetBytes(blockRes, "transactions.#.hash").Array()
-	l := len(hashRes)
-	if l > 0 {
-		txHashes := make([]string, l, l)
-		for i, hash := range hashRes {
-			txHashes[i] = hash.String()
+	if w.data.Contains(config.WarmLogs) {
+		logsRes, err := client.GetLogs(number)
+		if err != nil {
+			return err
+		}
+		if err := w.store.CacheLogs(number, logsRes); err != nil {
+			return err
 		}
-		go concurrent.ConsumeStrings(txHashes, w.cacheTxReceipt, WarmUpConcurrency)
 	}
 
-	w.logger.Debug("successfully warned up cache with block", "number", number)
+	w.logger.Debug("successfully warmed up cache with block", "number", number)
+	return nil
 }
 
-func (w *Warmer) cacheTxReceipt(hash string) {
+// warmAddresses caches the balance, nonce and code of every watched address
+// at the given block, so that requests for them are served from the cache
+// until the next block.
+func (w *Warmer) warmAddresses(number uint64) {
+	if len(w.watchAddresses) == 0 {
+		return
+	}
+
+	// a newer block supersedes this one, so skip it if the addresses are
+	// still being fetched at the previous block
+	if !atomic.CompareAndSwapInt32(&w.warmingAddrs, 0, 1) {
+		w.logger.Debug("skipping watched addresses while warm-up is in progress", "number", number)
+		return
+	}
+	defer atomic.StoreInt32(&w.warmingAddrs, 0)
+
 	client, err := w.switcher.ETHClient()
 	if err != nil {
 		w.logger.Error("failed to get Ethereum client", "err", err)
 		return
 	}
 
-	receiptRes, err := client.GetTransactionReceipt(hash)
+	block := jsonrpc.Uint642Hex(number)
+	concurrent.ConsumeStrings(w.watchAddresses, func(addr string) {
+		if err := w.warmAddress(client, addr, block, number); err != nil {
+			w.logger.Error("failed to warm up cache with watched address", "address", addr, "number", number, "err", err)
+			warmerAddressErrors.Inc()
+		}
+	}, w.concurrency)
+}
+
+func (w *Warmer) warmAddress(client *backend.ETHClient, addr string, block string, number uint64) error {
+	balance, err := client.GetBalance(addr, block)
 	if err != nil {
-		w.logger.Error("failed to get transaction receipt", "err", err)
-		return
+		return err
+	}
+	if err := w.store.cacheAccountState("eth_getBalance", addr, number, balance); err != nil {
+		return err
 	}
 
-	if err := w.store.CacheTransactionReceipt(receiptRes); err != nil {
-		w.logger.Error("failed to store tx receipt in cache", "err", err)
+	count, err := client.GetTransactionCount(addr, block)
+	if err != nil {
+		return err
+	}
+	countRes := []byte(fmt.Sprintf("\"%s\"", jsonrpc.Uint642Hex(count)))
+	if err := w.store.cacheAccountState("eth_getTransactionCount", addr, number, countRes); err != nil {
+		return err
 	}
 
-	w.logger.Debug("successfully warmed up cache with transaction receipt", "tx_hash", hash)
+	code, err := client.GetCode(addr, block)
+	if err != nil {
+		return err
+	}
+	return w.store.cacheAccountState("eth_getCode", addr, number, code)
 }
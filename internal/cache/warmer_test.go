@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"sync/atomic"
+	"testing"
+	"github.com/kyokan/chaind/internal/backend"
+	"github.com/kyokan/chaind/pkg"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWarmer_CacheBlocksBetween(t *testing.T) {
+	failAt := uint64(15)
+	srv := newTestChain(100, &failAt, false)
+	defer srv.Close()
+
+	sw := backend.NewSwitcher([]config.Backend{{Name: "test", URL: srv.URL, Type: pkg.EthBackend, Main: true}})
+	hWatcher := NewBlockHeightWatcher(sw)
+	hWatcher.updateBlockHeight()
+	cacher := newMemCacher()
+	w := NewWarmer(NewETHStore(cacher, hWatcher), cacher, hWatcher, sw, nil)
+
+	require.Equal(t, uint64(15), w.cacheBlocksBetween(10, 20))
+	has, _ := cacher.Has(txReceiptCacheKey("0xe"))
+	require.True(t, has)
+
+	atomic.StoreUint64(&failAt, 1000)
+	require.Equal(t, uint64(20), w.cacheBlocksBetween(15, 20))
+	require.Equal(t, uint64(20), w.cacheBlocksBetween(20, 20))
+}
+
+func TestWarmer_Config(t *testing.T) {
+	failAt := uint64(1000)
+	srv := newTestChain(100, &failAt, true)
+	defer srv.Close()
+
+	sw := backend.NewSwitcher([]config.Backend{{Name: "test", URL: srv.URL, Type: pkg.EthBackend, Main: true}})
+	hWatcher := NewBlockHeightWatcher(sw)
+	hWatcher.updateBlockHeight()
+	cacher := newMemCacher()
+	store := NewETHStore(cacher, hWatcher)
+	w := NewWarmer(store, cacher, hWatcher, sw, &config.WarmerConfig{
+		Depth:          10,
+		Data:           []string{config.WarmReceipts, config.WarmLogs},
+		WatchAddresses: []string{"0x9d8a62f656a8d1615c1294fd71e9cfb3e4855a4f"},
+	})
+
+	require.NoError(t, w.warm())
+	require.Equal(t, uint64(93), atomic.LoadUint64(&w.lastSeenBlock))
+	has, _ := cacher.Has(blockNumCacheKey(92, true))
+	require.False(t, has)
+	has, _ = cacher.Has(txReceiptCacheKey("0x5c"))
+	require.True(t, has)
+	has, _ = cacher.Has(txReceiptCacheKey("0x52"))
+	require.False(t, has)
+	logs, err := store.GetLogs(92)
+	require.NoError(t, err)
+	require.Equal(t, "[{\"blockNumber\":\"0x5c\"}]", string(logs))
+
+	w.warmAddresses(100)
+	balance, err := store.GetBalance("0x9D8A62F656A8D1615C1294FD71E9CFB3E4855A4F")
+	require.NoError(t, err)
+	require.Equal(t, "\"0x1\"", string(balance))
+	count, err := store.GetTransactionCount("0x9d8a62f656a8d1615c1294fd71e9cfb3e4855a4f")
+	require.NoError(t, err)
+	require.Equal(t, "\"0x2\"", string(count))
+	code, err := store.GetCode("0x9d8a62f656a8d1615c1294fd71e9cfb3e4855a4f")
+	require.NoError(t, err)
+	require.Equal(t, "\"0x\"", string(code))
+}
+
+func TestWarmer_WarmAddressesGuard(t *testing.T) {
+	failAt := uint64(1000)
+	srv := newTestChain(100, &failAt, true)
+	defer srv.Close()
+
+	sw := backend.NewSwitcher([]config.Backend{{Name: "test", URL: srv.URL, Type: pkg.EthBackend, Main: true}})
+	hWatcher := NewBlockHeightWatcher(sw)
+	cacher := newMemCacher()
+	store := NewETHStore(cacher, hWatcher)
+	addr := "0x9d8a62f656a8d1615c1294fd71e9cfb3e4855a4f"
+	w := NewWarmer(store, cacher, hWatcher, sw, &config.WarmerConfig{
+		WatchAddresses: []string{addr},
+	})
+
+	// an address warm-up in progress skips the block
+	atomic.StoreInt32(&w.warmingAddrs, 1)
+	w.warmAddresses(100)
+	balance, _, err := store.GetCachedBalance(addr)
+	require.NoError(t, err)
+	require.Nil(t, balance)
+
+	// a block warm-up in progress doesn't hold up the addresses
+	atomic.StoreInt32(&w.warmingAddrs, 0)
+	atomic.StoreInt32(&w.warming, 1)
+	w.onBlock(100)
+	balance, height, err := store.GetCachedBalance(addr)
+	require.NoError(t, err)
+	require.Equal(t, "\"0x1\"", string(balance))
+	require.Equal(t, uint64(100), height)
+	require.Equal(t, int32(0), atomic.LoadInt32(&w.warmingAddrs))
+}
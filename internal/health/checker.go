@@ -0,0 +1,141 @@
+package health
+
+import (
+	"time"
+	"github.com/kyokan/chaind/internal/backend"
+	"github.com/kyokan/chaind/internal/cache"
+	"github.com/kyokan/chaind/pkg"
+)
+
+// DefaultMaxHeadAge is how long the network head can go without advancing
+// before chaind stops reporting itself ready.
+const DefaultMaxHeadAge = 60 * time.Second
+
+// cacheCheckKey is looked up to check that the cache is reachable. It is
+// never set.
+const cacheCheckKey = "chaind:readiness"
+
+const (
+	StatusOK       = "ok"
+	StatusDegraded = "degraded"
+	StatusFailing  = "failing"
+)
+
+// Readiness is the result of a readiness check, along with the result of each
+// subsystem's check. chaind is ready if no subsystem is failing.
+type Readiness struct {
+	Status   string         `json:"status"`
+	Backends BackendsStatus `json:"backends"`
+	Cache    CacheStatus    `json:"cache"`
+	Head     HeadStatus     `json:"head"`
+}
+
+type BackendsStatus struct {
+	Status  string   `json:"status"`
+	Healthy []string `json:"healthy"`
+}
+
+type CacheStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type HeadStatus struct {
+	Status     string `json:"status"`
+	Height     uint64 `json:"height"`
+	AgeSecs    int64  `json:"ageSecs"`
+	MaxAgeSecs int64  `json:"maxAgeSecs"`
+}
+
+// Checker checks whether chaind is able to serve requests.
+type Checker struct {
+	sw            backend.Switcher
+	cacher        cache.Cacher
+	hWatcher      *cache.BlockHeightWatcher
+	allowDegraded bool
+	maxHeadAge    time.Duration
+}
+
+// NewChecker returns a Checker. An unreachable cache is reported as degraded
+// rather than failing if allowDegraded is set. A maxHeadAge of zero uses
+// DefaultMaxHeadAge.
+func NewChecker(sw backend.Switcher, cacher cache.Cacher, hWatcher *cache.BlockHeightWatcher, allowDegraded bool, maxHeadAge time.Duration) *Checker {
+	if maxHeadAge == 0 {
+		maxHeadAge = DefaultMaxHeadAge
+	}
+
+	return &Checker{
+		sw:            sw,
+		cacher:        cacher,
+		hWatcher:      hWatcher,
+		allowDegraded: allowDegraded,
+		maxHeadAge:    maxHeadAge,
+	}
+}
+
+func (c *Checker) Readiness() *Readiness {
+	r := &Readiness{
+		Status:   StatusOK,
+		Backends: c.checkBackends(),
+		Cache:    c.checkCache(),
+		Head:     c.checkHead(),
+	}
+	for _, status := range []string{r.Backends.Status, r.Cache.Status, r.Head.Status} {
+		if status == StatusFailing {
+			r.Status = StatusFailing
+			break
+		}
+		if status == StatusDegraded {
+			r.Status = StatusDegraded
+		}
+	}
+	return r
+}
+
+func (c *Checker) checkBackends() BackendsStatus {
+	status := BackendsStatus{
+		Status:  StatusFailing,
+		Healthy: []string{},
+	}
+	for _, back := range c.sw.HealthyBackendsFor(pkg.EthBackend) {
+		status.Healthy = append(status.Healthy, back.Name)
+	}
+	if len(status.Healthy) > 0 {
+		status.Status = StatusOK
+	}
+	return status
+}
+
+func (c *Checker) checkCache() CacheStatus {
+	if _, err := c.cacher.Has(cacheCheckKey); err != nil {
+		status := CacheStatus{
+			Status: StatusFailing,
+			Error:  err.Error(),
+		}
+		if c.allowDegraded {
+			status.Status = StatusDegraded
+		}
+		return status
+	}
+
+	return CacheStatus{Status: StatusOK}
+}
+
+func (c *Checker) checkHead() HeadStatus {
+	status := HeadStatus{
+		Status:     StatusFailing,
+		Height:     c.hWatcher.BlockHeight(),
+		MaxAgeSecs: int64(c.maxHeadAge / time.Second),
+	}
+	updatedAt := c.hWatcher.HeadUpdatedAt()
+	if updatedAt.IsZero() {
+		return status
+	}
+
+	age := time.Since(updatedAt)
+	status.AgeSecs = int64(age / time.Second)
+	if age <= c.maxHeadAge {
+		status.Status = StatusOK
+	}
+	return status
+}
@@ -0,0 +1,100 @@
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"github.com/kyokan/chaind/internal/backend"
+	"github.com/kyokan/chaind/internal/cache"
+	"github.com/kyokan/chaind/pkg"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/kyokan/chaind/pkg/mocknode"
+	"github.com/stretchr/testify/require"
+)
+
+// stubCacher only implements the method used by the cache check.
+type stubCacher struct {
+	cache.Cacher
+	err error
+}
+
+func (s *stubCacher) Has(key string) (bool, error) {
+	return false, s.err
+}
+
+func TestChecker(t *testing.T) {
+	node := mocknode.New(mocknode.Config{InitialHeight: 10})
+	srv := httptest.NewServer(node)
+	defer srv.Close()
+
+	sw := backend.NewSwitcher([]config.Backend{{Name: "test", URL: srv.URL, Type: pkg.EthBackend, Main: true}})
+	require.NoError(t, sw.Start())
+	defer sw.Stop()
+	hWatcher := cache.NewBlockHeightWatcher(sw)
+	require.NoError(t, hWatcher.Start())
+	defer hWatcher.Stop()
+
+	cacher := &stubCacher{}
+	checker := NewChecker(sw, cacher, hWatcher, false, time.Minute)
+	readiness := checker.Readiness()
+	require.Equal(t, StatusOK, readiness.Status)
+	require.Equal(t, []string{"test"}, readiness.Backends.Healthy)
+	require.Equal(t, uint64(10), readiness.Head.Height)
+
+	cacher.err = errors.New("connection refused")
+	readiness = checker.Readiness()
+	require.Equal(t, StatusFailing, readiness.Status)
+	require.Equal(t, "connection refused", readiness.Cache.Error)
+	readiness = NewChecker(sw, cacher, hWatcher, true, time.Minute).Readiness()
+	require.Equal(t, StatusDegraded, readiness.Status)
+	require.Equal(t, StatusDegraded, readiness.Cache.Status)
+
+	cacher.err = nil
+	readiness = NewChecker(sw, cacher, hWatcher, false, time.Nanosecond).Readiness()
+	require.Equal(t, StatusFailing, readiness.Status)
+	require.Equal(t, StatusFailing, readiness.Head.Status)
+}
+
+func TestServer(t *testing.T) {
+	node := mocknode.New(mocknode.Config{})
+	node.SetFaults(mocknode.Faults{HTTPStatus: http.StatusBadGateway})
+	back := httptest.NewServer(node)
+	defer back.Close()
+
+	sw := backend.NewSwitcher([]config.Backend{{Name: "test", URL: back.URL, Type: pkg.EthBackend, Main: true}})
+	hWatcher := cache.NewBlockHeightWatcher(sw)
+	srv := httptest.NewServer(NewServer(":0", NewChecker(sw, &stubCacher{}, hWatcher, false, 0)).Handler())
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL + "/healthz")
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	res, err = http.Get(srv.URL + "/readyz")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+	var readiness Readiness
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&readiness))
+	require.Equal(t, StatusOK, readiness.Cache.Status)
+	require.Equal(t, StatusFailing, readiness.Head.Status)
+	require.Equal(t, int64(DefaultMaxHeadAge/time.Second), readiness.Head.MaxAgeSecs)
+}
+
+func TestServer_StartListenError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	srv := NewServer(listener.Addr().String(), nil)
+	require.Error(t, srv.Start())
+
+	srv = NewServer("127.0.0.1:0", nil)
+	require.NoError(t, srv.Start())
+	require.NoError(t, srv.Stop())
+}
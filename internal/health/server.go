@@ -0,0 +1,113 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+	"github.com/inconshreveable/log15"
+	"github.com/pkg/errors"
+	"github.com/kyokan/chaind/pkg/log"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type liveness struct {
+	Status     string `json:"status"`
+	UptimeSecs int64  `json:"uptimeSecs"`
+}
+
+// Server serves Prometheus metrics on /metrics, liveness on /healthz and
+// readiness on /readyz. /readyz returns a 503 while chaind isn't ready.
+type Server struct {
+	addr     string
+	checker  *Checker
+	started  time.Time
+	srv      *http.Server
+	quitChan chan bool
+	errChan  chan error
+	logger   log15.Logger
+}
+
+func NewServer(addr string, checker *Checker) *Server {
+	return &Server{
+		addr:     addr,
+		checker:  checker,
+		quitChan: make(chan bool),
+		errChan:  make(chan error),
+		logger:   log.NewLog("health"),
+	}
+}
+
+func (s *Server) Start() error {
+	// listen before returning, so that an address that is already in use
+	// fails startup instead of only being logged.
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return errors.Wrapf(err, "failed to listen on %s", s.addr)
+	}
+
+	s.started = time.Now()
+	s.srv = &http.Server{
+		Addr:    s.addr,
+		Handler: s.Handler(),
+	}
+
+	go func() {
+		if err := s.srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+			s.logger.Error("metrics server error", "addr", s.addr, "err", err)
+		}
+	}()
+
+	go func() {
+		<-s.quitChan
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.errChan <- s.srv.Shutdown(ctx)
+	}()
+
+	s.logger.Info("started", "addr", s.addr)
+	return nil
+}
+
+func (s *Server) Stop() error {
+	s.quitChan <- true
+	return <-s.errChan
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/readyz", s.handleReadyz)
+	return mux
+}
+
+func (s *Server) handleHealthz(res http.ResponseWriter, req *http.Request) {
+	writeJSON(res, http.StatusOK, &liveness{
+		Status:     StatusOK,
+		UptimeSecs: int64(time.Since(s.started) / time.Second),
+	})
+}
+
+func (s *Server) handleReadyz(res http.ResponseWriter, req *http.Request) {
+	readiness := s.checker.Readiness()
+	code := http.StatusOK
+	if readiness.Status == StatusFailing {
+		s.logger.Debug("not ready", "backends", readiness.Backends.Status, "cache", readiness.Cache.Status, "head", readiness.Head.Status)
+		code = http.StatusServiceUnavailable
+	}
+	writeJSON(res, code, readiness)
+}
+
+func writeJSON(res http.ResponseWriter, code int, data interface{}) {
+	out, err := json.Marshal(data)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(code)
+	res.Write(out)
+}
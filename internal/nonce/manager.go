@@ -0,0 +1,147 @@
+package nonce
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"github.com/inconshreveable/log15"
+	"github.com/kyokan/chaind/internal/backend"
+	"github.com/kyokan/chaind/internal/cache"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/kyokan/chaind/pkg/eth"
+	"github.com/kyokan/chaind/pkg/log"
+)
+
+const DefaultDropAfter = time.Hour
+
+// Manager keeps track of the nonces of transactions sent through chaind, so
+// that pending nonce queries don't go backwards when chaind fails over to a
+// backend that hasn't seen those transactions yet.
+type Manager struct {
+	sw         backend.Switcher
+	hWatcher   *cache.BlockHeightWatcher
+	dropAfter  time.Duration
+	pending    map[string]map[uint64]time.Time
+	mtx        sync.Mutex
+	hdl        int
+	processing int32
+	logger     log15.Logger
+}
+
+func NewManager(cfg *config.NonceConfig, sw backend.Switcher, hWatcher *cache.BlockHeightWatcher) *Manager {
+	m := &Manager{
+		sw:        sw,
+		hWatcher:  hWatcher,
+		dropAfter: DefaultDropAfter,
+		pending:   make(map[string]map[uint64]time.Time),
+		logger:    log.NewLog("nonce_manager"),
+	}
+	if cfg.DropAfterSecs > 0 {
+		m.dropAfter = time.Duration(cfg.DropAfterSecs) * time.Second
+	}
+	return m
+}
+
+func (m *Manager) Start() error {
+	m.hdl = m.hWatcher.Subscribe(m.onBlock)
+	m.logger.Info("started")
+	return nil
+}
+
+func (m *Manager) Stop() error {
+	m.hWatcher.Unsubscribe(m.hdl)
+	return nil
+}
+
+// Observe records the sender and nonce of a signed transaction that was
+// accepted by a backend.
+func (m *Manager) Observe(rawTx string) (*eth.Transaction, error) {
+	tx, err := eth.DecodeRawTransaction(rawTx)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	nonces := m.pending[tx.From]
+	if nonces == nil {
+		nonces = make(map[uint64]time.Time)
+		m.pending[tx.From] = nonces
+	}
+	if _, ok := nonces[tx.Nonce]; !ok {
+		nonces[tx.Nonce] = time.Now()
+	}
+
+	m.logger.Debug("observed transaction", "from", tx.From, "nonce", tx.Nonce, "tx_hash", tx.Hash)
+	return tx, nil
+}
+
+// PendingNonce returns the larger of the nonce reported by the backend and
+// the nonce after the highest transaction chaind has seen from the address.
+func (m *Manager) PendingNonce(address string, backendNonce uint64) uint64 {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	next := backendNonce
+	for nonce := range m.pending[strings.ToLower(address)] {
+		if nonce+1 > next {
+			next = nonce + 1
+		}
+	}
+
+	return next
+}
+
+func (m *Manager) onBlock(number uint64) {
+	if !atomic.CompareAndSwapInt32(&m.processing, 0, 1) {
+		m.logger.Debug("skipping block, previous update still running", "number", number)
+		return
+	}
+	defer atomic.StoreInt32(&m.processing, 0)
+
+	m.mtx.Lock()
+	var addrs []string
+	for addr := range m.pending {
+		addrs = append(addrs, addr)
+	}
+	m.mtx.Unlock()
+	if len(addrs) == 0 {
+		return
+	}
+
+	client, err := m.sw.ETHClient()
+	if err != nil {
+		m.logger.Error("failed to get Ethereum client", "err", err)
+		return
+	}
+
+	for _, addr := range addrs {
+		mined, err := client.GetTransactionCount(addr, "latest")
+		if err != nil {
+			m.logger.Error("failed to get transaction count", "address", addr, "err", err)
+			continue
+		}
+		m.prune(addr, mined)
+	}
+}
+
+// prune forgets nonces that have been mined or whose transactions have been
+// pending for so long that they were probably dropped.
+func (m *Manager) prune(addr string, mined uint64) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	nonces := m.pending[addr]
+	for nonce, seenAt := range nonces {
+		if nonce < mined {
+			delete(nonces, nonce)
+		} else if time.Since(seenAt) > m.dropAfter {
+			m.logger.Info("forgetting nonce of dropped transaction", "address", addr, "nonce", nonce)
+			delete(nonces, nonce)
+		}
+	}
+	if len(nonces) == 0 {
+		delete(m.pending, addr)
+	}
+}
@@ -0,0 +1,81 @@
+package nonce
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+	"github.com/kyokan/chaind/internal/backend/backendtest"
+	"github.com/kyokan/chaind/internal/cache"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/kyokan/chaind/pkg/jsonrpc"
+	"github.com/stretchr/testify/require"
+)
+
+// signed by the key from the EIP-155 example, with nonce 9
+const testTx = "0xf86c098504a817c800825208943535353535353535353535353535353535353535880de0b6b3a76400008025a028ef61340bd939bc2195fe537567866003e1a15d3c71ff63e1590620aa636276a067cbe9d8997f761aecb703304b3800ccf555c9f3dc64214b297fb1966a3b6d83"
+const testAddr = "0x9d8a62f656a8d1615c1294fd71e9cfb3e4855a4f"
+
+func TestManager(t *testing.T) {
+	var mtx sync.Mutex
+	mined := "0x0"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req jsonrpc.Request
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &req)
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		result := "\"0x64\""
+		if req.Method == "eth_getTransactionCount" {
+			result = "\"" + mined + "\""
+		}
+		w.Write([]byte("{\"jsonrpc\":\"2.0\",\"id\":1,\"result\":" + result + "}"))
+	}))
+	defer srv.Close()
+
+	sw := backendtest.NewURLSwitch(srv.URL)
+	watcher := cache.NewBlockHeightWatcher(sw)
+	require.NoError(t, watcher.Start())
+	defer watcher.Stop()
+	m := NewManager(&config.NonceConfig{}, sw, watcher)
+
+	require.Equal(t, uint64(3), m.PendingNonce(testAddr, 3))
+
+	tx, err := m.Observe(testTx)
+	require.NoError(t, err)
+	require.Equal(t, testAddr, tx.From)
+	require.Equal(t, uint64(10), m.PendingNonce(testAddr, 3))
+	require.Equal(t, uint64(10), m.PendingNonce("0x9D8A62F656A8D1615C1294FD71E9CFB3E4855A4F", 3))
+	require.Equal(t, uint64(12), m.PendingNonce(testAddr, 12))
+
+	// nothing mined yet, so the nonce is still tracked
+	m.onBlock(100)
+	require.Equal(t, uint64(10), m.PendingNonce(testAddr, 3))
+
+	mtx.Lock()
+	mined = "0xa"
+	mtx.Unlock()
+	m.onBlock(100)
+	require.Equal(t, uint64(3), m.PendingNonce(testAddr, 3))
+
+	_, err = m.Observe("0xdeadbeef")
+	require.Error(t, err)
+}
+
+func TestManager_Drop(t *testing.T) {
+	m := NewManager(&config.NonceConfig{}, nil, nil)
+	_, err := m.Observe(testTx)
+	require.NoError(t, err)
+
+	m.prune(testAddr, 0)
+	require.Equal(t, uint64(10), m.PendingNonce(testAddr, 0))
+
+	m.dropAfter = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	m.prune(testAddr, 0)
+	require.Equal(t, uint64(0), m.PendingNonce(testAddr, 0))
+}
@@ -0,0 +1,135 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"github.com/inconshreveable/log15"
+	"github.com/kyokan/chaind/internal/audit"
+	"github.com/kyokan/chaind/pkg"
+	"github.com/kyokan/chaind/pkg/eth"
+	"github.com/kyokan/chaind/pkg/jsonrpc"
+	"github.com/kyokan/chaind/pkg/log"
+	"github.com/tidwall/gjson"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const sendRawTxMethod = "eth_sendRawTransaction"
+
+var errBroadcastRejected = errors.New("no backends accepted the transaction")
+
+// knownTxErrors are substrings of errors returned by nodes that already
+// have the transaction in their pool, which means that the transaction
+// was accepted.
+var knownTxErrors = []string{
+	"already known",
+	"known transaction",
+	"already imported",
+}
+
+// broadcast sends a raw transaction to every healthy backend in the pool in
+// parallel, so that it propagates even if one node is poorly peered. The
+// first successful response is returned; the remaining backends are allowed
+// to finish in the background so that their results can be audited.
+func (h *EthHandler) broadcast(req *http.Request, pool string, rpcReq *jsonrpc.Request, body []byte, logger log15.Logger) ([]byte, error) {
+	backends := h.sw.HealthyBackendsForPool(pkg.EthBackend, pool)
+	if len(backends) == 0 {
+		return nil, errors.New("no backends available")
+	}
+
+	txHash, err := eth.RawTransactionHash(gjson.GetBytes(rpcReq.Params, "0").String())
+	if err != nil {
+		logger.Warn("failed to hash raw transaction", "err", err)
+	}
+
+	// use a detached context so that the client disconnecting doesn't stop
+	// the transaction from reaching every backend. it keeps the request's ID
+	// and span so that the broadcast can be traced.
+	ctx := context.WithValue(context.Background(), log.RequestIDKey, req.Context().Value(log.RequestIDKey))
+	ctx = trace.ContextWithSpan(ctx, trace.SpanFromContext(req.Context()))
+	results := make(chan *upstreamResult, len(backends))
+	for i := range backends {
+		go h.postAsync(ctx, &backends[i], body, results)
+	}
+
+	out := make(chan []byte, 1)
+	go func() {
+		var accepted bool
+		var rejection []byte
+		auditResults := make([]audit.BroadcastResult, 0, len(backends))
+		for range backends {
+			res := <-results
+			ok, msg := broadcastAccepted(res)
+			auditResults = append(auditResults, audit.BroadcastResult{
+				Backend:  res.backend.Name,
+				Accepted: ok,
+				Error:    msg,
+			})
+
+			if !ok {
+				logger.Warn("backend rejected raw transaction", "backend", res.backend.Name, "tx_hash", txHash, "err", msg)
+				if rejection == nil && res.err == nil {
+					rejection = res.body
+				}
+				continue
+			}
+			if accepted {
+				continue
+			}
+
+			accepted = true
+			if gjson.GetBytes(res.body, "error").Exists() && txHash != "" {
+				out <- txHashResponse(rpcReq, txHash)
+			} else {
+				out <- res.body
+			}
+		}
+
+		if !accepted {
+			out <- rejection
+		}
+
+		if err := h.auditor.RecordBroadcast(req, txHash, auditResults); err != nil {
+			logger.Error("failed to record audit log for broadcast", "err", err)
+		}
+	}()
+
+	resBody := <-out
+	if resBody == nil {
+		return nil, errBroadcastRejected
+	}
+
+	return resBody, nil
+}
+
+func broadcastAccepted(res *upstreamResult) (bool, string) {
+	if res.err != nil {
+		return false, res.err.Error()
+	}
+
+	rpcErr := gjson.GetBytes(res.body, "error")
+	if !rpcErr.Exists() || rpcErr.Type == gjson.Null {
+		return true, ""
+	}
+
+	msg := rpcErr.Get("message").String()
+	lower := strings.ToLower(msg)
+	for _, known := range knownTxErrors {
+		if strings.Contains(lower, known) {
+			return true, ""
+		}
+	}
+
+	return false, msg
+}
+
+func txHashResponse(rpcReq *jsonrpc.Request, txHash string) []byte {
+	out, _ := json.Marshal(&jsonrpc.Response{
+		Jsonrpc: jsonrpc.Version,
+		ID:      rpcReq.ID,
+		Result:  []byte("\"" + txHash + "\""),
+	})
+	return out
+}
@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"errors"
+	"testing"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBroadcastAccepted(t *testing.T) {
+	backend := &config.Backend{Name: "test"}
+	tests := []struct {
+		body     string
+		err      error
+		accepted bool
+		msg      string
+	}{
+		{`{"jsonrpc":"2.0","id":1,"result":"0xabc"}`, nil, true, ""},
+		{`{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"already known"}}`, nil, true, ""},
+		{`{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"known transaction: abc"}}`, nil, true, ""},
+		{`{"jsonrpc":"2.0","id":1,"error":{"code":-32010,"message":"Transaction with the same hash was already imported."}}`, nil, true, ""},
+		{`{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"nonce too low"}}`, nil, false, "nonce too low"},
+		{"", errors.New("timeout"), false, "timeout"},
+	}
+
+	for _, tt := range tests {
+		ok, msg := broadcastAccepted(&upstreamResult{
+			body:    []byte(tt.body),
+			backend: backend,
+			err:     tt.err,
+		})
+		require.Equal(t, tt.accepted, ok, tt.body)
+		require.Equal(t, tt.msg, msg, tt.body)
+	}
+}
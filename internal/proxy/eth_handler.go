@@ -14,29 +14,67 @@ import (
 	"github.com/kyokan/chaind/pkg/jsonrpc"
 	"github.com/kyokan/chaind/pkg/config"
 	"strings"
+	"sync"
 	"github.com/kyokan/chaind/pkg/sets"
 	"github.com/tidwall/gjson"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/kyokan/chaind/pkg/metrics"
+	"github.com/kyokan/chaind/internal/backend"
+	"github.com/kyokan/chaind/internal/tracker"
+	"github.com/kyokan/chaind/internal/nonce"
+	"github.com/kyokan/chaind/internal/recorder"
+	"github.com/kyokan/chaind/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"context"
+	"fmt"
 )
 
 type beforeFunc func(res http.ResponseWriter, rpcReq *jsonrpc.Request, logger log15.Logger) bool
 type afterFunc func(rpcRes *jsonrpc.Response, rpcReq *jsonrpc.Request, logger log15.Logger) error
 
+type rewriteFunc func(resBody []byte, rpcReq *jsonrpc.Request, logger log15.Logger) []byte
+type localFunc func(res http.ResponseWriter, rpcReq *jsonrpc.Request, logger log15.Logger)
+
+// Cache outcomes of a request, used to label the request duration metric.
+// Requests for methods that are never cached have no cache outcome.
+const (
+	cacheHit  = "hit"
+	cacheMiss = "miss"
+	cacheNone = "none"
+)
+
+// unknownMethod labels the metrics of requests for methods that are disabled
+// or that backends don't support, so that clients can't create arbitrarily
+// many metric series.
+const unknownMethod = "unknown"
+
 type handler struct {
-	before beforeFunc
-	after  afterFunc
+	before  beforeFunc
+	rewrite rewriteFunc
+	after   afterFunc
 }
 
 type EthHandler struct {
+	sw          backend.Switcher
 	store       *cache.ETHStore
 	auditor     audit.Auditor
 	hWatcher    *cache.BlockHeightWatcher
 	handlers    map[string]*handler
+	locals      map[string]localFunc
+	tracker     *tracker.Tracker
+	nonces      *nonce.Manager
 	logger      log15.Logger
 	client      *http.Client
 	enabledAPIs *sets.StringSet
+	apiMtx      sync.RWMutex
+	hedges      map[string]time.Duration
+	router      *Router
+	quorumCfg   *Quorum
+	sticky      *Stickiness
+	recorder    *recorder.Recorder
 
 	requestCount       prometheus.Counter
 	cacheHits          prometheus.Counter
@@ -44,16 +82,35 @@ type EthHandler struct {
 	batchRequestCount  prometheus.Counter
 	singleRequestCount prometheus.Counter
 	batchSize          prometheus.Histogram
+	hedgeEligible      *prometheus.CounterVec
+	hedgeCount         *prometheus.CounterVec
+	hedgeWins          *prometheus.CounterVec
+	quorumResults      *prometheus.CounterVec
+	stickyResults      *prometheus.CounterVec
+	requestDuration    *prometheus.HistogramVec
+	upstreamErrors     *prometheus.CounterVec
 }
 
-func NewEthHandler(store *cache.ETHStore, auditor audit.Auditor, hWatcher *cache.BlockHeightWatcher, enabledAPIs []string) *EthHandler {
+func NewEthHandler(sw backend.Switcher, store *cache.ETHStore, auditor audit.Auditor, hWatcher *cache.BlockHeightWatcher, txTracker *tracker.Tracker, nonces *nonce.Manager, cfg *config.Config) *EthHandler {
+	hedges := make(map[string]time.Duration)
+	for _, hedge := range cfg.Hedges {
+		hedges[hedge.Method] = time.Duration(hedge.DelayMS) * time.Millisecond
+	}
+
 	h := &EthHandler{
+		sw:       sw,
 		store:    store,
 		auditor:  auditor,
 		hWatcher: hWatcher,
+		tracker:  txTracker,
+		nonces:   nonces,
 		logger:   log.NewLog("proxy/eth_handler"),
 		client: pkg.NewHTTPClient(10 * time.Second),
-		enabledAPIs: sets.NewStringSet(enabledAPIs),
+		enabledAPIs: sets.NewStringSet(cfg.ETHConfig.APIs),
+		hedges:      hedges,
+		router:      NewRouter(cfg.Routes, hWatcher),
+		quorumCfg:   NewQuorum(cfg.Quorum),
+		sticky:      NewStickiness(cfg.Stickiness, hWatcher),
 		requestCount: promauto.NewCounter(prometheus.CounterOpts{
 			Name:      "eth_request_count",
 			Subsystem: metrics.Subsystem,
@@ -85,6 +142,42 @@ func NewEthHandler(store *cache.ETHStore, auditor audit.Auditor, hWatcher *cache
 			Help:      "Size of incoming batch requests, denoted in number of requests in each batch.",
 			Buckets:   prometheus.LinearBuckets(1, 100, 20),
 		}),
+		hedgeEligible: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name:      "eth_hedge_eligible_request_count",
+			Subsystem: metrics.Subsystem,
+			Help:      "Number of upstream requests for methods with hedging enabled.",
+		}, []string{"method_name"}),
+		hedgeCount: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name:      "eth_hedged_request_count",
+			Subsystem: metrics.Subsystem,
+			Help:      "Number of upstream requests that were hedged to a second backend.",
+		}, []string{"method_name"}),
+		hedgeWins: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name:      "eth_hedge_wins",
+			Subsystem: metrics.Subsystem,
+			Help:      "Number of hedged requests where the second backend answered first.",
+		}, []string{"method_name"}),
+		quorumResults: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name:      "eth_quorum_results",
+			Subsystem: metrics.Subsystem,
+			Help:      "Outcomes of quorum requests.",
+		}, []string{"method_name", "outcome"}),
+		stickyResults: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name:      "eth_sticky_results",
+			Subsystem: metrics.Subsystem,
+			Help:      "Outcomes of requests from clients whose high-water mark the selected backend had not reached.",
+		}, []string{"outcome"}),
+		requestDuration: promauto.NewHistogramVec(prometheus.HistogramOpts{
+			Name:      "eth_request_duration_seconds",
+			Subsystem: metrics.Subsystem,
+			Help:      "Duration of Ethereum RPC requests, including batch items, by method, backend and cache outcome.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 16),
+		}, []string{"method_name", "backend", "cache"}),
+		upstreamErrors: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name:      "eth_upstream_errors",
+			Subsystem: metrics.Subsystem,
+			Help:      "Number of failed requests to backends, by reason.",
+		}, []string{"backend", "reason"}),
 	}
 	h.handlers = map[string]*handler{
 		"eth_blockNumber": {
@@ -98,23 +191,67 @@ func NewEthHandler(store *cache.ETHStore, auditor audit.Auditor, hWatcher *cache
 			before: h.hdlGetTransactionReceiptBefore,
 			after:  h.hdlGetTransactionReceiptAfter,
 		},
-		"eth_getBalance": {
-			before: h.hdlGetBalanceBefore,
-			after:  h.hdlGetBalanceAfter,
+		"eth_getBalance":          h.accountStateHandler(h.store.GetBalance, h.store.CacheBalance),
+		"eth_getTransactionCount": h.accountStateHandler(h.store.GetTransactionCount, h.store.CacheTransactionCount),
+		"eth_getCode":             h.accountStateHandler(h.store.GetCode, h.store.CacheCode),
+		"eth_getLogs": {
+			before: h.hdlGetLogsBefore,
+			after:  h.hdlGetLogsAfter,
 		},
 	}
+	h.locals = make(map[string]localFunc)
+	if txTracker != nil || nonces != nil {
+		h.handlers[sendRawTxMethod] = &handler{
+			after: h.hdlSendRawTransactionAfter,
+		}
+	}
+	if txTracker != nil {
+		h.locals["chaind_getTransactionStatus"] = h.hdlGetTransactionStatus
+	}
+	if nonces != nil {
+		h.handlers["eth_getTransactionCount"].rewrite = h.hdlGetTransactionCountRewrite
+	}
 	return h
 }
 
-func (h *EthHandler) Handle(res http.ResponseWriter, req *http.Request, backend *config.Backend) {
+// SetEnabledAPIs replaces the list of APIs clients are allowed to call.
+func (h *EthHandler) SetEnabledAPIs(apis []string) {
+	h.apiMtx.Lock()
+	defer h.apiMtx.Unlock()
+	h.enabledAPIs = sets.NewStringSet(apis)
+}
+
+// SetRecorder sets the recorder that captures the handler's traffic. It must
+// be called before the handler serves any requests.
+func (h *EthHandler) SetRecorder(rec *recorder.Recorder) {
+	h.recorder = rec
+}
+
+func (h *EthHandler) Handle(res http.ResponseWriter, req *http.Request) {
 	defer req.Body.Close()
 	logger := log.WithContext(h.logger, req.Context())
+	start := time.Now()
+	_, parseSpan := tracing.Tracer().Start(req.Context(), "eth.parse")
 	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
 		logger.Error("failed to read request body")
+		endWithError(parseSpan, err)
 		return
 	}
 
+	if h.recorder != nil {
+		resRecorder := &responseRecorder{ResponseWriter: res}
+		res = resRecorder
+		defer func() {
+			h.recorder.Record(&recorder.Exchange{
+				Time:     start,
+				Duration: time.Since(start),
+				Request:  body,
+				Response: resRecorder.body,
+			})
+		}()
+	}
+
 	h.requestCount.Add(1)
 
 	firstChar := string(body[0])
@@ -126,14 +263,19 @@ func (h *EthHandler) Handle(res http.ResponseWriter, req *http.Request, backend
 		err = json.Unmarshal(body, &rpcReqs)
 		if err != nil {
 			logger.Warn("received mal-formed batch request")
+			endWithError(parseSpan, err)
 			res.WriteHeader(http.StatusBadRequest)
 			return
 		}
+		parseSpan.SetAttributes(attribute.Int("chaind.batch_size", len(rpcReqs)))
+		parseSpan.End()
 
 		h.batchSize.Observe(float64(len(rpcReqs)))
 		batch := pkg.NewBatchResponse(res)
-		for _, rpcReq := range rpcReqs {
-			h.hdlRPCRequest(batch.ResponseWriter(), req, backend, &rpcReq)
+		for i, rpcReq := range rpcReqs {
+			ctx, span := tracing.Tracer().Start(req.Context(), "eth.batch_item", trace.WithAttributes(attribute.Int("chaind.batch_index", i)))
+			h.hdlRPCRequest(batch.ResponseWriter(), req.WithContext(ctx), &rpcReq)
+			span.End()
 		}
 		if err := batch.Flush(); err != nil {
 			logger.Error("failed to flush batch")
@@ -147,71 +289,176 @@ func (h *EthHandler) Handle(res http.ResponseWriter, req *http.Request, backend
 		err = json.Unmarshal(body, &rpcReq)
 		if err != nil {
 			logger.Warn("received mal-formed request")
+			endWithError(parseSpan, err)
 			res.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		h.hdlRPCRequest(res, req, backend, &rpcReq)
+		parseSpan.End()
+		h.hdlRPCRequest(res, req, &rpcReq)
 	}
 }
 
-func (h *EthHandler) hdlRPCRequest(res http.ResponseWriter, req *http.Request, backend *config.Backend, rpcReq *jsonrpc.Request) {
+// hdlRPCRequest serves a single JSON-RPC request, and records it in the audit
+// log once its response has been written.
+func (h *EthHandler) hdlRPCRequest(res http.ResponseWriter, req *http.Request, rpcReq *jsonrpc.Request) {
+	start := time.Now()
+	recorder := &responseRecorder{ResponseWriter: res}
+	requestID, _ := req.Context().Value(log.RequestIDKey).(string)
+	record := &audit.Record{
+		RequestID: requestID,
+		Method:    rpcReq.Method,
+		Params:    rpcReq.Params,
+	}
+	outcome := h.serveRPCRequest(recorder, req, rpcReq, record)
+
+	record.Latency = time.Since(start)
+	record.ResponseSize = len(recorder.body)
+	if rpcErr := gjson.GetBytes(recorder.body, "error"); rpcErr.Exists() {
+		record.ErrorCode = int(rpcErr.Get("code").Int())
+		record.Error = rpcErr.Get("message").String()
+	}
+	if err := h.auditor.RecordRequest(req, record); err != nil {
+		log.WithContext(h.logger, req.Context()).Error("failed to record audit log for request", "err", err)
+	}
+
+	method := rpcReq.Method
+	if (h.locals[method] == nil && !h.apiEnabled(method)) || record.ErrorCode == jsonrpc.MethodNotFoundCode {
+		method = unknownMethod
+	}
+	backendName := record.Backend
+	if backendName == "" {
+		backendName = "none"
+	}
+	h.requestDuration.WithLabelValues(method, backendName, outcome).Observe(record.Latency.Seconds())
+
+	// the request's span is the server span for single requests, and the
+	// batch item span for batch requests
+	span := trace.SpanFromContext(req.Context())
+	span.SetAttributes(
+		attribute.String("rpc.method", rpcReq.Method),
+		attribute.String("chaind.backend", record.Backend),
+		attribute.String("chaind.cache", outcome),
+	)
+	if record.ErrorCode != 0 {
+		span.SetAttributes(attribute.Int("rpc.jsonrpc.error_code", record.ErrorCode))
+		span.SetStatus(codes.Error, record.Error)
+	}
+}
+
+func (h *EthHandler) apiEnabled(method string) bool {
+	split := strings.Split(method, "_")
+	h.apiMtx.RLock()
+	defer h.apiMtx.RUnlock()
+	return h.enabledAPIs.Contains(split[0])
+}
+
+// serveRPCRequest serves a single JSON-RPC request, and returns its cache
+// outcome.
+func (h *EthHandler) serveRPCRequest(res *responseRecorder, req *http.Request, rpcReq *jsonrpc.Request, record *audit.Record) string {
 	logger := log.WithContext(h.logger, req.Context())
 	body, err := json.Marshal(rpcReq)
 	if err != nil {
 		logger.Error("failed to unmarshal request body")
-		return
+		return cacheNone
 	}
 
-	err = h.auditor.RecordRequest(req, body, pkg.EthBackend)
-	if err != nil {
-		logger.Error("failed to record audit log for request")
+	// chaind's own methods are answered locally, and aren't subject to the
+	// enabled API list
+	if local := h.locals[rpcReq.Method]; local != nil {
+		local(res, rpcReq, logger)
+		return cacheNone
 	}
 
-	split := strings.Split(rpcReq.Method, "_")
-	if !h.enabledAPIs.Contains(split[0]) {
+	_, aclSpan := tracing.Tracer().Start(req.Context(), "eth.acl")
+	enabled := h.apiEnabled(rpcReq.Method)
+	aclSpan.SetAttributes(attribute.Bool("chaind.allowed", enabled))
+	aclSpan.End()
+	if !enabled {
 		failRequest(res, rpcReq.ID, -32602, "bad request")
-		return
+		return cacheNone
 	}
 
+	// quorum requests bypass the cache, since it was populated by a single backend
+	useQuorum := h.quorumCfg.Applies(req, rpcReq)
 	hdlr := h.handlers[rpcReq.Method]
+	client := h.sticky.ClientFor(req)
 	handledInBefore := false
-	if hdlr != nil && hdlr.before != nil {
+	outcome := cacheNone
+	if hdlr != nil && hdlr.before != nil && !useQuorum {
+		_, cacheSpan := tracing.Tracer().Start(req.Context(), "eth.cache_lookup")
 		handledInBefore = hdlr.before(res, rpcReq, logger)
+		cacheSpan.SetAttributes(attribute.Bool("chaind.cache_hit", handledInBefore))
+		cacheSpan.End()
+		h.store.RecordLookup(rpcReq.Method, handledInBefore)
+		outcome = cacheMiss
 	}
 	if handledInBefore {
+		record.Cached = true
+		h.sticky.Observe(client, rpcReq.Method, []byte(gjson.GetBytes(res.body, "result").Raw))
 		h.cacheHits.Add(1)
 		logger.Debug("request handled in before filter")
-		return
+		return cacheHit
 	}
 	h.cacheMisses.Add(1)
 
-	proxyRes, err := h.client.Post(backend.URL, "application/json", bytes.NewReader(body))
-	if err != nil || proxyRes.StatusCode != 200 {
+	// the backend is only resolved once the request has been routed, so that
+	// a pool can serve requests while the default pool is down
+	pool := h.router.PoolFor(rpcReq)
+	back, err := h.sw.BackendForPool(pkg.EthBackend, pool)
+	if err != nil {
+		logger.Error("no backends available in pool", "pool", pool, "err", err)
+		failRequest(res, rpcReq.ID, -32603, "no backends available")
+		return outcome
+	}
+	if pool != backend.DefaultPool {
+		logger.Debug("routed request to pool", "method", rpcReq.Method, "pool", pool, "backend", back.Name)
+	}
+	// broadcasts and quorum reads go to every backend in the pool, not back
+	if client != "" && rpcReq.Method != sendRawTxMethod && !useQuorum {
+		back = h.stickyBackend(req.Context(), client, pool, back, logger)
+	}
+	record.Backend = back.Name
+
+	var resBody []byte
+	if rpcReq.Method == sendRawTxMethod {
+		resBody, err = h.broadcast(req, pool, rpcReq, body, logger)
+	} else if useQuorum {
+		resBody, err = h.quorum(req.Context(), pool, rpcReq.Method, body, logger)
+	} else if delay, ok := h.hedges[rpcReq.Method]; ok {
+		resBody, err = h.hedge(req.Context(), pool, back, rpcReq.Method, body, delay, logger)
+	} else {
+		resBody, err = h.post(req.Context(), back, body)
+	}
+	if err == errQuorumNotReached || err == errQuorumUnavailable || err == errBroadcastRejected {
+		failRequest(res, rpcReq.ID, -32000, err.Error())
+		return outcome
+	}
+	if err != nil {
 		logger.Error("received error result from backend", "err", err)
 		failRequest(res, rpcReq.ID, -32602, "bad request")
-		return
+		return outcome
 	}
-	defer proxyRes.Body.Close()
 
-	resBody, err := ioutil.ReadAll(proxyRes.Body)
-	if err != nil {
-		failWithInternalError(res, rpcReq.ID, err)
-		logger.Error("failed to read body", "err", err)
-		return
+	if hdlr != nil && hdlr.rewrite != nil {
+		resBody = hdlr.rewrite(resBody, rpcReq, logger)
 	}
 
 	_, err = res.Write(resBody)
 	if err != nil {
 		logger.Error("failed to flush proxied request")
 		failWithInternalError(res, rpcReq.ID, err)
-		return
+		return outcome
 	}
 
 	var rpcRes jsonrpc.Response
 	err = json.Unmarshal(resBody, &rpcRes)
 	if err != nil {
 		logger.Error("received un-parseable response from backend", "err", err)
-		return
+		return outcome
+	}
+
+	if rpcRes.Error == nil {
+		h.sticky.Observe(client, rpcReq.Method, rpcRes.Result)
 	}
 
 	if hdlr != nil && hdlr.after != nil {
@@ -221,6 +468,56 @@ func (h *EthHandler) hdlRPCRequest(res http.ResponseWriter, req *http.Request, b
 	} else {
 		logger.Debug("no post-processor found")
 	}
+	return outcome
+}
+
+func (h *EthHandler) post(ctx context.Context, back *config.Backend, body []byte) ([]byte, error) {
+	ctx, span := tracing.Tracer().Start(
+		ctx,
+		"eth.upstream",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("chaind.backend", back.Name)),
+	)
+	defer span.End()
+
+	httpReq, err := http.NewRequest("POST", back.URL, bytes.NewReader(body))
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	httpReq = httpReq.WithContext(ctx)
+	httpReq.Header.Set("Content-Type", "application/json")
+	if requestID, ok := ctx.Value(log.RequestIDKey).(string); ok {
+		httpReq.Header.Set(requestIDHeader, requestID)
+	}
+	tracing.Inject(ctx, httpReq.Header)
+
+	proxyRes, err := h.client.Do(httpReq)
+	if err != nil {
+		// hedged and quorum requests cancel the requests they no longer need
+		if ctx.Err() != context.Canceled {
+			h.upstreamErrors.WithLabelValues(back.Name, backend.ErrorReason(err)).Inc()
+		}
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	defer proxyRes.Body.Close()
+	span.SetAttributes(attribute.Int("http.status_code", proxyRes.StatusCode))
+	if proxyRes.StatusCode != 200 {
+		h.upstreamErrors.WithLabelValues(back.Name, backend.ReasonStatus).Inc()
+		err := fmt.Errorf("backend %s returned status code %d", back.Name, proxyRes.StatusCode)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	resBody, err := ioutil.ReadAll(proxyRes.Body)
+	if err != nil {
+		if ctx.Err() != context.Canceled {
+			h.upstreamErrors.WithLabelValues(back.Name, backend.ErrorReason(err)).Inc()
+		}
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return resBody, err
 }
 
 func (h *EthHandler) hdlBlockNumberBefore(res http.ResponseWriter, rpcReq *jsonrpc.Request, logger log15.Logger) bool {
@@ -318,41 +615,143 @@ func (h *EthHandler) hdlGetTransactionReceiptAfter(rpcRes *jsonrpc.Response, rpc
 	return h.store.CacheTransactionReceipt(rpcRes.Result)
 }
 
-func (h *EthHandler) hdlGetBalanceBefore(res http.ResponseWriter, rpcReq *jsonrpc.Request, logger log15.Logger) bool {
-	logger.Debug("pre-processing eth_getBalance")
-	results := gjson.GetManyBytes(rpcReq.Params, "0", "1")
-	if results[1].String() != "latest" {
-		return false
+// accountStateHandler serves methods that take an address and a block, such
+// as eth_getBalance, from the cache when the block is latest.
+func (h *EthHandler) accountStateHandler(get func(string) ([]byte, error), cache func(string, []byte) error) *handler {
+	return &handler{
+		before: func(res http.ResponseWriter, rpcReq *jsonrpc.Request, logger log15.Logger) bool {
+			logger.Debug("pre-processing " + rpcReq.Method)
+			results := gjson.GetManyBytes(rpcReq.Params, "0", "1")
+			if results[1].String() != "latest" {
+				return false
+			}
+
+			addr := results[0].String()
+			if addr == "" {
+				logger.Info("encountered empty address, bailing")
+				return false
+			}
+			cached, err := get(addr)
+			if err != nil {
+				logger.Error("failed to get account state from cache", "err", err)
+				return false
+			}
+			if cached == nil {
+				logger.Debug("no cached account state found")
+				return false
+			}
+			err = writeResponse(res, rpcReq.ID, cached)
+			if err != nil {
+				logger.Error("encountered error writing response")
+				return false
+			}
+
+			return true
+		},
+		after: func(rpcRes *jsonrpc.Response, rpcReq *jsonrpc.Request, logger log15.Logger) error {
+			logger.Debug("post-processing " + rpcReq.Method)
+			results := gjson.GetManyBytes(rpcReq.Params, "0", "1")
+			if results[1].String() != "latest" || rpcRes.Error != nil {
+				return nil
+			}
+			addr := results[0].String()
+			if addr == "" {
+				logger.Debug("skipping mal-formed address")
+				return nil
+			}
+
+			return cache(addr, rpcRes.Result)
+		},
 	}
+}
 
-	addr := results[0].String()
-	if addr == "" {
-		logger.Info("encountered empty address, bailing")
-		return false
+func (h *EthHandler) hdlSendRawTransactionAfter(rpcRes *jsonrpc.Response, rpcReq *jsonrpc.Request, logger log15.Logger) error {
+	logger.Debug("post-processing eth_sendRawTransaction")
+	txHash := gjson.ParseBytes(rpcRes.Result).String()
+	if txHash == "" {
+		logger.Debug("skipping tracking for failed transaction")
+		return nil
 	}
-	cached, err := h.store.GetBalance(addr)
-	if cached == nil {
-		logger.Debug("no cached balance found")
-		return false
+
+	rawTx := gjson.GetBytes(rpcReq.Params, "0").String()
+	if h.tracker != nil {
+		h.tracker.Track(txHash, rawTx)
+	}
+	if h.nonces != nil {
+		if _, err := h.nonces.Observe(rawTx); err != nil {
+			logger.Warn("failed to decode sent transaction", "tx_hash", txHash, "err", err)
+		}
+	}
+	return nil
+}
+
+func (h *EthHandler) hdlGetTransactionCountRewrite(resBody []byte, rpcReq *jsonrpc.Request, logger log15.Logger) []byte {
+	if gjson.GetBytes(rpcReq.Params, "1").String() != "pending" {
+		return resBody
+	}
+
+	var rpcRes jsonrpc.Response
+	if err := json.Unmarshal(resBody, &rpcRes); err != nil || rpcRes.Error != nil {
+		return resBody
 	}
-	err = writeResponse(res, rpcReq.ID, cached)
+	backendNonce, err := jsonrpc.Hex2Uint64(gjson.ParseBytes(rpcRes.Result).String())
 	if err != nil {
-		logger.Error("encountered error writing response")
-		return false
+		logger.Warn("received invalid transaction count from backend", "err", err)
+		return resBody
 	}
 
-	return true
+	addr := gjson.GetBytes(rpcReq.Params, "0").String()
+	next := h.nonces.PendingNonce(addr, backendNonce)
+	if next == backendNonce {
+		return resBody
+	}
+
+	logger.Info("backend is behind on pending nonce", "address", addr, "backend_nonce", backendNonce, "nonce", next)
+	rpcRes.Result = json.RawMessage(fmt.Sprintf("\"%s\"", jsonrpc.Uint642Hex(next)))
+	out, err := json.Marshal(rpcRes)
+	if err != nil {
+		logger.Error("failed to marshal rewritten transaction count", "err", err)
+		return resBody
+	}
+	return out
 }
 
-func (h *EthHandler) hdlGetBalanceAfter(rpcRes *jsonrpc.Response, rpcReq *jsonrpc.Request, logger log15.Logger) error {
-	h.logger.Debug("post-processing eth_getBalance")
-	addr := gjson.GetBytes(rpcReq.Params, "0").String()
-	if addr == "" {
-		h.logger.Debug("skipping mal-formed address")
-		return nil
+func (h *EthHandler) hdlGetTransactionStatus(res http.ResponseWriter, rpcReq *jsonrpc.Request, logger log15.Logger) {
+	txHash := gjson.GetBytes(rpcReq.Params, "0").String()
+	if txHash == "" {
+		failRequest(res, rpcReq.ID, -32602, "missing transaction hash")
+		return
+	}
+
+	status := h.tracker.Status(txHash)
+	data, err := json.Marshal(status)
+	if err != nil {
+		logger.Error("failed to marshal transaction status", "err", err)
+		failWithInternalError(res, rpcReq.ID, err)
+		return
 	}
 
-	return h.store.CacheBalance(addr, rpcRes.Result)
+	if err := writeResponse(res, rpcReq.ID, data); err != nil {
+		logger.Error("failed to write transaction status", "err", err)
+	}
+}
+
+// responseRecorder keeps a copy of everything written to the response, so
+// that it can be audited and inspected once the request completes.
+type responseRecorder struct {
+	http.ResponseWriter
+	body []byte
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.body = append(r.body, data...)
+	return r.ResponseWriter.Write(data)
+}
+
+func endWithError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	span.End()
 }
 
 func writeResponse(res http.ResponseWriter, id interface{}, data []byte) error {
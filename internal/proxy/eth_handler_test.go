@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"github.com/inconshreveable/log15"
+	"github.com/kyokan/chaind/internal/audit"
+	"github.com/kyokan/chaind/internal/backend"
+	"github.com/kyokan/chaind/internal/backend/backendtest"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/kyokan/chaind/pkg/sets"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+// testAuditor keeps the records it's given.
+type testAuditor struct {
+	records []audit.Record
+	mtx     sync.Mutex
+}
+
+func (a *testAuditor) RecordRequest(req *http.Request, record *audit.Record) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	a.records = append(a.records, *record)
+	return nil
+}
+
+func (a *testAuditor) RecordBroadcast(req *http.Request, txHash string, results []audit.BroadcastResult) error {
+	return nil
+}
+
+// newTestHandler returns an EthHandler over sw without any caching, whose
+// metrics aren't registered so that tests can create as many as they need.
+func newTestHandler(sw backend.Switcher) *EthHandler {
+	logger := log15.New()
+	logger.SetHandler(log15.DiscardHandler())
+	return &EthHandler{
+		sw:          sw,
+		auditor:     new(testAuditor),
+		handlers:    make(map[string]*handler),
+		locals:      make(map[string]localFunc),
+		logger:      logger,
+		client:      http.DefaultClient,
+		enabledAPIs: sets.NewStringSet([]string{"eth", "net", "web3", "debug"}),
+		hedges:      make(map[string]time.Duration),
+		router:      NewRouter(nil, nil),
+		requestCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "test_request_count",
+		}),
+		cacheHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "test_cache_hits",
+		}),
+		cacheMisses: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "test_cache_misses",
+		}),
+		batchRequestCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "test_batch_request_count",
+		}),
+		singleRequestCount: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "test_single_request_count",
+		}),
+		batchSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name: "test_batch_size",
+		}),
+		hedgeEligible: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "test_hedge_eligible",
+		}, []string{"method_name"}),
+		hedgeCount: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "test_hedge_count",
+		}, []string{"method_name"}),
+		hedgeWins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "test_hedge_wins",
+		}, []string{"method_name"}),
+		quorumResults: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "test_quorum_results",
+		}, []string{"method_name", "outcome"}),
+		stickyResults: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "test_sticky_results",
+		}, []string{"outcome"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "test_request_duration_seconds",
+		}, []string{"method_name", "backend", "cache"}),
+		upstreamErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "test_upstream_errors",
+		}, []string{"backend", "reason"}),
+	}
+}
+
+func counterValue(t *testing.T, c prometheus.Counter) float64 {
+	var m dto.Metric
+	require.NoError(t, c.Write(&m))
+	return m.GetCounter().GetValue()
+}
+
+// serve sends body to h, and returns the response body.
+func serve(t *testing.T, h *EthHandler, body string) string {
+	res := httptest.NewRecorder()
+	h.Handle(res, httptest.NewRequest("POST", "/", strings.NewReader(body)))
+	require.Equal(t, http.StatusOK, res.Code)
+	return res.Body.String()
+}
+
+func TestHandle_RoutedPoolWithoutDefaultPool(t *testing.T) {
+	archive := newTestBackend(0, 200, `{"jsonrpc":"2.0","id":1,"result":"0xabc"}`)
+	defer archive.srv.Close()
+	back := archive.backend("archive")
+	back.Tags = []string{"archive"}
+
+	h := newTestHandler(backendtest.NewSwitch(back))
+	h.router = NewRouter([]config.Route{{Pool: "archive", Methods: []string{"debug_*"}}}, nil)
+
+	// the archive pool serves its requests while the default pool is empty
+	res := serve(t, h, `{"jsonrpc":"2.0","id":1,"method":"debug_traceTransaction","params":["0x01"]}`)
+	require.Equal(t, "0xabc", gjson.Get(res, "result").String())
+
+	res = serve(t, h, `{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`)
+	require.Equal(t, int64(-32603), gjson.Get(res, "error.code").Int())
+	require.Equal(t, "no backends available", gjson.Get(res, "error.message").String())
+
+	records := h.auditor.(*testAuditor).records
+	require.Len(t, records, 2)
+	require.Equal(t, "archive", records[0].Backend)
+	require.Equal(t, "", records[1].Backend)
+}
@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"context"
+	"time"
+	"errors"
+	"github.com/inconshreveable/log15"
+	"github.com/kyokan/chaind/pkg"
+	"github.com/kyokan/chaind/pkg/config"
+)
+
+type upstreamResult struct {
+	body    []byte
+	backend *config.Backend
+	err     error
+}
+
+// hedge sends body to the primary backend. If the primary hasn't answered
+// within delay, or fails before then, the same request is sent to a second
+// healthy backend from the same pool. Whichever backend answers successfully first wins, and the
+// other request is cancelled.
+func (h *EthHandler) hedge(ctx context.Context, pool string, primary *config.Backend, method string, body []byte, delay time.Duration, logger log15.Logger) ([]byte, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	h.hedgeEligible.WithLabelValues(method).Inc()
+	results := make(chan *upstreamResult, 2)
+	go h.postAsync(ctx, primary, body, results)
+	pending := 1
+	hedged := false
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var lastErr error
+	for {
+		select {
+		case <-timer.C:
+			if hedged {
+				continue
+			}
+			hedged = true
+			if h.sendHedge(ctx, pool, primary, method, body, results, logger) {
+				pending++
+			}
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				if res.backend != primary {
+					h.hedgeWins.WithLabelValues(method).Inc()
+					logger.Debug("hedged request won", "method", method, "backend", res.backend.Name)
+				}
+				return res.body, nil
+			}
+
+			lastErr = res.err
+			logger.Warn("upstream request failed", "method", method, "backend", res.backend.Name, "err", res.err)
+			if !hedged {
+				hedged = true
+				if h.sendHedge(ctx, pool, primary, method, body, results, logger) {
+					pending++
+				}
+			}
+			if pending == 0 {
+				return nil, lastErr
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (h *EthHandler) sendHedge(ctx context.Context, pool string, primary *config.Backend, method string, body []byte, results chan *upstreamResult, logger log15.Logger) bool {
+	secondary := h.secondaryBackend(pool, primary)
+	if secondary == nil {
+		logger.Debug("no secondary backend available to hedge to", "method", method)
+		return false
+	}
+
+	h.hedgeCount.WithLabelValues(method).Inc()
+	logger.Debug("hedging request", "method", method, "primary", primary.Name, "secondary", secondary.Name)
+	go h.postAsync(ctx, secondary, body, results)
+	return true
+}
+
+func (h *EthHandler) secondaryBackend(pool string, primary *config.Backend) *config.Backend {
+	for _, backend := range h.sw.HealthyBackendsForPool(pkg.EthBackend, pool) {
+		if backend.Name != primary.Name {
+			return &backend
+		}
+	}
+
+	return nil
+}
+
+func (h *EthHandler) postAsync(ctx context.Context, backend *config.Backend, body []byte, results chan *upstreamResult) {
+	resBody, err := h.post(ctx, backend, body)
+	if err == nil && len(resBody) == 0 {
+		err = errors.New("backend returned an empty response")
+	}
+
+	results <- &upstreamResult{
+		body:    resBody,
+		backend: backend,
+		err:     err,
+	}
+}
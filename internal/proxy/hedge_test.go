@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+	"github.com/kyokan/chaind/internal/backend"
+	"github.com/kyokan/chaind/internal/backend/backendtest"
+	"github.com/kyokan/chaind/pkg"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/stretchr/testify/require"
+)
+
+const hedgeMethod = "eth_call"
+
+// testBackend answers after a delay, and records whether the request was
+// cancelled before then.
+type testBackend struct {
+	srv       *httptest.Server
+	hits      int32
+	cancelled chan struct{}
+}
+
+func newTestBackend(delay time.Duration, status int, body string) *testBackend {
+	b := &testBackend{cancelled: make(chan struct{}, 1)}
+	b.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&b.hits, 1)
+		// the server only notices that the client went away once the body
+		// has been read
+		ioutil.ReadAll(r.Body)
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			b.cancelled <- struct{}{}
+			return
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	return b
+}
+
+func (b *testBackend) backend(name string) config.Backend {
+	return config.Backend{Name: name, URL: b.srv.URL}
+}
+
+func (b *testBackend) requireCancelled(t *testing.T) {
+	select {
+	case <-b.cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("request to losing backend was not cancelled")
+	}
+}
+
+func runHedge(t *testing.T, sw backend.Switcher, delay time.Duration) (*EthHandler, []byte, error) {
+	h := newTestHandler(sw)
+	primary, err := sw.BackendFor(pkg.EthBackend)
+	require.NoError(t, err)
+	body, err := h.hedge(context.Background(), backend.DefaultPool, primary, hedgeMethod, []byte(`{}`), delay, h.logger)
+	return h, body, err
+}
+
+func TestHedge_SecondaryWins(t *testing.T) {
+	slow := newTestBackend(5*time.Second, 200, `"slow"`)
+	defer slow.srv.Close()
+	fast := newTestBackend(0, 200, `"fast"`)
+	defer fast.srv.Close()
+
+	start := time.Now()
+	h, body, err := runHedge(t, backendtest.NewSwitch(slow.backend("slow"), fast.backend("fast")), 50*time.Millisecond)
+	require.NoError(t, err)
+	require.Equal(t, `"fast"`, string(body))
+	require.True(t, time.Since(start) >= 50*time.Millisecond)
+	slow.requireCancelled(t)
+	require.Equal(t, float64(1), counterValue(t, h.hedgeEligible.WithLabelValues(hedgeMethod)))
+	require.Equal(t, float64(1), counterValue(t, h.hedgeCount.WithLabelValues(hedgeMethod)))
+	require.Equal(t, float64(1), counterValue(t, h.hedgeWins.WithLabelValues(hedgeMethod)))
+}
+
+func TestHedge_PrimaryWinsAfterHedging(t *testing.T) {
+	primary := newTestBackend(100*time.Millisecond, 200, `"primary"`)
+	defer primary.srv.Close()
+	secondary := newTestBackend(5*time.Second, 200, `"secondary"`)
+	defer secondary.srv.Close()
+
+	h, body, err := runHedge(t, backendtest.NewSwitch(primary.backend("primary"), secondary.backend("secondary")), 20*time.Millisecond)
+	require.NoError(t, err)
+	require.Equal(t, `"primary"`, string(body))
+	secondary.requireCancelled(t)
+	require.Equal(t, float64(1), counterValue(t, h.hedgeCount.WithLabelValues(hedgeMethod)))
+	require.Equal(t, float64(0), counterValue(t, h.hedgeWins.WithLabelValues(hedgeMethod)))
+}
+
+func TestHedge_PrimaryBeforeDelay(t *testing.T) {
+	primary := newTestBackend(0, 200, `"primary"`)
+	defer primary.srv.Close()
+	secondary := newTestBackend(0, 200, `"secondary"`)
+	defer secondary.srv.Close()
+
+	h, body, err := runHedge(t, backendtest.NewSwitch(primary.backend("primary"), secondary.backend("secondary")), time.Second)
+	require.NoError(t, err)
+	require.Equal(t, `"primary"`, string(body))
+	require.Equal(t, int32(0), atomic.LoadInt32(&secondary.hits))
+	require.Equal(t, float64(0), counterValue(t, h.hedgeCount.WithLabelValues(hedgeMethod)))
+}
+
+func TestHedge_PrimaryFailsBeforeDelay(t *testing.T) {
+	primary := newTestBackend(0, 502, "")
+	defer primary.srv.Close()
+	secondary := newTestBackend(0, 200, `"secondary"`)
+	defer secondary.srv.Close()
+
+	// the hedge is sent as soon as the primary fails, without waiting
+	start := time.Now()
+	h, body, err := runHedge(t, backendtest.NewSwitch(primary.backend("primary"), secondary.backend("secondary")), 5*time.Second)
+	require.NoError(t, err)
+	require.Equal(t, `"secondary"`, string(body))
+	require.True(t, time.Since(start) < time.Second)
+	require.Equal(t, float64(1), counterValue(t, h.hedgeCount.WithLabelValues(hedgeMethod)))
+	require.Equal(t, float64(1), counterValue(t, h.hedgeWins.WithLabelValues(hedgeMethod)))
+}
+
+func TestHedge_BothFail(t *testing.T) {
+	primary := newTestBackend(0, 502, "")
+	defer primary.srv.Close()
+	secondary := newTestBackend(0, 503, "")
+	defer secondary.srv.Close()
+
+	_, _, err := runHedge(t, backendtest.NewSwitch(primary.backend("primary"), secondary.backend("secondary")), 5*time.Second)
+	require.Error(t, err)
+}
+
+func TestHedge_NoSecondary(t *testing.T) {
+	primary := newTestBackend(100*time.Millisecond, 200, `"primary"`)
+	defer primary.srv.Close()
+
+	h, body, err := runHedge(t, backendtest.NewSwitch(primary.backend("primary")), 20*time.Millisecond)
+	require.NoError(t, err)
+	require.Equal(t, `"primary"`, string(body))
+	require.Equal(t, float64(0), counterValue(t, h.hedgeCount.WithLabelValues(hedgeMethod)))
+
+	failing := newTestBackend(0, 502, "")
+	defer failing.srv.Close()
+	_, _, err = runHedge(t, backendtest.NewSwitch(failing.backend("failing")), 5*time.Second)
+	require.Error(t, err)
+}
+
+func TestSecondaryBackend(t *testing.T) {
+	sw := backendtest.NewSwitch(
+		config.Backend{Name: "full-1"},
+		config.Backend{Name: "archive-1", Tags: []string{"archive"}},
+		config.Backend{Name: "full-2"},
+		config.Backend{Name: "archive-2", Tags: []string{"archive"}},
+	)
+	h := newTestHandler(sw)
+
+	require.Equal(t, "full-2", h.secondaryBackend(backend.DefaultPool, &config.Backend{Name: "full-1"}).Name)
+	require.Equal(t, "full-1", h.secondaryBackend(backend.DefaultPool, &config.Backend{Name: "full-2"}).Name)
+	// secondaries come from the primary's pool
+	require.Equal(t, "archive-2", h.secondaryBackend("archive", &config.Backend{Name: "archive-1"}).Name)
+	require.Nil(t, h.secondaryBackend("trace", &config.Backend{Name: "archive-1"}))
+
+	sw.SetBackends(config.Backend{Name: "full-1"})
+	require.Nil(t, h.secondaryBackend(backend.DefaultPool, &config.Backend{Name: "full-1"}))
+}
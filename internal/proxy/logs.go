@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+	"github.com/inconshreveable/log15"
+	"github.com/kyokan/chaind/pkg/jsonrpc"
+	"github.com/tidwall/gjson"
+)
+
+// singleBlockFilter returns the block number of eth_getLogs filters that
+// cover exactly one block. Every log in a block is cached together, so only
+// these filters can be served from the cache.
+func singleBlockFilter(filter gjson.Result) (uint64, bool) {
+	if filter.Get("blockHash").Exists() {
+		return 0, false
+	}
+	from := filter.Get("fromBlock").String()
+	if from != filter.Get("toBlock").String() {
+		return 0, false
+	}
+	blockNum, err := jsonrpc.Hex2Uint64(from)
+	if err != nil {
+		return 0, false
+	}
+
+	return blockNum, true
+}
+
+func (h *EthHandler) hdlGetLogsBefore(res http.ResponseWriter, rpcReq *jsonrpc.Request, logger log15.Logger) bool {
+	logger.Debug("pre-processing eth_getLogs")
+	filter := gjson.GetBytes(rpcReq.Params, "0")
+	blockNum, ok := singleBlockFilter(filter)
+	if !ok {
+		return false
+	}
+
+	cached, err := h.store.GetLogs(blockNum)
+	if err != nil {
+		logger.Error("failed to get logs from cache", "err", err)
+		return false
+	}
+	if cached == nil {
+		logger.Debug("found no logs in logs cache")
+		return false
+	}
+
+	err = writeResponse(res, rpcReq.ID, filterLogs(cached, filter))
+	if err != nil {
+		logger.Error("failed to write cached response", "err", err)
+		return false
+	}
+
+	logger.Debug("found cached logs response, sending")
+	return true
+}
+
+func (h *EthHandler) hdlGetLogsAfter(rpcRes *jsonrpc.Response, rpcReq *jsonrpc.Request, logger log15.Logger) error {
+	logger.Debug("post-processing eth_getLogs")
+	filter := gjson.GetBytes(rpcReq.Params, "0")
+	blockNum, ok := singleBlockFilter(filter)
+	if !ok || rpcRes.Error != nil {
+		return nil
+	}
+	// filtered responses don't contain every log in the block
+	if filter.Get("address").Exists() || filter.Get("topics").Exists() {
+		return nil
+	}
+
+	return h.store.CacheLogs(blockNum, rpcRes.Result)
+}
+
+// filterLogs applies the filter's address and topic criteria to a block's
+// logs.
+func filterLogs(logs []byte, filter gjson.Result) []byte {
+	addresses := filterValues(filter.Get("address"))
+	topics := filter.Get("topics").Array()
+	if len(addresses) == 0 && len(topics) == 0 {
+		return logs
+	}
+
+	out := []byte("[")
+	for _, entry := range gjson.ParseBytes(logs).Array() {
+		if !logMatches(entry, addresses, topics) {
+			continue
+		}
+		if len(out) > 1 {
+			out = append(out, ',')
+		}
+		out = append(out, entry.Raw...)
+	}
+	return append(out, ']')
+}
+
+func logMatches(entry gjson.Result, addresses []string, topics []gjson.Result) bool {
+	if len(addresses) > 0 && !containsFold(addresses, entry.Get("address").String()) {
+		return false
+	}
+
+	entryTopics := entry.Get("topics").Array()
+	for i, topic := range topics {
+		// null matches any topic in this position
+		wanted := filterValues(topic)
+		if len(wanted) == 0 {
+			continue
+		}
+		if i >= len(entryTopics) || !containsFold(wanted, entryTopics[i].String()) {
+			return false
+		}
+	}
+	return true
+}
+
+// filterValues returns the values of filter criteria that may be either a
+// single string or a list of alternatives.
+func filterValues(val gjson.Result) []string {
+	if val.IsArray() {
+		var out []string
+		for _, v := range val.Array() {
+			out = append(out, v.String())
+		}
+		return out
+	}
+	if val.Type == gjson.String {
+		return []string{val.String()}
+	}
+	return nil
+}
+
+func containsFold(vals []string, val string) bool {
+	for _, v := range vals {
+		if strings.EqualFold(v, val) {
+			return true
+		}
+	}
+	return false
+}
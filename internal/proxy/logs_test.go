@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"testing"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+const blockLogs = `[{"address":"0xAA","topics":["0x01","0x02"]},{"address":"0xbb","topics":["0x01"]},{"address":"0xcc","topics":[]}]`
+
+func TestSingleBlockFilter(t *testing.T) {
+	blockNum, ok := singleBlockFilter(gjson.Parse(`{"fromBlock":"0x10","toBlock":"0x10"}`))
+	require.True(t, ok)
+	require.Equal(t, uint64(16), blockNum)
+
+	_, ok = singleBlockFilter(gjson.Parse(`{"fromBlock":"0x10","toBlock":"0x11"}`))
+	require.False(t, ok)
+	_, ok = singleBlockFilter(gjson.Parse(`{"fromBlock":"latest","toBlock":"latest"}`))
+	require.False(t, ok)
+	_, ok = singleBlockFilter(gjson.Parse(`{"blockHash":"0xab"}`))
+	require.False(t, ok)
+}
+
+func TestFilterLogs(t *testing.T) {
+	tests := []struct {
+		filter   string
+		expected string
+	}{
+		{`{}`, blockLogs},
+		{`{"address":"0xaa"}`, `[{"address":"0xAA","topics":["0x01","0x02"]}]`},
+		{`{"address":["0xaa","0xCC"]}`, `[{"address":"0xAA","topics":["0x01","0x02"]},{"address":"0xcc","topics":[]}]`},
+		{`{"topics":["0x01"]}`, `[{"address":"0xAA","topics":["0x01","0x02"]},{"address":"0xbb","topics":["0x01"]}]`},
+		{`{"topics":[null,"0x02"]}`, `[{"address":"0xAA","topics":["0x01","0x02"]}]`},
+		{`{"topics":[["0x03","0x01"],["0x04"]]}`, `[]`},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.expected, string(filterLogs([]byte(blockLogs), gjson.Parse(tt.filter))), tt.filter)
+	}
+}
@@ -1,7 +1,6 @@
 package proxy
 
 import (
-	"github.com/kyokan/chaind/pkg"
 	"github.com/kyokan/chaind/pkg/log"
 	"github.com/kyokan/chaind/pkg/config"
 	"net/http"
@@ -12,10 +11,23 @@ import (
 	"github.com/satori/go.uuid"
 	"github.com/kyokan/chaind/internal/cache"
 	"github.com/kyokan/chaind/internal/backend"
+	"github.com/kyokan/chaind/internal/tracker"
+	"github.com/kyokan/chaind/internal/nonce"
+	"github.com/kyokan/chaind/internal/recorder"
+	"github.com/kyokan/chaind/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var logger = log.NewLog("proxy")
 
+// requestIDHeader carries the request's ID to clients and backends.
+const requestIDHeader = "X-Request-Id"
+
+// maxRequestIDLen is the longest request ID accepted from clients.
+const maxRequestIDLen = 128
+
 type Proxy struct {
 	sw         backend.Switcher
 	config     *config.Config
@@ -24,11 +36,11 @@ type Proxy struct {
 	errChan    chan error
 }
 
-func NewProxy(sw backend.Switcher, auditor audit.Auditor, store *cache.ETHStore, fHelper *cache.BlockHeightWatcher, config *config.Config) *Proxy {
+func NewProxy(sw backend.Switcher, auditor audit.Auditor, store *cache.ETHStore, fHelper *cache.BlockHeightWatcher, txTracker *tracker.Tracker, nonces *nonce.Manager, config *config.Config) *Proxy {
 	return &Proxy{
 		sw:         sw,
 		config:     config,
-		ethHandler: NewEthHandler(store, auditor, fHelper, config.ETHConfig.APIs),
+		ethHandler: NewEthHandler(sw, store, auditor, fHelper, txTracker, nonces, config),
 		quitChan:   make(chan bool),
 		errChan:    make(chan error),
 	}
@@ -69,22 +81,56 @@ func (p *Proxy) Stop() error {
 	return <-p.errChan
 }
 
+// SetRecorder sets the recorder that captures Ethereum traffic.
+func (p *Proxy) SetRecorder(rec *recorder.Recorder) {
+	p.ethHandler.SetRecorder(rec)
+}
+
+// SetEnabledAPIs replaces the list of Ethereum APIs clients are allowed to call.
+func (p *Proxy) SetEnabledAPIs(apis []string) {
+	p.ethHandler.SetEnabledAPIs(apis)
+}
+
 func (p *Proxy) handleETHRequest(res http.ResponseWriter, req *http.Request) {
-	ctx := context.WithValue(req.Context(), log.RequestIDKey, uuid.NewV4().String())
+	requestID := clientRequestID(req)
+	if requestID == "" {
+		requestID = uuid.NewV4().String()
+	}
+	res.Header().Set(requestIDHeader, requestID)
+	ctx := context.WithValue(req.Context(), log.RequestIDKey, requestID)
+	ctx, span := tracing.Tracer().Start(
+		tracing.Extract(ctx, req.Header),
+		"eth.request",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("chaind.request_id", requestID)),
+	)
+	defer span.End()
 	req = req.WithContext(ctx)
 	cLog := log.WithContext(logger, req.Context())
 	if req.Method != "POST" {
 		cLog.Info("rejected non-POST request to eth endpoint")
+		span.SetStatus(codes.Error, "method not allowed")
 		res.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
 
 	start := time.Now()
-	back, err := p.sw.BackendFor(pkg.EthBackend)
-	if err != nil {
-		res.WriteHeader(http.StatusServiceUnavailable)
-		return
-	}
-	p.ethHandler.Handle(res, req, back)
+	p.ethHandler.Handle(res, req)
 	cLog.Info("finished handling Ethereum JSON-RPC request", "elapsed", time.Since(start))
 }
+
+// clientRequestID returns the request ID sent by the client, or an empty
+// string if it is missing or invalid. Since it is written to logs and
+// forwarded to backends, only short IDs of printable ASCII are accepted.
+func clientRequestID(req *http.Request) string {
+	id := req.Header.Get(requestIDHeader)
+	if len(id) > maxRequestIDLen {
+		return ""
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return ""
+		}
+	}
+	return id
+}
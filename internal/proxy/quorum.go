@@ -0,0 +1,191 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"github.com/inconshreveable/log15"
+	"github.com/kyokan/chaind/pkg"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/kyokan/chaind/pkg/jsonrpc"
+	"github.com/kyokan/chaind/pkg/sets"
+)
+
+// QuorumHeader opts a single request into quorum mode, regardless of method.
+const QuorumHeader = "X-Chaind-Quorum"
+
+var errQuorumNotReached = errors.New("backends did not reach quorum")
+var errQuorumUnavailable = errors.New("not enough healthy backends for quorum")
+
+type quorumAnswer struct {
+	backend    string
+	body       []byte
+	normalized string
+	err        error
+}
+
+type Quorum struct {
+	methods   *sets.StringSet
+	size      int
+	threshold int
+}
+
+func NewQuorum(cfg *config.Quorum) *Quorum {
+	if cfg == nil {
+		return nil
+	}
+
+	return &Quorum{
+		methods:   sets.NewStringSet(cfg.Methods),
+		size:      cfg.Size,
+		threshold: cfg.Threshold,
+	}
+}
+
+// Applies returns true if the request should be answered by a quorum of
+// backends, either because its method is configured to or because the client
+// asked for it.
+func (q *Quorum) Applies(req *http.Request, rpcReq *jsonrpc.Request) bool {
+	if q == nil {
+		return false
+	}
+
+	header := req.Header.Get(QuorumHeader)
+	if header == "1" || strings.ToLower(header) == "true" {
+		return true
+	}
+
+	return q.methods.Contains(rpcReq.Method)
+}
+
+// quorum sends body to up to size healthy backends in the pool, and returns
+// the first response that threshold backends agree on.
+func (h *EthHandler) quorum(ctx context.Context, pool string, method string, body []byte, logger log15.Logger) ([]byte, error) {
+	backends := h.sw.HealthyBackendsForPool(pkg.EthBackend, pool)
+	if len(backends) > h.quorumCfg.size {
+		backends = backends[:h.quorumCfg.size]
+	}
+	if len(backends) < h.quorumCfg.threshold {
+		h.quorumResults.WithLabelValues(method, "unavailable").Inc()
+		return nil, errQuorumUnavailable
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan *upstreamResult, len(backends))
+	for i := range backends {
+		go h.postAsync(ctx, &backends[i], body, results)
+	}
+
+	var answers []*quorumAnswer
+	for range backends {
+		res := <-results
+		answer := &quorumAnswer{
+			backend: res.backend.Name,
+			body:    res.body,
+			err:     res.err,
+		}
+		if res.err == nil {
+			answer.normalized, answer.err = normalizeResponse(res.body)
+		}
+		answers = append(answers, answer)
+
+		if winner := tallyQuorum(answers, h.quorumCfg.threshold); winner != nil {
+			h.quorumResults.WithLabelValues(method, "agreed").Inc()
+			return winner.body, nil
+		}
+	}
+
+	h.quorumResults.WithLabelValues(method, "disagreed").Inc()
+	ctxArgs := []interface{}{"method", method, "threshold", h.quorumCfg.threshold}
+	for _, answer := range answers {
+		if answer.err != nil {
+			ctxArgs = append(ctxArgs, answer.backend, fmt.Sprintf("error: %s", answer.err))
+		} else {
+			ctxArgs = append(ctxArgs, answer.backend, string(answer.body))
+		}
+	}
+	logger.Warn("backends disagreed on response", ctxArgs...)
+	return nil, errQuorumNotReached
+}
+
+// tallyQuorum returns the first answer that at least threshold backends
+// agree on, or nil if there isn't one yet.
+func tallyQuorum(answers []*quorumAnswer, threshold int) *quorumAnswer {
+	counts := make(map[string]int)
+	for _, answer := range answers {
+		if answer.err != nil {
+			continue
+		}
+
+		counts[answer.normalized]++
+		if counts[answer.normalized] >= threshold {
+			return answer
+		}
+	}
+
+	return nil
+}
+
+// normalizeResponse strips the parts of a JSON-RPC response that can
+// legitimately differ between backends - the envelope, key order, whitespace
+// and the case of hex strings - so that responses can be compared byte for byte.
+func normalizeResponse(body []byte) (string, error) {
+	var res struct {
+		Result json.RawMessage `json:"result"`
+		Error  json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return "", err
+	}
+
+	var payload interface{}
+	data := res.Result
+	prefix := "result:"
+	if len(res.Error) > 0 && string(res.Error) != "null" {
+		data = res.Error
+		prefix = "error:"
+	}
+	if len(data) == 0 {
+		data = []byte("null")
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&payload); err != nil {
+		return "", err
+	}
+
+	out, err := json.Marshal(lowerHex(payload))
+	if err != nil {
+		return "", err
+	}
+
+	return prefix + string(out), nil
+}
+
+func lowerHex(val interface{}) interface{} {
+	switch v := val.(type) {
+	case string:
+		if strings.HasPrefix(v, "0x") || strings.HasPrefix(v, "0X") {
+			return strings.ToLower(v)
+		}
+		return v
+	case []interface{}:
+		for i := range v {
+			v[i] = lowerHex(v[i])
+		}
+		return v
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = lowerHex(v[k])
+		}
+		return v
+	default:
+		return v
+	}
+}
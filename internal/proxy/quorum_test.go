@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"github.com/kyokan/chaind/internal/backend"
+	"github.com/kyokan/chaind/internal/backend/backendtest"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/kyokan/chaind/pkg/jsonrpc"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestNormalizeResponse(t *testing.T) {
+	a, err := normalizeResponse([]byte(`{"jsonrpc":"2.0","id":1,"result":{"b":"0xABC","a":1}}`))
+	require.NoError(t, err)
+	b, err := normalizeResponse([]byte(`{"id":2, "result": {"a": 1, "b": "0xabc"}, "jsonrpc":"2.0"}`))
+	require.NoError(t, err)
+	require.Equal(t, a, b)
+
+	c, err := normalizeResponse([]byte(`{"jsonrpc":"2.0","id":1,"result":{"a":2,"b":"0xabc"}}`))
+	require.NoError(t, err)
+	require.NotEqual(t, a, c)
+
+	nullRes, err := normalizeResponse([]byte(`{"jsonrpc":"2.0","id":1,"result":null}`))
+	require.NoError(t, err)
+	errRes, err := normalizeResponse([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"missing trie node"}}`))
+	require.NoError(t, err)
+	require.NotEqual(t, nullRes, errRes)
+
+	_, err = normalizeResponse([]byte("not json"))
+	require.Error(t, err)
+}
+
+func TestTallyQuorum(t *testing.T) {
+	answers := []*quorumAnswer{
+		{backend: "a", normalized: "result:1"},
+		{backend: "b", err: errors.New("timeout")},
+		{backend: "c", normalized: "result:2"},
+	}
+	require.Nil(t, tallyQuorum(answers, 2))
+
+	answers = append(answers, &quorumAnswer{backend: "d", normalized: "result:2"})
+	winner := tallyQuorum(answers, 2)
+	require.NotNil(t, winner)
+	require.Equal(t, "result:2", winner.normalized)
+}
+
+func TestQuorum_Applies(t *testing.T) {
+	var disabled *Quorum
+	req, err := http.NewRequest("POST", "/eth", nil)
+	require.NoError(t, err)
+	require.False(t, disabled.Applies(req, &jsonrpc.Request{Method: "eth_getBalance"}))
+
+	q := NewQuorum(&config.Quorum{
+		Methods:   []string{"eth_getBalance"},
+		Size:      3,
+		Threshold: 2,
+	})
+	require.True(t, q.Applies(req, &jsonrpc.Request{Method: "eth_getBalance"}))
+	require.False(t, q.Applies(req, &jsonrpc.Request{Method: "eth_call"}))
+
+	req.Header.Set(QuorumHeader, "true")
+	require.True(t, q.Applies(req, &jsonrpc.Request{Method: "eth_call"}))
+}
+
+// newQuorumHandler returns a handler over one backend per response, and a
+// function that closes them.
+func newQuorumHandler(cfg *config.Quorum, responses ...string) (*EthHandler, func()) {
+	var backends []config.Backend
+	var srvs []*testBackend
+	for i, res := range responses {
+		srv := newTestBackend(0, 200, res)
+		srvs = append(srvs, srv)
+		backends = append(backends, srv.backend(fmt.Sprintf("test-%d", i)))
+	}
+
+	h := newTestHandler(backendtest.NewSwitch(backends...))
+	h.quorumCfg = NewQuorum(cfg)
+	return h, func() {
+		for _, srv := range srvs {
+			srv.srv.Close()
+		}
+	}
+}
+
+func TestQuorum_Agreed(t *testing.T) {
+	h, done := newQuorumHandler(
+		&config.Quorum{Size: 3, Threshold: 2},
+		`{"jsonrpc":"2.0","id":1,"result":"0xABC"}`,
+		`{"jsonrpc":"2.0","id":1,"result":"0x123"}`,
+		`{"id":1, "jsonrpc":"2.0", "result":"0xabc"}`,
+	)
+	defer done()
+
+	body, err := h.quorum(context.Background(), backend.DefaultPool, "eth_getBalance", []byte(`{}`), h.logger)
+	require.NoError(t, err)
+	require.Equal(t, "0xabc", strings.ToLower(gjson.GetBytes(body, "result").String()))
+	require.Equal(t, float64(1), counterValue(t, h.quorumResults.WithLabelValues("eth_getBalance", "agreed")))
+}
+
+func TestQuorum_Disagreed(t *testing.T) {
+	h, done := newQuorumHandler(
+		&config.Quorum{Methods: []string{"eth_getBalance"}, Size: 3, Threshold: 2},
+		`{"jsonrpc":"2.0","id":1,"result":"0x1"}`,
+		`{"jsonrpc":"2.0","id":1,"result":"0x2"}`,
+		`{"jsonrpc":"2.0","id":1,"error":{"code":-32000,"message":"missing trie node"}}`,
+	)
+	defer done()
+
+	_, err := h.quorum(context.Background(), backend.DefaultPool, "eth_getBalance", []byte(`{}`), h.logger)
+	require.Equal(t, errQuorumNotReached, err)
+	require.Equal(t, float64(1), counterValue(t, h.quorumResults.WithLabelValues("eth_getBalance", "disagreed")))
+
+	// clients get an error rather than any one backend's answer
+	res := serve(t, h, `{"jsonrpc":"2.0","id":1,"method":"eth_getBalance","params":["0x01","latest"]}`)
+	require.Equal(t, int64(-32000), gjson.Get(res, "error.code").Int())
+	require.Equal(t, errQuorumNotReached.Error(), gjson.Get(res, "error.message").String())
+}
+
+func TestQuorum_Unavailable(t *testing.T) {
+	h, done := newQuorumHandler(
+		&config.Quorum{Size: 2, Threshold: 2},
+		`{"jsonrpc":"2.0","id":1,"result":"0x1"}`,
+	)
+	defer done()
+
+	_, err := h.quorum(context.Background(), backend.DefaultPool, "eth_getBalance", []byte(`{}`), h.logger)
+	require.Equal(t, errQuorumUnavailable, err)
+	require.Equal(t, float64(1), counterValue(t, h.quorumResults.WithLabelValues("eth_getBalance", "unavailable")))
+}
+
+func TestQuorum_RoutedPool(t *testing.T) {
+	full := newTestBackend(0, 200, `{"jsonrpc":"2.0","id":1,"result":"0x1"}`)
+	defer full.srv.Close()
+	archive1 := newTestBackend(0, 200, `{"jsonrpc":"2.0","id":1,"result":"0x2"}`)
+	defer archive1.srv.Close()
+	archive2 := newTestBackend(10*time.Millisecond, 200, `{"jsonrpc":"2.0","id":1,"result":"0x2"}`)
+	defer archive2.srv.Close()
+
+	archiveBackends := []config.Backend{archive1.backend("archive-1"), archive2.backend("archive-2")}
+	for i := range archiveBackends {
+		archiveBackends[i].Tags = []string{"archive"}
+	}
+	h := newTestHandler(backendtest.NewSwitch(append(archiveBackends, full.backend("full"))...))
+	h.quorumCfg = NewQuorum(&config.Quorum{Size: 2, Threshold: 2})
+	h.router = NewRouter([]config.Route{{Pool: "archive", Methods: []string{"debug_*"}}}, nil)
+
+	// only the backends in the request's pool are asked
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"debug_traceTransaction","params":["0x01"]}`))
+	req.Header.Set(QuorumHeader, "true")
+	res := httptest.NewRecorder()
+	h.Handle(res, req)
+	require.Equal(t, "0x2", gjson.Get(res.Body.String(), "result").String())
+	require.Equal(t, int32(0), atomic.LoadInt32(&full.hits))
+}
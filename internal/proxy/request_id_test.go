@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/kyokan/chaind/pkg/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientRequestID(t *testing.T) {
+	tests := []struct {
+		header string
+		id     string
+	}{
+		{"", ""},
+		{"support-1234", "support-1234"},
+		{"0f8fad5b-d9cb-469f-a165-70867728950e", "0f8fad5b-d9cb-469f-a165-70867728950e"},
+		{"has space", ""},
+		{"line\nbreak", ""},
+		{"café", ""},
+		{strings.Repeat("a", maxRequestIDLen), strings.Repeat("a", maxRequestIDLen)},
+		{strings.Repeat("a", maxRequestIDLen+1), ""},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/", nil)
+		req.Header.Set(requestIDHeader, tt.header)
+		require.Equal(t, tt.id, clientRequestID(req), tt.header)
+	}
+}
+
+func TestHandleETHRequestID(t *testing.T) {
+	p := &Proxy{}
+
+	// generated IDs are returned to the client, even if the request fails
+	res := httptest.NewRecorder()
+	p.handleETHRequest(res, httptest.NewRequest("GET", "/", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, res.Code)
+	require.Len(t, res.Header().Get(requestIDHeader), 36)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(requestIDHeader, "support-1234")
+	res = httptest.NewRecorder()
+	p.handleETHRequest(res, req)
+	require.Equal(t, "support-1234", res.Header().Get(requestIDHeader))
+}
+
+func TestPostForwardsRequestID(t *testing.T) {
+	var requestID string
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		requestID = req.Header.Get(requestIDHeader)
+		res.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer srv.Close()
+
+	h := newTestHandler(nil)
+	back := &config.Backend{Name: "test", URL: srv.URL}
+
+	ctx := context.WithValue(context.Background(), log.RequestIDKey, "support-1234")
+	_, err := h.post(ctx, back, []byte(`{}`))
+	require.NoError(t, err)
+	require.Equal(t, "support-1234", requestID)
+
+	// requests chaind makes on its own don't have an ID
+	_, err = h.post(context.Background(), back, []byte(`{}`))
+	require.NoError(t, err)
+	require.Equal(t, "", requestID)
+}
@@ -0,0 +1,116 @@
+package proxy
+
+import (
+	"path"
+	"github.com/kyokan/chaind/internal/backend"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/kyokan/chaind/pkg/jsonrpc"
+	"github.com/tidwall/gjson"
+)
+
+// blockParamPaths maps methods that read historical state to the gjson path
+// of their block parameter.
+var blockParamPaths = map[string]string{
+	"eth_getBalance":                          "1",
+	"eth_getCode":                             "1",
+	"eth_getTransactionCount":                 "1",
+	"eth_getStorageAt":                        "2",
+	"eth_call":                                "1",
+	"eth_estimateGas":                         "1",
+	"eth_getProof":                            "2",
+	"eth_getBlockByNumber":                    "0",
+	"eth_getBlockTransactionCountByNumber":    "0",
+	"eth_getTransactionByBlockNumberAndIndex": "0",
+	"eth_getUncleByBlockNumberAndIndex":       "0",
+	"eth_getUncleCountByBlockNumber":          "0",
+	"eth_getLogs":                             "0.fromBlock",
+	"debug_traceBlockByNumber":                "0",
+	"debug_traceCall":                         "1",
+	"trace_block":                             "0",
+	"trace_call":                              "2",
+	"trace_replayBlockTransactions":           "0",
+}
+
+type heightSource interface {
+	BlockHeight() uint64
+}
+
+type Router struct {
+	routes  []config.Route
+	heights heightSource
+}
+
+func NewRouter(routes []config.Route, heights heightSource) *Router {
+	return &Router{
+		routes:  routes,
+		heights: heights,
+	}
+}
+
+// PoolFor returns the backend pool that should serve the request. Routes are
+// evaluated in order, and the first match wins. Requests that don't match any
+// route are served by the default pool.
+func (r *Router) PoolFor(rpcReq *jsonrpc.Request) string {
+	for _, route := range r.routes {
+		if r.matches(&route, rpcReq) {
+			return route.Pool
+		}
+	}
+
+	return backend.DefaultPool
+}
+
+func (r *Router) matches(route *config.Route, rpcReq *jsonrpc.Request) bool {
+	if len(route.Methods) > 0 && !methodMatches(route.Methods, rpcReq.Method) {
+		return false
+	}
+
+	if route.OlderThan > 0 {
+		return r.isHistorical(rpcReq, route.OlderThan)
+	}
+
+	return true
+}
+
+func (r *Router) isHistorical(rpcReq *jsonrpc.Request, olderThan uint64) bool {
+	paramPath, ok := blockParamPaths[rpcReq.Method]
+	if !ok {
+		return false
+	}
+
+	param := gjson.GetBytes(rpcReq.Params, paramPath)
+	// EIP-1898 allows block parameters to be objects
+	if param.IsObject() {
+		param = param.Get("blockNumber")
+	}
+
+	blockStr := param.String()
+	switch blockStr {
+	case "earliest":
+		return true
+	case "", "latest", "pending":
+		return false
+	}
+
+	blockNum, err := jsonrpc.Hex2Uint64(blockStr)
+	if err != nil {
+		return false
+	}
+
+	height := r.heights.BlockHeight()
+	if height < olderThan {
+		return false
+	}
+
+	return blockNum < height-olderThan
+}
+
+func methodMatches(patterns []string, method string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, method); ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"testing"
+	"github.com/kyokan/chaind/internal/backend"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/kyokan/chaind/pkg/jsonrpc"
+	"github.com/stretchr/testify/require"
+)
+
+type staticHeight uint64
+
+func (s staticHeight) BlockHeight() uint64 {
+	return uint64(s)
+}
+
+func TestRouter_PoolFor(t *testing.T) {
+	router := NewRouter([]config.Route{
+		{
+			Pool:    "trace",
+			Methods: []string{"debug_*", "trace_*"},
+		},
+		{
+			Pool:      "archive",
+			OlderThan: 128,
+		},
+	}, staticHeight(1000))
+
+	tests := []struct {
+		method string
+		params string
+		pool   string
+	}{
+		{"debug_traceTransaction", `["0xabc"]`, "trace"},
+		{"trace_block", `["0x1"]`, "trace"},
+		{"eth_getBalance", `["0xabc", "latest"]`, backend.DefaultPool},
+		{"eth_getBalance", `["0xabc", "0x3e8"]`, backend.DefaultPool},
+		{"eth_getBalance", `["0xabc", "0x1"]`, "archive"},
+		{"eth_getBalance", `["0xabc", "earliest"]`, "archive"},
+		{"eth_getStorageAt", `["0xabc", "0x0", "0x1"]`, "archive"},
+		{"eth_call", `[{}, {"blockNumber": "0x1"}]`, "archive"},
+		{"eth_call", `[{}, {"blockHash": "0xdef"}]`, backend.DefaultPool},
+		{"eth_getLogs", `[{"fromBlock": "0x1", "toBlock": "latest"}]`, "archive"},
+		{"eth_blockNumber", `[]`, backend.DefaultPool},
+	}
+
+	for _, tt := range tests {
+		pool := router.PoolFor(&jsonrpc.Request{
+			Method: tt.method,
+			Params: []byte(tt.params),
+		})
+		require.Equal(t, tt.pool, pool, "%s %s", tt.method, tt.params)
+	}
+}
+
+func TestRouter_PoolFor_LowHeight(t *testing.T) {
+	router := NewRouter([]config.Route{
+		{
+			Pool:      "archive",
+			OlderThan: 128,
+		},
+	}, staticHeight(10))
+
+	pool := router.PoolFor(&jsonrpc.Request{
+		Method: "eth_getBalance",
+		Params: []byte(`["0xabc", "0x1"]`),
+	})
+	require.Equal(t, backend.DefaultPool, pool)
+}
@@ -0,0 +1,211 @@
+package proxy
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"sync"
+	"time"
+	"github.com/inconshreveable/log15"
+	"github.com/kyokan/chaind/pkg"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/kyokan/chaind/pkg/jsonrpc"
+	"github.com/tidwall/gjson"
+)
+
+const DefaultStickyWait = time.Second
+const DefaultStickyRetention = 10 * time.Minute
+
+// DefaultStickyMaxClients is how many clients' high-water marks are kept by
+// default. Clients are identified by a header they control, so the least
+// recently seen are forgotten beyond this.
+const DefaultStickyMaxClients = 10000
+
+// stickyPollInterval is how often backend heights are re-checked while
+// waiting for a backend to catch up.
+const stickyPollInterval = 50 * time.Millisecond
+
+type backendHeights interface {
+	BackendHeight(name string) (uint64, bool)
+}
+
+type highWaterMark struct {
+	client   string
+	height   uint64
+	lastSeen time.Time
+}
+
+// Stickiness remembers the highest block each client has been shown, so that
+// later requests from the client aren't served by a backend that hasn't seen
+// that block yet. Clients are identified by a configurable header. Marks are
+// kept in least recently seen order, so that idle clients can be pruned and
+// the least recently seen client evicted once maxClients are tracked.
+type Stickiness struct {
+	header     string
+	wait       time.Duration
+	retention  time.Duration
+	maxClients int
+	heights    backendHeights
+	marks      map[string]*list.Element
+	lru        *list.List
+	mtx        sync.Mutex
+}
+
+func NewStickiness(cfg *config.Stickiness, heights backendHeights) *Stickiness {
+	if cfg == nil {
+		return nil
+	}
+
+	s := &Stickiness{
+		header:     cfg.Header,
+		wait:       DefaultStickyWait,
+		retention:  DefaultStickyRetention,
+		maxClients: DefaultStickyMaxClients,
+		heights:    heights,
+		marks:      make(map[string]*list.Element),
+		lru:        list.New(),
+	}
+	if cfg.WaitMS > 0 {
+		s.wait = time.Duration(cfg.WaitMS) * time.Millisecond
+	}
+	if cfg.RetentionSecs > 0 {
+		s.retention = time.Duration(cfg.RetentionSecs) * time.Second
+	}
+	if cfg.MaxClients > 0 {
+		s.maxClients = cfg.MaxClients
+	}
+	return s
+}
+
+// ClientFor returns the client making the request, or an empty string if the
+// request doesn't identify its client.
+func (s *Stickiness) ClientFor(req *http.Request) string {
+	if s == nil {
+		return ""
+	}
+
+	return req.Header.Get(s.header)
+}
+
+// HighWaterMark returns the highest block the client has been shown.
+func (s *Stickiness) HighWaterMark(client string) uint64 {
+	if s == nil || client == "" {
+		return 0
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	el := s.marks[client]
+	if el == nil {
+		return 0
+	}
+	return el.Value.(*highWaterMark).height
+}
+
+// Observe raises the client's high-water mark to the highest block in the
+// response, if any.
+func (s *Stickiness) Observe(client string, method string, result []byte) {
+	if s == nil || client == "" {
+		return
+	}
+	height := responseHeight(method, gjson.ParseBytes(result))
+	if height == 0 {
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	now := time.Now()
+	el := s.marks[client]
+	if el == nil {
+		el = s.lru.PushFront(&highWaterMark{client: client})
+		s.marks[client] = el
+	} else {
+		s.lru.MoveToFront(el)
+	}
+	mark := el.Value.(*highWaterMark)
+	if height > mark.height {
+		mark.height = height
+	}
+	mark.lastSeen = now
+
+	for back := s.lru.Back(); back != nil && back != el; back = s.lru.Back() {
+		oldest := back.Value.(*highWaterMark)
+		if len(s.marks) <= s.maxClients && now.Sub(oldest.lastSeen) <= s.retention {
+			break
+		}
+		s.lru.Remove(back)
+		delete(s.marks, oldest.client)
+	}
+}
+
+func (s *Stickiness) isCaughtUp(back *config.Backend, mark uint64) bool {
+	height, ok := s.heights.BackendHeight(back.Name)
+	return ok && height >= mark
+}
+
+// responseHeight returns the highest block number in a response, or zero if
+// it doesn't contain one.
+func responseHeight(method string, result gjson.Result) uint64 {
+	var height uint64
+	check := func(val gjson.Result) {
+		if val.Type != gjson.String {
+			return
+		}
+		if number, err := jsonrpc.Hex2Uint64(val.String()); err == nil && number > height {
+			height = number
+		}
+	}
+
+	switch {
+	case method == "eth_blockNumber":
+		check(result)
+	case result.IsArray():
+		for _, item := range result.Array() {
+			check(item.Get("blockNumber"))
+		}
+	case result.IsObject():
+		check(result.Get("number"))
+		check(result.Get("blockNumber"))
+	}
+	return height
+}
+
+// stickyBackend returns a backend in the pool that has seen every block the
+// client has been shown, preferring back. If none has, it waits for one to
+// catch up before giving up and returning back.
+func (h *EthHandler) stickyBackend(ctx context.Context, client string, pool string, back *config.Backend, logger log15.Logger) *config.Backend {
+	mark := h.sticky.HighWaterMark(client)
+	if mark == 0 || h.sticky.isCaughtUp(back, mark) {
+		return back
+	}
+
+	deadline := time.After(h.sticky.wait)
+	ticker := time.NewTicker(stickyPollInterval)
+	defer ticker.Stop()
+	waited := false
+	for {
+		for _, candidate := range h.sw.HealthyBackendsForPool(pkg.EthBackend, pool) {
+			if h.sticky.isCaughtUp(&candidate, mark) {
+				outcome := "rerouted"
+				if waited {
+					outcome = "waited"
+				}
+				h.stickyResults.WithLabelValues(outcome).Inc()
+				logger.Debug("routed request to backend at client's high-water mark", "backend", candidate.Name, "mark", mark)
+				return &candidate
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return back
+		case <-deadline:
+			h.stickyResults.WithLabelValues("behind").Inc()
+			logger.Warn("no backend caught up to client's high-water mark", "backend", back.Name, "mark", mark)
+			return back
+		case <-ticker.C:
+			waited = true
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"github.com/kyokan/chaind/internal/backend"
+	"github.com/kyokan/chaind/internal/backend/backendtest"
+	"github.com/kyokan/chaind/pkg"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+type staticHeights struct {
+	heights map[string]uint64
+	mtx     sync.Mutex
+}
+
+func (s *staticHeights) BackendHeight(name string) (uint64, bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	height, ok := s.heights[name]
+	return height, ok
+}
+
+func (s *staticHeights) set(name string, height uint64) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.heights[name] = height
+}
+
+func TestResponseHeight(t *testing.T) {
+	tests := []struct {
+		method   string
+		result   string
+		expected uint64
+	}{
+		{"eth_blockNumber", `"0x10"`, 16},
+		{"eth_getBlockByNumber", `{"number":"0x11","hash":"0xab"}`, 17},
+		{"eth_getBlockByNumber", `null`, 0},
+		{"eth_getTransactionReceipt", `{"blockNumber":"0x12"}`, 18},
+		{"eth_getLogs", `[{"blockNumber":"0x13"},{"blockNumber":"0x15"},{"blockNumber":"0x14"}]`, 21},
+		{"eth_getBalance", `"0x100"`, 0},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, tt.expected, responseHeight(tt.method, gjson.Parse(tt.result)), tt.result)
+	}
+}
+
+func TestStickiness_Observe(t *testing.T) {
+	var nilSticky *Stickiness
+	nilSticky.Observe("client", "eth_blockNumber", []byte(`"0x10"`))
+	require.Equal(t, uint64(0), nilSticky.HighWaterMark("client"))
+
+	s := NewStickiness(&config.Stickiness{Header: "X-Api-Key", RetentionSecs: 1}, &staticHeights{})
+	s.Observe("client", "eth_blockNumber", []byte(`"0x10"`))
+	s.Observe("client", "eth_getBlockByNumber", []byte(`{"number":"0x8"}`))
+	require.Equal(t, uint64(16), s.HighWaterMark("client"))
+	require.Equal(t, uint64(0), s.HighWaterMark("other"))
+
+	s.mtx.Lock()
+	s.marks["client"].Value.(*highWaterMark).lastSeen = time.Now().Add(-time.Minute)
+	s.mtx.Unlock()
+	s.Observe("other", "eth_blockNumber", []byte(`"0x1"`))
+	require.Equal(t, uint64(0), s.HighWaterMark("client"))
+	require.Equal(t, uint64(1), s.HighWaterMark("other"))
+}
+
+func TestStickiness_MaxClients(t *testing.T) {
+	s := NewStickiness(&config.Stickiness{Header: "X-Api-Key", MaxClients: 2}, &staticHeights{})
+	s.Observe("client-1", "eth_blockNumber", []byte(`"0x1"`))
+	s.Observe("client-2", "eth_blockNumber", []byte(`"0x2"`))
+	// seeing client-1 again makes client-2 the least recently seen
+	s.Observe("client-1", "eth_blockNumber", []byte(`"0x3"`))
+	s.Observe("client-3", "eth_blockNumber", []byte(`"0x4"`))
+
+	require.Equal(t, uint64(3), s.HighWaterMark("client-1"))
+	require.Equal(t, uint64(0), s.HighWaterMark("client-2"))
+	require.Equal(t, uint64(4), s.HighWaterMark("client-3"))
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	require.Len(t, s.marks, 2)
+	require.Equal(t, 2, s.lru.Len())
+}
+
+func TestStickyBackend(t *testing.T) {
+	heights := &staticHeights{heights: map[string]uint64{"test-1": 10, "test-2": 12}}
+	backends := []config.Backend{{Name: "test-1", Type: pkg.EthBackend}, {Name: "test-2", Type: pkg.EthBackend}}
+	sw := backendtest.NewSwitch(backends...)
+	h := newTestHandler(sw)
+	h.sticky = NewStickiness(&config.Stickiness{Header: "X-Api-Key", WaitMS: 200}, heights)
+	logger := h.logger
+	ctx := context.Background()
+
+	// clients without a mark use the selected backend
+	require.Equal(t, "test-1", h.stickyBackend(ctx, "client", backend.DefaultPool, &backends[0], logger).Name)
+
+	h.sticky.Observe("client", "eth_blockNumber", []byte(`"0xc"`))
+	require.Equal(t, "test-2", h.stickyBackend(ctx, "client", backend.DefaultPool, &backends[0], logger).Name)
+
+	// wait for a backend to catch up
+	h.sticky.Observe("client", "eth_blockNumber", []byte(`"0xd"`))
+	go func() {
+		time.Sleep(60 * time.Millisecond)
+		heights.set("test-1", 13)
+	}()
+	require.Equal(t, "test-1", h.stickyBackend(ctx, "client", backend.DefaultPool, &backends[0], logger).Name)
+
+	// give up once the wait is over
+	h.sticky.Observe("client", "eth_blockNumber", []byte(`"0x20"`))
+	start := time.Now()
+	require.Equal(t, "test-1", h.stickyBackend(ctx, "client", backend.DefaultPool, &backends[0], logger).Name)
+	require.True(t, time.Since(start) >= 200*time.Millisecond)
+}
+
+func TestStickyBackend_FanOut(t *testing.T) {
+	h, done := newQuorumHandler(
+		&config.Quorum{Methods: []string{"eth_getBalance"}, Size: 2, Threshold: 2},
+		`{"jsonrpc":"2.0","id":1,"result":"0x1"}`,
+		`{"jsonrpc":"2.0","id":1,"result":"0x1"}`,
+	)
+	defer done()
+	h.sticky = NewStickiness(&config.Stickiness{Header: "X-Api-Key", WaitMS: 500}, &staticHeights{})
+	h.sticky.Observe("client", "eth_blockNumber", []byte(`"0x20"`))
+
+	// quorum reads and broadcasts don't wait for a backend to catch up,
+	// since they are sent to every backend in the pool
+	for _, method := range []string{"eth_getBalance", sendRawTxMethod} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"`+method+`","params":["0x01"]}`))
+		req.Header.Set("X-Api-Key", "client")
+		start := time.Now()
+		h.Handle(httptest.NewRecorder(), req)
+		require.True(t, time.Since(start) < 500*time.Millisecond, method)
+	}
+	require.Equal(t, 0.0, counterValue(t, h.stickyResults.WithLabelValues("behind")))
+}
@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"github.com/kyokan/chaind/internal/tracing"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestPostPropagatesTrace(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	defer otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+
+	var traceparent string
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		traceparent = req.Header.Get("traceparent")
+		res.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`))
+	}))
+	defer srv.Close()
+
+	h := newTestHandler(nil)
+	ctx, parent := tracing.Tracer().Start(context.Background(), "eth.request")
+	_, err := h.post(ctx, &config.Backend{Name: "test", URL: srv.URL}, []byte(`{}`))
+	require.NoError(t, err)
+	parent.End()
+
+	ended := spans.Ended()
+	require.Len(t, ended, 2)
+	upstream := ended[0]
+	require.Equal(t, "eth.upstream", upstream.Name())
+	require.Equal(t, parent.SpanContext().SpanID(), upstream.Parent().SpanID())
+	require.Contains(t, upstream.Attributes(), attribute.String("chaind.backend", "test"))
+	require.Contains(t, upstream.Attributes(), attribute.Int("http.status_code", 200))
+
+	// the backend sees the upstream span as the parent of its own spans
+	sc := upstream.SpanContext()
+	require.Equal(t, "00-"+sc.TraceID().String()+"-"+sc.SpanID().String()+"-01", traceparent)
+}
@@ -0,0 +1,73 @@
+package recorder
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"time"
+)
+
+// maxLineSize is the largest exchange a Reader accepts.
+const maxLineSize = 64 * 1024 * 1024
+
+// Reader reads the exchanges in a capture file in the order they were
+// recorded.
+type Reader struct {
+	file    *os.File
+	gz      *gzip.Reader
+	scanner *bufio.Scanner
+}
+
+func OpenReader(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(gz)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	return &Reader{
+		file:    f,
+		gz:      gz,
+		scanner: scanner,
+	}, nil
+}
+
+// Next returns the next exchange, or io.EOF once there are none left. A
+// capture cut short, for example because chaind crashed, ends at the last
+// complete exchange.
+func (r *Reader) Next() (*Exchange, error) {
+	if !r.scanner.Scan() {
+		err := r.scanner.Err()
+		if err == nil || err == io.ErrUnexpectedEOF {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+
+	var ex exchangeJSON
+	if err := json.Unmarshal(r.scanner.Bytes(), &ex); err != nil {
+		if !r.scanner.Scan() && r.scanner.Err() == io.ErrUnexpectedEOF {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	return &Exchange{
+		Time:     time.Unix(0, ex.Time),
+		Duration: time.Duration(ex.Duration),
+		Request:  []byte(ex.Request),
+		Response: []byte(ex.Response),
+	}, nil
+}
+
+func (r *Reader) Close() error {
+	r.gz.Close()
+	return r.file.Close()
+}
@@ -0,0 +1,226 @@
+package recorder
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"math/rand"
+	"os"
+	"time"
+	"github.com/inconshreveable/log15"
+	"github.com/kyokan/chaind/internal/audit"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/kyokan/chaind/pkg/log"
+	"github.com/kyokan/chaind/pkg/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// DefaultBufferSize is the default number of exchanges buffered in memory
+// before new exchanges are dropped.
+const DefaultBufferSize = 10000
+
+// flushInterval is how often buffered exchanges are flushed to disk.
+const flushInterval = time.Second
+
+var droppedExchanges = promauto.NewCounter(prometheus.CounterOpts{
+	Name:      "eth_recorder_dropped_exchanges",
+	Subsystem: metrics.Subsystem,
+})
+
+// Exchange is an HTTP request body sent to the proxy, along with the
+// response body it got back and how long it took.
+type Exchange struct {
+	Time     time.Time
+	Duration time.Duration
+	Request  []byte
+	Response []byte
+}
+
+// exchangeJSON is how an exchange is stored in a capture. Times are Unix
+// nanoseconds.
+type exchangeJSON struct {
+	Time     int64  `json:"t"`
+	Duration int64  `json:"d"`
+	Request  string `json:"q"`
+	Response string `json:"r"`
+}
+
+// Recorder appends a sample of the proxy's traffic to a capture file as
+// gzipped JSON lines. Exchanges are buffered and written in the background,
+// and dropped if the buffer is full. Request params are redacted the same
+// way as in the audit log. A nil Recorder records nothing.
+type Recorder struct {
+	path       string
+	sampleRate float64
+	redactor   *audit.Redactor
+	exchanges  chan *Exchange
+	quitChan   chan bool
+	doneChan   chan bool
+	logger     log15.Logger
+}
+
+// NewRecorder returns a Recorder that redacts requests with redactor, or
+// with the default redactions if it is nil.
+func NewRecorder(cfg *config.RecorderConfig, redactor *audit.Redactor) *Recorder {
+	if cfg == nil {
+		return nil
+	}
+	if redactor == nil {
+		redactor = audit.NewRedactor(nil)
+	}
+
+	sampleRate := cfg.SampleRate
+	if sampleRate == 0 {
+		sampleRate = 1
+	}
+	bufferSize := cfg.BufferSize
+	if bufferSize == 0 {
+		bufferSize = DefaultBufferSize
+	}
+
+	return &Recorder{
+		path:       cfg.Path,
+		sampleRate: sampleRate,
+		redactor:   redactor,
+		exchanges:  make(chan *Exchange, bufferSize),
+		quitChan:   make(chan bool),
+		doneChan:   make(chan bool),
+		logger:     log.NewLog("recorder"),
+	}
+}
+
+// Start opens the capture file, appending to it if it already exists. New
+// files are only readable by their owner, since captures include client
+// requests.
+func (r *Recorder) Start() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	go r.write(f)
+	r.logger.Info("started", "path", r.path, "sample_rate", r.sampleRate)
+	return nil
+}
+
+// Stop writes any buffered exchanges and closes the capture file.
+func (r *Recorder) Stop() error {
+	r.quitChan <- true
+	<-r.doneChan
+	return nil
+}
+
+// Record samples an exchange for writing to the capture file.
+func (r *Recorder) Record(ex *Exchange) {
+	if r == nil {
+		return
+	}
+	if r.sampleRate < 1 && rand.Float64() >= r.sampleRate {
+		return
+	}
+
+	select {
+	case r.exchanges <- ex:
+	default:
+		droppedExchanges.Inc()
+	}
+}
+
+func (r *Recorder) write(f *os.File) {
+	// every run appends its own gzip member, which readers decompress as
+	// one stream
+	buf := bufio.NewWriter(f)
+	gz := gzip.NewWriter(buf)
+	enc := json.NewEncoder(gz)
+	flush := func() {
+		if err := gz.Flush(); err != nil {
+			r.logger.Error("failed to flush capture", "err", err)
+		}
+		if err := buf.Flush(); err != nil {
+			r.logger.Error("failed to flush capture", "err", err)
+		}
+	}
+	encode := func(ex *Exchange) {
+		err := enc.Encode(&exchangeJSON{
+			Time:     ex.Time.UnixNano(),
+			Duration: int64(ex.Duration),
+			Request:  string(r.redact(ex.Request)),
+			Response: string(ex.Response),
+		})
+		if err != nil {
+			r.logger.Error("failed to write exchange", "err", err)
+		}
+	}
+
+	tick := time.NewTicker(flushInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case ex := <-r.exchanges:
+			encode(ex)
+		case <-tick.C:
+			flush()
+		case <-r.quitChan:
+			for len(r.exchanges) > 0 {
+				encode(<-r.exchanges)
+			}
+			if err := gz.Close(); err != nil {
+				r.logger.Error("failed to close capture", "err", err)
+			}
+			flush()
+			if err := f.Close(); err != nil {
+				r.logger.Error("failed to close capture", "err", err)
+			}
+			r.doneChan <- true
+			return
+		}
+	}
+}
+
+// redact applies the redactor to the params of the request body, or of every
+// request in a batch. Bodies that can't be parsed are returned as they are.
+func (r *Recorder) redact(body []byte) []byte {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 || trimmed[0] != '[' {
+		return r.redactRequest(body)
+	}
+
+	var reqs []json.RawMessage
+	if err := json.Unmarshal(trimmed, &reqs); err != nil {
+		return body
+	}
+	for i, req := range reqs {
+		reqs[i] = r.redactRequest(req)
+	}
+	out, err := json.Marshal(reqs)
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+func (r *Recorder) redactRequest(body []byte) []byte {
+	var req map[string]json.RawMessage
+	if err := json.Unmarshal(body, &req); err != nil {
+		return body
+	}
+	params, ok := req["params"]
+	if !ok {
+		return body
+	}
+	var method string
+	json.Unmarshal(req["method"], &method)
+
+	redacted := r.redactor.Redact(method, params)
+	if bytes.Equal(redacted, params) {
+		return body
+	}
+	req["params"] = redacted
+	out, err := json.Marshal(req)
+	if err != nil {
+		return body
+	}
+	return out
+}
@@ -0,0 +1,113 @@
+package recorder
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+	"github.com/kyokan/chaind/internal/audit"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/stretchr/testify/require"
+)
+
+func readAll(t *testing.T, capture string) []*Exchange {
+	r, err := OpenReader(capture)
+	require.NoError(t, err)
+	defer r.Close()
+
+	var exchanges []*Exchange
+	for {
+		ex, err := r.Next()
+		if err == io.EOF {
+			return exchanges
+		}
+		require.NoError(t, err)
+		exchanges = append(exchanges, ex)
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chaind-recorder")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var nilRec *Recorder
+	nilRec.Record(&Exchange{})
+	require.Nil(t, NewRecorder(nil, nil))
+
+	capture := path.Join(dir, "capture.gz")
+	start := time.Unix(1538395200, 0)
+	for run := 0; run < 2; run++ {
+		rec := NewRecorder(&config.RecorderConfig{Path: capture}, nil)
+		require.NoError(t, rec.Start())
+		for i := 0; i < 3; i++ {
+			rec.Record(&Exchange{
+				Time:     start.Add(time.Duration(run*3+i) * time.Second),
+				Duration: 5 * time.Millisecond,
+				Request:  []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`),
+				Response: []byte("{\"jsonrpc\":\"2.0\",\"id\":1,\"result\":\"0x10\"}\n"),
+			})
+		}
+		require.NoError(t, rec.Stop())
+	}
+
+	exchanges := readAll(t, capture)
+	require.Len(t, exchanges, 6)
+	for i, ex := range exchanges {
+		require.True(t, start.Add(time.Duration(i)*time.Second).Equal(ex.Time))
+		require.Equal(t, 5*time.Millisecond, ex.Duration)
+		require.Equal(t, "{\"jsonrpc\":\"2.0\",\"id\":1,\"result\":\"0x10\"}\n", string(ex.Response))
+	}
+
+	// a capture cut short ends at the last complete exchange
+	truncated := path.Join(dir, "truncated.gz")
+	f, err := os.Create(truncated)
+	require.NoError(t, err)
+	gz := gzip.NewWriter(f)
+	gz.Write([]byte("{\"t\":1,\"d\":2,\"q\":\"{}\",\"r\":\"{}\"}\n{\"t\":2,\"d\""))
+	require.NoError(t, gz.Flush())
+	require.NoError(t, f.Close())
+	require.Len(t, readAll(t, truncated), 1)
+
+	rec := NewRecorder(&config.RecorderConfig{Path: path.Join(dir, "sampled.gz"), SampleRate: 0.0001}, nil)
+	require.NoError(t, rec.Start())
+	rec.Record(&Exchange{Time: start})
+	require.NoError(t, rec.Stop())
+}
+
+func TestRecorder_Redacts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chaind-recorder")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	capture := path.Join(dir, "capture.gz")
+	rec := NewRecorder(&config.RecorderConfig{Path: capture}, audit.NewRedactor([]config.Redaction{
+		{Method: "eth_call", Params: []int{0}, Action: config.RedactDrop},
+	}))
+	require.NoError(t, rec.Start())
+	requests := []string{
+		`{"jsonrpc":"2.0","id":1,"method":"personal_unlockAccount","params":["0x01","hunter2",300]}`,
+		`[{"jsonrpc":"2.0","id":1,"method":"eth_call","params":[{"data":"0x01"},"latest"]},{"jsonrpc":"2.0","id":2,"method":"eth_blockNumber","params":[]}]`,
+		`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`,
+		`not json`,
+	}
+	for _, req := range requests {
+		rec.Record(&Exchange{Time: time.Now(), Request: []byte(req)})
+	}
+	require.NoError(t, rec.Stop())
+
+	info, err := os.Stat(capture)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	exchanges := readAll(t, capture)
+	require.Len(t, exchanges, 4)
+	require.JSONEq(t, `{"jsonrpc":"2.0","id":1,"method":"personal_unlockAccount","params":["[redacted]","[redacted]","[redacted]"]}`, string(exchanges[0].Request))
+	require.JSONEq(t, `[{"jsonrpc":"2.0","id":1,"method":"eth_call","params":["[redacted]","latest"]},{"jsonrpc":"2.0","id":2,"method":"eth_blockNumber","params":[]}]`, string(exchanges[1].Request))
+	// requests without redactions are recorded as they were sent
+	require.Equal(t, requests[2], string(exchanges[2].Request))
+	require.Equal(t, requests[3], string(exchanges[3].Request))
+}
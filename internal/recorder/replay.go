@@ -0,0 +1,251 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+	"github.com/kyokan/chaind/pkg"
+	"github.com/tidwall/gjson"
+)
+
+// MaxMismatches is the number of mismatched responses kept in a report.
+const MaxMismatches = 20
+
+// BatchMethod is the method that batch requests are reported under.
+const BatchMethod = "batch"
+
+type ReplayOptions struct {
+	URL string
+	// Speed scales the gaps between exchanges, so 1 replays at the original
+	// rate and 2 twice as fast. Zero replays as fast as Concurrency allows.
+	Speed       float64
+	Concurrency int
+	Timeout     time.Duration
+}
+
+// MethodStats are the results of replaying the requests for one method.
+type MethodStats struct {
+	Method     string
+	Count      int
+	Errors     int
+	Mismatches int
+	Latencies  []time.Duration
+	Original   []time.Duration
+}
+
+// Percentile returns the pth percentile of the replayed latencies.
+func (m *MethodStats) Percentile(p float64) time.Duration {
+	return percentile(m.Latencies, p)
+}
+
+// OriginalPercentile returns the pth percentile of the recorded latencies.
+func (m *MethodStats) OriginalPercentile(p float64) time.Duration {
+	return percentile(m.Original, p)
+}
+
+// Mismatch is a replayed request whose response differed from the recorded
+// one, ignoring JSON-RPC IDs.
+type Mismatch struct {
+	Method   string
+	Request  []byte
+	Expected []byte
+	Actual   []byte
+}
+
+type Report struct {
+	Methods    []*MethodStats
+	Total      *MethodStats
+	Mismatches []Mismatch
+	Elapsed    time.Duration
+}
+
+type replayResult struct {
+	ex       *Exchange
+	method   string
+	latency  time.Duration
+	response []byte
+	err      error
+}
+
+// Replay sends every request in the capture to opts.URL, and compares the
+// responses and latencies with the recorded ones.
+func Replay(r *Reader, opts ReplayOptions) (*Report, error) {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 1
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = 30 * time.Second
+	}
+	client := pkg.NewHTTPClient(opts.Timeout)
+
+	results := make(chan *replayResult, opts.Concurrency)
+	report := &Report{
+		Total: &MethodStats{},
+	}
+	collected := make(chan bool)
+	go func() {
+		report.collect(results)
+		collected <- true
+	}()
+
+	var wg sync.WaitGroup
+	sem := make(chan bool, opts.Concurrency)
+	start := time.Now()
+	var first time.Time
+	var readErr error
+	for {
+		ex, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			readErr = err
+			break
+		}
+
+		if opts.Speed > 0 {
+			if first.IsZero() {
+				first = ex.Time
+			}
+			due := start.Add(time.Duration(float64(ex.Time.Sub(first)) / opts.Speed))
+			time.Sleep(time.Until(due))
+		} else {
+			sem <- true
+		}
+
+		wg.Add(1)
+		go func(ex *Exchange) {
+			defer wg.Done()
+			results <- send(client, opts.URL, ex)
+			if opts.Speed <= 0 {
+				<-sem
+			}
+		}(ex)
+	}
+
+	wg.Wait()
+	close(results)
+	<-collected
+	report.Elapsed = time.Since(start)
+	return report, readErr
+}
+
+func send(client *http.Client, url string, ex *Exchange) *replayResult {
+	res := &replayResult{
+		ex:     ex,
+		method: methodOf(ex.Request),
+	}
+
+	start := time.Now()
+	httpRes, err := client.Post(url, "application/json", bytes.NewReader(ex.Request))
+	if err != nil {
+		res.err = err
+		return res
+	}
+	defer httpRes.Body.Close()
+	res.response, res.err = ioutil.ReadAll(httpRes.Body)
+	res.latency = time.Since(start)
+	if res.err == nil && httpRes.StatusCode != http.StatusOK {
+		res.err = fmt.Errorf("got status code %d", httpRes.StatusCode)
+	}
+	return res
+}
+
+func (r *Report) collect(results chan *replayResult) {
+	methods := make(map[string]*MethodStats)
+	for res := range results {
+		stats := methods[res.method]
+		if stats == nil {
+			stats = &MethodStats{Method: res.method}
+			methods[res.method] = stats
+		}
+
+		for _, s := range []*MethodStats{stats, r.Total} {
+			s.Count++
+			if res.err != nil {
+				s.Errors++
+				continue
+			}
+			s.Latencies = append(s.Latencies, res.latency)
+			s.Original = append(s.Original, res.ex.Duration)
+		}
+		if res.err != nil || sameResponse(res.ex.Response, res.response) {
+			continue
+		}
+
+		stats.Mismatches++
+		r.Total.Mismatches++
+		if len(r.Mismatches) < MaxMismatches {
+			r.Mismatches = append(r.Mismatches, Mismatch{
+				Method:   res.method,
+				Request:  res.ex.Request,
+				Expected: res.ex.Response,
+				Actual:   res.response,
+			})
+		}
+	}
+
+	for _, stats := range methods {
+		r.Methods = append(r.Methods, stats)
+	}
+	sort.Slice(r.Methods, func(i, j int) bool {
+		return r.Methods[i].Method < r.Methods[j].Method
+	})
+}
+
+func methodOf(request []byte) string {
+	parsed := gjson.ParseBytes(request)
+	if parsed.IsArray() {
+		return BatchMethod
+	}
+	return parsed.Get("method").String()
+}
+
+// sameResponse compares two response bodies, ignoring the JSON-RPC ID and
+// version of single and batch responses.
+func sameResponse(a []byte, b []byte) bool {
+	var aJSON, bJSON interface{}
+	if json.Unmarshal(a, &aJSON) != nil || json.Unmarshal(b, &bJSON) != nil {
+		return bytes.Equal(bytes.TrimSpace(a), bytes.TrimSpace(b))
+	}
+
+	return reflect.DeepEqual(stripEnvelope(aJSON), stripEnvelope(bJSON))
+}
+
+func stripEnvelope(body interface{}) interface{} {
+	switch v := body.(type) {
+	case map[string]interface{}:
+		delete(v, "id")
+		delete(v, "jsonrpc")
+	case []interface{}:
+		for _, item := range v {
+			stripEnvelope(item)
+		}
+	}
+	return body
+}
+
+func percentile(durations []time.Duration, p float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+
+	sorted := make([]time.Duration, len(durations))
+	copy(sorted, durations)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	i := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
@@ -0,0 +1,74 @@
+package recorder
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+)
+
+func TestReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chaind-recorder")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		switch gjson.GetBytes(body, "method").String() {
+		case "eth_blockNumber":
+			res.Write([]byte(`{"id":2,"jsonrpc":"2.0","result":"0x10"}`))
+		case "eth_chainId":
+			res.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":"0x2"}`))
+		case "eth_syncing":
+			res.WriteHeader(http.StatusBadGateway)
+		default:
+			res.Write([]byte(`[{"jsonrpc":"2.0","id":1,"result":"0x10"},{"jsonrpc":"2.0","id":2,"result":"0x1"}]`))
+		}
+	}))
+	defer srv.Close()
+
+	capture := path.Join(dir, "capture.gz")
+	rec := NewRecorder(&config.RecorderConfig{Path: capture}, nil)
+	require.NoError(t, rec.Start())
+	start := time.Now()
+	exchanges := []*Exchange{
+		{Request: []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`), Response: []byte(`{"jsonrpc":"2.0","id":1,"result":"0x10"}`)},
+		{Request: []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]}`), Response: []byte(`{"jsonrpc":"2.0","id":1,"result":"0x1"}`)},
+		{Request: []byte(`{"jsonrpc":"2.0","id":1,"method":"eth_syncing","params":[]}`), Response: []byte(`{"jsonrpc":"2.0","id":1,"result":false}`)},
+		{Request: []byte(`[{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber"},{"jsonrpc":"2.0","id":2,"method":"eth_chainId"}]`), Response: []byte(`[{"jsonrpc":"2.0","id":1,"result":"0x10"},{"jsonrpc":"2.0","id":2,"result":"0x1"}]`)},
+	}
+	for i, ex := range exchanges {
+		ex.Time = start.Add(time.Duration(i) * 100 * time.Millisecond)
+		ex.Duration = time.Duration(i+1) * time.Millisecond
+		rec.Record(ex)
+	}
+	require.NoError(t, rec.Stop())
+
+	for _, speed := range []float64{0, 4} {
+		r, err := OpenReader(capture)
+		require.NoError(t, err)
+		report, err := Replay(r, ReplayOptions{URL: srv.URL, Speed: speed, Concurrency: 2})
+		require.NoError(t, err)
+		r.Close()
+
+		require.Equal(t, 4, report.Total.Count)
+		require.Equal(t, 1, report.Total.Errors)
+		require.Equal(t, 1, report.Total.Mismatches)
+		require.Len(t, report.Methods, 4)
+		require.Equal(t, BatchMethod, report.Methods[0].Method)
+		require.Equal(t, "eth_chainId", report.Methods[2].Method)
+		require.Equal(t, 1, report.Methods[2].Mismatches)
+		require.Equal(t, 4*time.Millisecond, report.Methods[0].OriginalPercentile(50))
+		require.Equal(t, 4*time.Millisecond, report.Total.OriginalPercentile(100))
+		require.Equal(t, `{"jsonrpc":"2.0","id":1,"result":"0x2"}`, string(report.Mismatches[0].Actual))
+		if speed > 0 {
+			require.True(t, report.Elapsed >= 75*time.Millisecond, report.Elapsed)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package internal
+
+import (
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+	"github.com/fsnotify/fsnotify"
+	"github.com/inconshreveable/log15"
+	"github.com/kyokan/chaind/internal/backend"
+	"github.com/kyokan/chaind/internal/cache"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/kyokan/chaind/pkg/log"
+)
+
+// editors often write a file in several steps, so wait for changes to settle
+// before reloading
+const reloadDebounce = 500 * time.Millisecond
+
+type apiSetter interface {
+	SetEnabledAPIs(apis []string)
+}
+
+type policySetter interface {
+	SetPolicy(policy *cache.Policy)
+}
+
+// Reloader re-reads the config file on SIGHUP, and optionally whenever the
+// file changes, and applies what it can without a restart: the backend list,
+// enabled APIs, log level and cache policy. Invalid configs are rejected and
+// the running config is left untouched.
+type Reloader struct {
+	cfg      *config.Config
+	sw       backend.Controller
+	apis     apiSetter
+	policies policySetter
+	watcher  *fsnotify.Watcher
+	sigs     chan os.Signal
+	quitChan chan bool
+	mtx      sync.Mutex
+	logger   log15.Logger
+}
+
+func NewReloader(cfg *config.Config, sw backend.Controller, apis apiSetter, policies policySetter) *Reloader {
+	return &Reloader{
+		cfg:      cfg,
+		sw:       sw,
+		apis:     apis,
+		policies: policies,
+		sigs:     make(chan os.Signal, 1),
+		quitChan: make(chan bool),
+		logger:   log.NewLog("reloader"),
+	}
+}
+
+func (r *Reloader) Start() error {
+	var events chan fsnotify.Event
+	if r.cfg.WatchConfig {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			return err
+		}
+		// watch the directory rather than the file, since editors and
+		// config management tools often replace the file instead of
+		// writing to it
+		if err := watcher.Add(filepath.Dir(config.ConfigFile())); err != nil {
+			watcher.Close()
+			return err
+		}
+		r.watcher = watcher
+		events = watcher.Events
+	}
+
+	signal.Notify(r.sigs, syscall.SIGHUP)
+	go r.loop(events)
+	r.logger.Info("started", "watch_config", r.cfg.WatchConfig)
+	return nil
+}
+
+func (r *Reloader) Stop() error {
+	signal.Stop(r.sigs)
+	r.quitChan <- true
+	if r.watcher != nil {
+		return r.watcher.Close()
+	}
+	return nil
+}
+
+func (r *Reloader) loop(events chan fsnotify.Event) {
+	cfgFile := filepath.Clean(config.ConfigFile())
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-r.quitChan:
+			return
+		case <-r.sigs:
+			r.logger.Info("received SIGHUP, reloading config")
+			r.Reload()
+		case event := <-events:
+			if filepath.Clean(event.Name) != cfgFile || event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+			debounce = time.After(reloadDebounce)
+		case <-debounce:
+			debounce = nil
+			r.logger.Info("config file changed, reloading config")
+			r.Reload()
+		}
+	}
+}
+
+// Reload reads and validates the config file, then applies it.
+func (r *Reloader) Reload() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	next, err := config.ReadConfig(false)
+	if err != nil {
+		r.logger.Error("rejecting config reload, failed to read config", "err", err)
+		return err
+	}
+	if err := config.ValidateConfig(&next); err != nil {
+		r.logger.Error("rejecting config reload", "err", err)
+		return err
+	}
+
+	r.apply(r.cfg, &next)
+	r.cfg = &next
+	r.logger.Info("config reloaded")
+	return nil
+}
+
+func (r *Reloader) apply(prev *config.Config, next *config.Config) {
+	if prev.LogLevel != next.LogLevel {
+		r.logger.Info("changing log level", "from", prev.LogLevel, "to", next.LogLevel)
+		setLogLevel(r.logger, next.LogLevel)
+	}
+
+	if !reflect.DeepEqual(enabledAPIs(prev), enabledAPIs(next)) {
+		r.logger.Info("changing enabled APIs", "apis", enabledAPIs(next))
+		r.apis.SetEnabledAPIs(enabledAPIs(next))
+	}
+
+	if !reflect.DeepEqual(prev.CacheConfig, next.CacheConfig) {
+		r.logger.Info("changing cache policy")
+		r.policies.SetPolicy(cache.NewPolicy(next.CacheConfig))
+	}
+
+	r.applyBackends(prev.Backends, next.Backends)
+
+	if requiresRestart(prev, next) {
+		r.logger.Warn("config contains changes that will only take effect after a restart")
+	}
+}
+
+// applyBackends adds new backends before removing old ones, so that the
+// default pool never runs empty.
+func (r *Reloader) applyBackends(prev []config.Backend, next []config.Backend) {
+	prevByName := make(map[string]config.Backend)
+	for _, back := range prev {
+		prevByName[back.Name] = back
+	}
+	nextByName := make(map[string]bool)
+
+	for _, back := range next {
+		nextByName[back.Name] = true
+		old, ok := prevByName[back.Name]
+		var err error
+		if !ok {
+			err = r.sw.AddBackend(back)
+		} else if old.URL != back.URL || !reflect.DeepEqual(old.Tags, back.Tags) {
+			err = r.sw.UpdateBackend(back)
+		}
+		if err != nil {
+			r.logger.Error("failed to apply backend change", "name", back.Name, "err", err)
+		}
+	}
+
+	for _, back := range prev {
+		if nextByName[back.Name] {
+			continue
+		}
+		if err := r.sw.RemoveBackend(back.Name); err != nil {
+			r.logger.Error("failed to remove backend", "name", back.Name, "err", err)
+		}
+	}
+}
+
+func enabledAPIs(cfg *config.Config) []string {
+	if cfg.ETHConfig == nil {
+		return nil
+	}
+
+	return cfg.ETHConfig.APIs
+}
+
+// requiresRestart returns true if anything other than the live-reloadable
+// settings differs between the two configs.
+func requiresRestart(prev *config.Config, next *config.Config) bool {
+	masked := *next
+	masked.LogLevel = prev.LogLevel
+	masked.Backends = prev.Backends
+	masked.CacheConfig = prev.CacheConfig
+	if next.ETHConfig != nil && prev.ETHConfig != nil {
+		eth := *next.ETHConfig
+		eth.APIs = prev.ETHConfig.APIs
+		masked.ETHConfig = &eth
+	}
+
+	return !reflect.DeepEqual(prev, &masked)
+}
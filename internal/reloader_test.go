@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"github.com/kyokan/chaind/internal/backend"
+	"github.com/kyokan/chaind/internal/cache"
+	"github.com/kyokan/chaind/pkg"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingSetter struct {
+	apis   []string
+	policy *cache.Policy
+}
+
+func (r *recordingSetter) SetEnabledAPIs(apis []string) {
+	r.apis = apis
+}
+
+func (r *recordingSetter) SetPolicy(policy *cache.Policy) {
+	r.policy = policy
+}
+
+const reloadedConfig = `
+log_level="info"
+
+[eth]
+path="eth"
+apis=["eth", "net"]
+
+[cache]
+finality_depth=64
+
+[[backend]]
+name="test-1"
+url="http://localhost:8545"
+type="ETH"
+
+[[backend]]
+name="test-3"
+url="http://localhost:8547"
+type="ETH"
+tags=["archive"]
+`
+
+func TestReloader(t *testing.T) {
+	home, err := ioutil.TempDir("", "chaind")
+	require.NoError(t, err)
+	defer os.RemoveAll(home)
+	viper.Set(config.FlagHome, home)
+
+	cfg := &config.Config{
+		LogLevel:  "info",
+		ETHConfig: &config.ETH{Path: "eth", APIs: []string{"eth"}},
+		Backends: []config.Backend{
+			{Name: "test-1", URL: "http://localhost:8545", Type: pkg.EthBackend},
+			{Name: "test-2", URL: "http://localhost:8546", Type: pkg.EthBackend},
+		},
+	}
+	sw := backend.NewSwitcher(cfg.Backends)
+	setter := &recordingSetter{}
+	r := NewReloader(cfg, sw, setter, setter)
+
+	// a missing config file is rejected
+	require.Error(t, r.Reload())
+
+	cfgFile := path.Join(home, config.DefaultConfigFile)
+	require.NoError(t, ioutil.WriteFile(cfgFile, []byte("[[backend]]\nname=\"test-1\"\nurl=\"http://localhost:8545\"\ntype=\"BTC\"\n"), 0644))
+	require.Error(t, r.Reload())
+	duplicate := "[[backend]]\nname=\"test-1\"\nurl=\"http://localhost:8545\"\ntype=\"ETH\"\n"
+	require.NoError(t, ioutil.WriteFile(cfgFile, []byte(duplicate+duplicate), 0644))
+	require.Error(t, r.Reload())
+	require.Len(t, sw.Statuses(), 2)
+	require.Nil(t, setter.apis)
+	require.Nil(t, setter.policy)
+
+	require.NoError(t, ioutil.WriteFile(cfgFile, []byte(reloadedConfig), 0644))
+	require.NoError(t, r.Reload())
+	require.Equal(t, []string{"eth", "net"}, setter.apis)
+	require.Equal(t, uint64(64), setter.policy.FinalityDepth)
+	statuses := sw.Statuses()
+	require.Len(t, statuses, 2)
+	require.Equal(t, "test-1", statuses[0].Name)
+	require.Equal(t, "test-3", statuses[1].Name)
+	back, err := sw.BackendForPool(pkg.EthBackend, "archive")
+	require.NoError(t, err)
+	require.Equal(t, "test-3", back.Name)
+}
+
+func TestRequiresRestart(t *testing.T) {
+	prev := &config.Config{
+		RPCPort:   8080,
+		LogLevel:  "info",
+		ETHConfig: &config.ETH{Path: "eth", APIs: []string{"eth"}},
+		Backends:  []config.Backend{{Name: "test-1"}},
+	}
+	next := *prev
+	next.LogLevel = "debug"
+	next.ETHConfig = &config.ETH{Path: "eth", APIs: []string{"eth", "net"}}
+	next.Backends = []config.Backend{{Name: "test-2"}}
+	require.False(t, requiresRestart(prev, &next))
+
+	next.RPCPort = 9090
+	require.True(t, requiresRestart(prev, &next))
+}
@@ -11,8 +11,14 @@ import (
 	"github.com/kyokan/chaind/internal/cache"
 	"github.com/inconshreveable/log15"
 	"github.com/kyokan/chaind/internal/backend"
-	"net/http"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/kyokan/chaind/internal/tracker"
+	"github.com/kyokan/chaind/internal/nonce"
+	"github.com/kyokan/chaind/internal/admin"
+	"github.com/kyokan/chaind/internal/recorder"
+	"github.com/kyokan/chaind/pkg"
+	"github.com/kyokan/chaind/internal/health"
+	"github.com/kyokan/chaind/internal/tracing"
+	"time"
 	)
 
 func Start(cfg *config.Config) error {
@@ -21,50 +27,112 @@ func Start(cfg *config.Config) error {
 	}
 
 	logger := log.NewLog("")
-	lvl, err := log15.LvlFromString(cfg.LogLevel)
+	setLogLevel(logger, cfg.LogLevel)
+
+	tracer, err := tracing.NewProvider(cfg.TracingConfig)
 	if err != nil {
-		logger.Warn("invalid log level, falling back to INFO", "level", cfg.LogLevel)
-		lvl = log15.LvlInfo
+		return err
+	}
+	if tracer != nil {
+		if err := tracer.Start(); err != nil {
+			return err
+		}
 	}
-	log.SetLevel(lvl)
 
 	sw := backend.NewSwitcher(cfg.Backends)
 	if err := sw.Start(); err != nil {
 		return err
 	}
 
-	if cfg.EnablePrometheus {
-		logger.Info("Prometheus metrics enabled, listening on port 2112")
-		http.Handle("/metrics", promhttp.Handler())
-		go http.ListenAndServe(":2112", nil)
+	var adminSrv *admin.Server
+	if cfg.AdminConfig != nil {
+		adminSrv = admin.NewServer(cfg.AdminConfig, sw)
+		if err := adminSrv.Start(); err != nil {
+			return err
+		}
 	}
 
 	cacher := cache.NewRedisCacher(cfg.RedisConfig)
 	if err := cacher.Start(); err != nil {
-		return err
+		if !cfg.RedisConfig.AllowDegraded {
+			return err
+		}
+		logger.Warn("cache is unreachable, starting in degraded mode", "err", err)
 	}
 
-	auditor, err := audit.NewLogAuditor(cfg.LogAuditorConfig)
+	auditor, err := audit.NewAuditor(cfg)
 	if err != nil {
 		return err
 	}
+	if svc, ok := auditor.(pkg.Service); ok {
+		if err := svc.Start(); err != nil {
+			return err
+		}
+	}
 
 	hWatcher := cache.NewBlockHeightWatcher(sw)
 	if err := hWatcher.Start(); err != nil {
 		return err
 	}
 
+	var healthSrv *health.Server
+	metricsCfg := cfg.MetricsConfig
+	if metricsCfg == nil && cfg.EnablePrometheus {
+		metricsCfg = &config.MetricsConfig{ListenAddr: config.DefaultMetricsListenAddr}
+	}
+	if metricsCfg != nil {
+		maxHeadAge := time.Duration(metricsCfg.MaxHeadAgeSecs) * time.Second
+		checker := health.NewChecker(sw, cacher, hWatcher, cfg.RedisConfig.AllowDegraded, maxHeadAge)
+		healthSrv = health.NewServer(metricsCfg.ListenAddr, checker)
+		if err := healthSrv.Start(); err != nil {
+			return err
+		}
+	}
+
 	store := cache.NewETHStore(cacher, hWatcher)
-	warmer := cache.NewWarmer(store, cacher, hWatcher, sw)
+	store.SetPolicy(cache.NewPolicy(cfg.CacheConfig))
+	if err := store.Start(); err != nil {
+		return err
+	}
+	warmer := cache.NewWarmer(store, cacher, hWatcher, sw, cfg.WarmerConfig)
 	if err := warmer.Start(); err != nil {
 		return err
 	}
 
-	prox := proxy.NewProxy(sw, auditor, store, hWatcher, cfg)
+	var txTracker *tracker.Tracker
+	if cfg.TrackerConfig != nil {
+		txTracker = tracker.NewTracker(cfg.TrackerConfig, sw, hWatcher)
+		if err := txTracker.Start(); err != nil {
+			return err
+		}
+	}
+
+	var nonces *nonce.Manager
+	if cfg.NonceConfig != nil {
+		nonces = nonce.NewManager(cfg.NonceConfig, sw, hWatcher)
+		if err := nonces.Start(); err != nil {
+			return err
+		}
+	}
+
+	rec := recorder.NewRecorder(cfg.RecorderConfig, audit.NewRedactor(cfg.Redactions))
+	if rec != nil {
+		if err := rec.Start(); err != nil {
+			return err
+		}
+	}
+
+	prox := proxy.NewProxy(sw, auditor, store, hWatcher, txTracker, nonces, cfg)
+	prox.SetRecorder(rec)
 	if err := prox.Start(); err != nil {
 		return err
 	}
 
+	reloader := NewReloader(cfg, sw, prox, store)
+	if err := reloader.Start(); err != nil {
+		return err
+	}
+
 	sigs := make(chan os.Signal, 1)
 	done := make(chan bool, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
@@ -72,9 +140,16 @@ func Start(cfg *config.Config) error {
 	go func() {
 		<-sigs
 		logger.Info("interrupted, shutting down")
+		if err := reloader.Stop(); err != nil {
+			logger.Error("failed to stop config reloader", "err", err)
+		}
 		if err := sw.Stop(); err != nil {
 			logger.Error("failed to stop backend switch", "err", err)
 		}
+		// stopped before the cacher so that the last lookup counts are flushed
+		if err := store.Stop(); err != nil {
+			logger.Error("failed to stop eth store", "err", err)
+		}
 		if err := cacher.Stop(); err != nil {
 			logger.Error("failed to stop cacher", "err", err)
 		}
@@ -87,6 +162,42 @@ func Start(cfg *config.Config) error {
 		if err := warmer.Stop(); err != nil {
 			logger.Error("failed to stop cache warmer", "err", err)
 		}
+		if svc, ok := auditor.(pkg.Service); ok {
+			if err := svc.Stop(); err != nil {
+				logger.Error("failed to stop auditor", "err", err)
+			}
+		}
+		if rec != nil {
+			if err := rec.Stop(); err != nil {
+				logger.Error("failed to stop traffic recorder", "err", err)
+			}
+		}
+		if txTracker != nil {
+			if err := txTracker.Stop(); err != nil {
+				logger.Error("failed to stop transaction tracker", "err", err)
+			}
+		}
+		if nonces != nil {
+			if err := nonces.Stop(); err != nil {
+				logger.Error("failed to stop nonce manager", "err", err)
+			}
+		}
+		if adminSrv != nil {
+			if err := adminSrv.Stop(); err != nil {
+				logger.Error("failed to stop admin server", "err", err)
+			}
+		}
+		if healthSrv != nil {
+			if err := healthSrv.Stop(); err != nil {
+				logger.Error("failed to stop metrics server", "err", err)
+			}
+		}
+		// stopped last so that it exports the spans of in-flight requests
+		if tracer != nil {
+			if err := tracer.Stop(); err != nil {
+				logger.Error("failed to stop tracing", "err", err)
+			}
+		}
 		done <- true
 	}()
 
@@ -94,3 +205,12 @@ func Start(cfg *config.Config) error {
 	logger.Info("goodbye")
 	return nil
 }
+
+func setLogLevel(logger log15.Logger, level string) {
+	lvl, err := log15.LvlFromString(level)
+	if err != nil {
+		logger.Warn("invalid log level, falling back to INFO", "level", level)
+		lvl = log15.LvlInfo
+	}
+	log.SetLevel(lvl)
+}
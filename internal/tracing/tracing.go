@@ -0,0 +1,111 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+	"github.com/inconshreveable/log15"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/kyokan/chaind/pkg/log"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the name of the tracer chaind's spans are created with.
+const TracerName = "github.com/kyokan/chaind"
+
+const DefaultServiceName = "chaind"
+
+// Tracer returns the tracer used for chaind's spans. Until a Provider is
+// started, its spans are not recorded.
+func Tracer() trace.Tracer {
+	return otel.Tracer(TracerName)
+}
+
+// Extract returns ctx with the W3C trace context in the headers, if any, so
+// that spans started from it continue the client's trace.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// Inject adds the W3C trace context of the span in ctx to the headers.
+func Inject(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// Provider batches spans and exports them over OTLP/HTTP. Starting it makes it
+// the global tracer provider, and enables W3C trace context propagation.
+type Provider struct {
+	tp     *sdktrace.TracerProvider
+	logger log15.Logger
+}
+
+// NewProvider returns a Provider, or nil if tracing isn't configured.
+func NewProvider(cfg *config.TracingConfig) (*Provider, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(endpoint.Host),
+	}
+	if endpoint.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if endpoint.Path != "" && endpoint.Path != "/" {
+		opts = append(opts, otlptracehttp.WithURLPath(endpoint.Path))
+	}
+	if len(cfg.Headers) > 0 {
+		opts = append(opts, otlptracehttp.WithHeaders(cfg.Headers))
+	}
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceName := cfg.ServiceName
+	if serviceName == "" {
+		serviceName = DefaultServiceName
+	}
+	sampleRate := cfg.SampleRate
+	if sampleRate == 0 {
+		sampleRate = 1
+	}
+
+	return &Provider{
+		tp: sdktrace.NewTracerProvider(
+			sdktrace.WithBatcher(exporter),
+			sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", serviceName))),
+			// clients that send a sampled trace context are always traced
+			sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRate))),
+		),
+		logger: log.NewLog("tracing"),
+	}, nil
+}
+
+func (p *Provider) Start() error {
+	otel.SetTracerProvider(p.tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		p.logger.Warn("tracing error", "err", err)
+	}))
+	p.logger.Info("started")
+	return nil
+}
+
+// Stop exports any buffered spans before returning.
+func (p *Provider) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return p.tp.Shutdown(ctx)
+}
@@ -0,0 +1,117 @@
+package tracing
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"google.golang.org/protobuf/proto"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	coltrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// collector is an in-process OTLP/HTTP collector that keeps the spans it
+// receives.
+type collector struct {
+	mtx      sync.Mutex
+	services []string
+	spans    []string
+	headers  http.Header
+}
+
+func (c *collector) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/v1/traces" {
+		res.WriteHeader(http.StatusNotFound)
+		return
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var export coltrace.ExportTraceServiceRequest
+	if err := proto.Unmarshal(body, &export); err != nil {
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.headers = req.Header
+	for _, rs := range export.ResourceSpans {
+		for _, attr := range rs.Resource.Attributes {
+			if attr.Key == "service.name" {
+				c.services = append(c.services, attr.Value.GetStringValue())
+			}
+		}
+		for _, ss := range rs.ScopeSpans {
+			for _, span := range ss.Spans {
+				c.spans = append(c.spans, span.Name)
+			}
+		}
+	}
+	res.Header().Set("Content-Type", "application/x-protobuf")
+	res.WriteHeader(http.StatusOK)
+}
+
+func TestProvider(t *testing.T) {
+	coll := new(collector)
+	srv := httptest.NewServer(coll)
+	defer srv.Close()
+
+	prov, err := NewProvider(&config.TracingConfig{
+		Endpoint:    srv.URL,
+		ServiceName: "chaind-test",
+		Headers:     map[string]string{"X-Api-Key": "secret"},
+	})
+	require.NoError(t, err)
+	require.NoError(t, prov.Start())
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	ctx, parent := Tracer().Start(context.Background(), "parent")
+	_, child := Tracer().Start(ctx, "child")
+	child.End()
+	parent.End()
+	require.NoError(t, prov.Stop())
+
+	coll.mtx.Lock()
+	defer coll.mtx.Unlock()
+	require.ElementsMatch(t, []string{"parent", "child"}, coll.spans)
+	require.Equal(t, []string{"chaind-test"}, coll.services)
+	require.Equal(t, "secret", coll.headers.Get("X-Api-Key"))
+}
+
+func TestNewProvider_Nil(t *testing.T) {
+	prov, err := NewProvider(nil)
+	require.NoError(t, err)
+	require.Nil(t, prov)
+}
+
+func TestPropagation(t *testing.T) {
+	prov, err := NewProvider(&config.TracingConfig{Endpoint: "http://localhost:4318"})
+	require.NoError(t, err)
+	require.NoError(t, prov.Start())
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	defer prov.Stop()
+
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	in := make(http.Header)
+	in.Set("traceparent", traceparent)
+	ctx := Extract(context.Background(), in)
+	sc := trace.SpanContextFromContext(ctx)
+	require.True(t, sc.IsRemote())
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID().String())
+
+	// spans started from the client's context continue its trace
+	ctx, span := Tracer().Start(ctx, "request")
+	defer span.End()
+	out := make(http.Header)
+	Inject(ctx, out)
+	require.Regexp(t, "^00-4bf92f3577b34da6a3ce929d0e0e4736-[0-9a-f]{16}-01$", out.Get("traceparent"))
+	require.NotEqual(t, traceparent, out.Get("traceparent"))
+}
@@ -0,0 +1,272 @@
+package tracker
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"github.com/inconshreveable/log15"
+	"github.com/kyokan/chaind/internal/backend"
+	"github.com/kyokan/chaind/internal/cache"
+	"github.com/kyokan/chaind/pkg"
+	"github.com/kyokan/chaind/pkg/concurrent"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/kyokan/chaind/pkg/jsonrpc"
+	"github.com/kyokan/chaind/pkg/log"
+	"github.com/tidwall/gjson"
+)
+
+const DefaultDropAfter = time.Hour
+const DefaultRebroadcastAfter = 2 * time.Minute
+const DefaultRetention = time.Hour
+const ReceiptConcurrency = 5
+
+type TxStatus string
+
+const (
+	StatusPending   TxStatus = "pending"
+	StatusMined     TxStatus = "mined"
+	StatusFinalized TxStatus = "finalized"
+	StatusDropped   TxStatus = "dropped"
+)
+
+type TrackedTx struct {
+	Hash          string    `json:"hash"`
+	Status        TxStatus  `json:"status"`
+	BlockNumber   string    `json:"blockNumber,omitempty"`
+	SubmittedAt   time.Time `json:"submittedAt"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+	Rebroadcasts  int       `json:"rebroadcasts"`
+	rawTx         string
+	lastBroadcast time.Time
+}
+
+func (t *TrackedTx) isTerminal() bool {
+	return t.Status == StatusFinalized || t.Status == StatusDropped
+}
+
+// Tracker follows transactions submitted through chaind until they are
+// finalized or dropped. Stuck transactions are periodically rebroadcast, and
+// every status change is posted to the configured webhooks.
+type Tracker struct {
+	sw               backend.Switcher
+	hWatcher         *cache.BlockHeightWatcher
+	dropAfter        time.Duration
+	rebroadcastAfter time.Duration
+	retention        time.Duration
+	webhooks         []string
+	client           *http.Client
+	txs              map[string]*TrackedTx
+	mtx              sync.Mutex
+	hdl              int
+	processing       int32
+	logger           log15.Logger
+}
+
+func NewTracker(cfg *config.TrackerConfig, sw backend.Switcher, hWatcher *cache.BlockHeightWatcher) *Tracker {
+	t := &Tracker{
+		sw:               sw,
+		hWatcher:         hWatcher,
+		dropAfter:        DefaultDropAfter,
+		rebroadcastAfter: DefaultRebroadcastAfter,
+		retention:        DefaultRetention,
+		webhooks:         cfg.Webhooks,
+		client:           pkg.NewHTTPClient(5 * time.Second),
+		txs:              make(map[string]*TrackedTx),
+		logger:           log.NewLog("tracker"),
+	}
+	if cfg.DropAfterSecs > 0 {
+		t.dropAfter = time.Duration(cfg.DropAfterSecs) * time.Second
+	}
+	if cfg.RebroadcastAfterSecs > 0 {
+		t.rebroadcastAfter = time.Duration(cfg.RebroadcastAfterSecs) * time.Second
+	}
+	if cfg.RetentionSecs > 0 {
+		t.retention = time.Duration(cfg.RetentionSecs) * time.Second
+	}
+	return t
+}
+
+func (t *Tracker) Start() error {
+	t.hdl = t.hWatcher.Subscribe(t.onBlock)
+	t.logger.Info("started")
+	return nil
+}
+
+func (t *Tracker) Stop() error {
+	t.hWatcher.Unsubscribe(t.hdl)
+	return nil
+}
+
+// Track starts following the transaction with the given hash. rawTx is kept
+// so that the transaction can be rebroadcast if it gets stuck.
+func (t *Tracker) Track(hash string, rawTx string) {
+	hash = strings.ToLower(hash)
+	now := time.Now()
+
+	t.mtx.Lock()
+	if _, ok := t.txs[hash]; ok {
+		t.mtx.Unlock()
+		return
+	}
+	tx := &TrackedTx{
+		Hash:          hash,
+		Status:        StatusPending,
+		SubmittedAt:   now,
+		UpdatedAt:     now,
+		rawTx:         rawTx,
+		lastBroadcast: now,
+	}
+	t.txs[hash] = tx
+	snapshot := *tx
+	t.mtx.Unlock()
+
+	t.logger.Debug("tracking transaction", "tx_hash", hash)
+	t.notify(&snapshot)
+}
+
+// Status returns a copy of the tracked transaction with the given hash, or nil
+// if it isn't being tracked.
+func (t *Tracker) Status(hash string) *TrackedTx {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	tx, ok := t.txs[strings.ToLower(hash)]
+	if !ok {
+		return nil
+	}
+	snapshot := *tx
+	return &snapshot
+}
+
+func (t *Tracker) onBlock(number uint64) {
+	// blocks can arrive faster than receipts can be fetched, so skip
+	// blocks rather than letting updates pile up
+	if !atomic.CompareAndSwapInt32(&t.processing, 0, 1) {
+		t.logger.Debug("skipping block, previous update still running", "number", number)
+		return
+	}
+	defer atomic.StoreInt32(&t.processing, 0)
+
+	var hashes []string
+	t.mtx.Lock()
+	for hash, tx := range t.txs {
+		if tx.isTerminal() {
+			if time.Since(tx.UpdatedAt) > t.retention {
+				delete(t.txs, hash)
+			}
+			continue
+		}
+		hashes = append(hashes, hash)
+	}
+	t.mtx.Unlock()
+
+	concurrent.ConsumeStrings(hashes, t.update, ReceiptConcurrency)
+}
+
+func (t *Tracker) update(hash string) {
+	client, err := t.sw.ETHClient()
+	if err != nil {
+		t.logger.Error("failed to get Ethereum client", "err", err)
+		return
+	}
+
+	receipt, err := client.GetTransactionReceipt(hash)
+	if err != nil {
+		t.logger.Error("failed to get transaction receipt", "tx_hash", hash, "err", err)
+		return
+	}
+
+	blockNumStr := gjson.GetBytes(receipt, "blockNumber").String()
+	var blockNum uint64
+	if blockNumStr != "" {
+		blockNum, err = jsonrpc.Hex2Uint64(blockNumStr)
+		if err != nil {
+			t.logger.Error("received invalid block number in receipt", "tx_hash", hash, "number", blockNumStr)
+			return
+		}
+	}
+
+	t.mtx.Lock()
+	tx, ok := t.txs[hash]
+	if !ok {
+		t.mtx.Unlock()
+		return
+	}
+
+	prevStatus := tx.Status
+	rebroadcast := false
+	switch {
+	case blockNumStr != "" && t.hWatcher.IsFinalized(blockNum):
+		tx.Status = StatusFinalized
+		tx.BlockNumber = blockNumStr
+	case blockNumStr != "":
+		tx.Status = StatusMined
+		tx.BlockNumber = blockNumStr
+	case time.Since(tx.SubmittedAt) > t.dropAfter:
+		tx.Status = StatusDropped
+		tx.BlockNumber = ""
+	default:
+		// a previously mined transaction without a receipt was reorged out
+		tx.Status = StatusPending
+		tx.BlockNumber = ""
+		if time.Since(tx.lastBroadcast) > t.rebroadcastAfter {
+			tx.lastBroadcast = time.Now()
+			tx.Rebroadcasts++
+			rebroadcast = true
+		}
+	}
+
+	changed := tx.Status != prevStatus
+	if changed {
+		tx.UpdatedAt = time.Now()
+	}
+	snapshot := *tx
+	t.mtx.Unlock()
+
+	if rebroadcast {
+		t.rebroadcast(&snapshot)
+	}
+	if changed {
+		t.logger.Info("transaction status changed", "tx_hash", hash, "from", prevStatus, "to", snapshot.Status)
+		t.notify(&snapshot)
+	}
+}
+
+func (t *Tracker) rebroadcast(tx *TrackedTx) {
+	t.logger.Info("rebroadcasting stuck transaction", "tx_hash", tx.Hash, "attempt", tx.Rebroadcasts)
+	for _, back := range t.sw.HealthyBackendsFor(pkg.EthBackend) {
+		if _, err := backend.NewETHClient(back.URL).SendRawTransaction(tx.rawTx); err != nil {
+			t.logger.Warn("backend rejected rebroadcast transaction", "tx_hash", tx.Hash, "backend", back.Name, "err", err)
+		}
+	}
+}
+
+func (t *Tracker) notify(tx *TrackedTx) {
+	if len(t.webhooks) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(tx)
+	if err != nil {
+		t.logger.Error("failed to marshal webhook body", "err", err)
+		return
+	}
+
+	for _, hook := range t.webhooks {
+		go func(hook string) {
+			res, err := t.client.Post(hook, "application/json", bytes.NewReader(body))
+			if err != nil {
+				t.logger.Warn("failed to call webhook", "url", hook, "err", err)
+				return
+			}
+			res.Body.Close()
+			if res.StatusCode >= 300 {
+				t.logger.Warn("webhook returned non-2xx response", "url", hook, "status", res.StatusCode)
+			}
+		}(hook)
+	}
+}
@@ -0,0 +1,146 @@
+package tracker
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+	"github.com/kyokan/chaind/internal/backend/backendtest"
+	"github.com/kyokan/chaind/internal/cache"
+	"github.com/kyokan/chaind/pkg/config"
+	"github.com/kyokan/chaind/pkg/jsonrpc"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type TrackerSuite struct {
+	suite.Suite
+	srv          *httptest.Server
+	watcher      *cache.BlockHeightWatcher
+	tracker      *Tracker
+	mtx          sync.Mutex
+	receipts     map[string]string
+	rebroadcasts int
+	hooks        chan TrackedTx
+	hookSrv      *httptest.Server
+}
+
+func (s *TrackerSuite) SetupTest() {
+	s.receipts = make(map[string]string)
+	s.rebroadcasts = 0
+	s.hooks = make(chan TrackedTx, 10)
+	s.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req jsonrpc.Request
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &req)
+		s.mtx.Lock()
+		defer s.mtx.Unlock()
+
+		var result string
+		switch req.Method {
+		case "eth_blockNumber":
+			result = "\"0x64\""
+		case "eth_getTransactionReceipt":
+			var params []string
+			json.Unmarshal(req.Params, &params)
+			result = s.receipts[params[0]]
+			if result == "" {
+				result = "null"
+			}
+		case "eth_sendRawTransaction":
+			s.rebroadcasts++
+			result = "\"0xabc\""
+		}
+		w.Write([]byte("{\"jsonrpc\":\"2.0\",\"id\":1,\"result\":" + result + "}"))
+	}))
+	s.hookSrv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var tx TrackedTx
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &tx)
+		s.hooks <- tx
+	}))
+
+	sw := backendtest.NewURLSwitch(s.srv.URL)
+	s.watcher = cache.NewBlockHeightWatcher(sw)
+	require.NoError(s.T(), s.watcher.Start())
+	s.tracker = NewTracker(&config.TrackerConfig{
+		Webhooks: []string{s.hookSrv.URL},
+	}, sw, s.watcher)
+}
+
+func (s *TrackerSuite) TearDownTest() {
+	require.NoError(s.T(), s.watcher.Stop())
+	s.srv.Close()
+	s.hookSrv.Close()
+}
+
+func (s *TrackerSuite) setReceipt(hash string, receipt string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.receipts[hash] = receipt
+}
+
+func (s *TrackerSuite) nextHook() TrackedTx {
+	select {
+	case tx := <-s.hooks:
+		return tx
+	case <-time.After(time.Second):
+		s.T().Fatal("timed out waiting for webhook")
+		return TrackedTx{}
+	}
+}
+
+func (s *TrackerSuite) TestLifecycle() {
+	require.Nil(s.T(), s.tracker.Status("0xabc"))
+
+	s.tracker.Track("0xABC", "0xf86c")
+	require.Equal(s.T(), StatusPending, s.tracker.Status("0xabc").Status)
+	require.Equal(s.T(), StatusPending, s.nextHook().Status)
+
+	s.tracker.onBlock(100)
+	require.Equal(s.T(), StatusPending, s.tracker.Status("0xabc").Status)
+
+	s.setReceipt("0xabc", "{\"transactionHash\":\"0xabc\",\"blockNumber\":\"0x63\"}")
+	s.tracker.onBlock(100)
+	status := s.tracker.Status("0xabc")
+	require.Equal(s.T(), StatusMined, status.Status)
+	require.Equal(s.T(), "0x63", status.BlockNumber)
+	require.Equal(s.T(), StatusMined, s.nextHook().Status)
+
+	// simulate a reorg
+	s.setReceipt("0xabc", "")
+	s.tracker.onBlock(100)
+	require.Equal(s.T(), StatusPending, s.tracker.Status("0xabc").Status)
+	require.Equal(s.T(), StatusPending, s.nextHook().Status)
+
+	s.setReceipt("0xabc", "{\"transactionHash\":\"0xabc\",\"blockNumber\":\"0x5a\"}")
+	s.tracker.onBlock(100)
+	require.Equal(s.T(), StatusFinalized, s.tracker.Status("0xabc").Status)
+	require.Equal(s.T(), StatusFinalized, s.nextHook().Status)
+}
+
+func (s *TrackerSuite) TestRebroadcastAndDrop() {
+	s.tracker.rebroadcastAfter = 0
+	s.tracker.Track("0xdef", "0xf86c")
+	s.nextHook()
+
+	s.tracker.onBlock(100)
+	status := s.tracker.Status("0xdef")
+	require.Equal(s.T(), StatusPending, status.Status)
+	require.Equal(s.T(), 1, status.Rebroadcasts)
+	s.mtx.Lock()
+	require.Equal(s.T(), 1, s.rebroadcasts)
+	s.mtx.Unlock()
+
+	s.tracker.dropAfter = 0
+	s.tracker.onBlock(100)
+	require.Equal(s.T(), StatusDropped, s.tracker.Status("0xdef").Status)
+	require.Equal(s.T(), StatusDropped, s.nextHook().Status)
+}
+
+func TestTrackerSuite(t *testing.T) {
+	suite.Run(t, new(TrackerSuite))
+}
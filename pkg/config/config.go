@@ -10,11 +10,19 @@ import (
 	"github.com/kyokan/chaind/pkg"
 	"net/url"
 	"github.com/kyokan/chaind/pkg/sets"
+	"strings"
+	"encoding/hex"
 )
 
 const DefaultHome = "~/.chaind"
 const DefaultConfigFile = "chaind.toml"
 
+const FullNodeTag = "full"
+
+// DefaultMetricsListenAddr is where metrics and health checks are served if
+// enable_prometheus is set without a [metrics] stanza.
+const DefaultMetricsListenAddr = ":2112"
+
 const (
 	FlagHome     = "home"
 	FlagCertPath = "cert_path"
@@ -22,6 +30,60 @@ const (
 	FlagRPCPort  = "rpc_port"
 )
 
+var CacheableMethods = sets.NewStringSet([]string{
+	"eth_getBalance",
+	"eth_getBlockByNumber",
+	"eth_getCode",
+	"eth_getLogs",
+	"eth_getTransactionCount",
+	"eth_getTransactionReceipt",
+})
+
+const (
+	WarmBlocks   = "blocks"
+	WarmReceipts = "receipts"
+	WarmLogs     = "logs"
+)
+
+var WarmableData = sets.NewStringSet([]string{
+	WarmBlocks,
+	WarmReceipts,
+	WarmLogs,
+})
+
+const (
+	AuditSinkFile   = "file"
+	AuditSinkSyslog = "syslog"
+	AuditSinkSQLite = "sqlite"
+)
+
+var AuditSinkTypes = sets.NewStringSet([]string{
+	AuditSinkFile,
+	AuditSinkSyslog,
+	AuditSinkSQLite,
+})
+
+var SyslogNetworks = sets.NewStringSet([]string{
+	"tcp",
+	"udp",
+	"unix",
+	"unixgram",
+})
+
+const (
+	RedactDrop     = "drop"
+	RedactHash     = "hash"
+	RedactTruncate = "truncate"
+	RedactKeep     = "keep"
+)
+
+var RedactActions = sets.NewStringSet([]string{
+	RedactDrop,
+	RedactHash,
+	RedactTruncate,
+	RedactKeep,
+})
+
 var ValidETHAPIs = sets.NewStringSet([]string{
 	"admin",
 	"db",
@@ -40,23 +102,98 @@ type Config struct {
 	CertPath         string            `mapstructure:"cert_path"`
 	UseTLS           bool              `mapstructure:"use_tls"`
 	EnablePrometheus bool              `mapstructure:"enable_prometheus"`
+	MetricsConfig    *MetricsConfig    `mapstructure:"metrics"`
 	ETHConfig        *ETH              `mapstructure:"eth"`
 	RPCPort          int               `mapstructure:"rpc_port"`
 	LogLevel         string            `mapstructure:"log_level"`
 	LogAuditorConfig *LogAuditorConfig `mapstructure:"log_auditor"`
+	AuditConfig      *AuditConfig      `mapstructure:"audit"`
+	Redactions       []Redaction       `mapstructure:"redact"`
+	RecorderConfig   *RecorderConfig   `mapstructure:"recorder"`
+	TracingConfig    *TracingConfig    `mapstructure:"tracing"`
 	RedisConfig      *RedisConfig      `mapstructure:"redis"`
 	Backends         []Backend         `mapstructure:"backend"`
+	Hedges           []Hedge           `mapstructure:"hedge"`
+	Routes           []Route           `mapstructure:"route"`
+	Quorum           *Quorum           `mapstructure:"quorum"`
+	Stickiness       *Stickiness       `mapstructure:"stickiness"`
+	TrackerConfig    *TrackerConfig    `mapstructure:"tracker"`
+	NonceConfig      *NonceConfig      `mapstructure:"nonce_manager"`
+	AdminConfig      *AdminConfig      `mapstructure:"admin"`
+	CacheConfig      *CacheConfig      `mapstructure:"cache"`
+	WarmerConfig     *WarmerConfig     `mapstructure:"warmer"`
+	WatchConfig      bool              `mapstructure:"watch_config"`
 	Master           bool              `mapstructure:"master"`
 }
 
 type LogAuditorConfig struct {
-	LogFile string `mapstructure:"log_file"`
+	LogFile      string `mapstructure:"log_file"`
+	Format       string `mapstructure:"format"`
+	APIKeyHeader string `mapstructure:"api_key_header"`
+}
+
+type AuditConfig struct {
+	BufferSize   int         `mapstructure:"buffer_size"`
+	APIKeyHeader string      `mapstructure:"api_key_header"`
+	Sinks        []AuditSink `mapstructure:"sink"`
+}
+
+// AuditSink configures one destination for audit records. Which fields apply
+// depends on the sink's type.
+type AuditSink struct {
+	Type string `mapstructure:"type"`
+	// file and sqlite
+	Path string `mapstructure:"path"`
+	// file
+	Format          string `mapstructure:"format"`
+	MaxSizeMB       int    `mapstructure:"max_size_mb"`
+	MaxAgeDays      int    `mapstructure:"max_age_days"`
+	MaxBackups      int    `mapstructure:"max_backups"`
+	Compress        bool   `mapstructure:"compress"`
+	RotateEverySecs int    `mapstructure:"rotate_every_secs"`
+	// syslog
+	Network  string `mapstructure:"network"`
+	Address  string `mapstructure:"address"`
+	AppName  string `mapstructure:"app_name"`
+	Facility string `mapstructure:"facility"`
+}
+
+// Redaction hides request params from audit records. An empty Params
+// applies the action to every param.
+type Redaction struct {
+	Method    string `mapstructure:"method"`
+	Params    []int  `mapstructure:"params"`
+	Action    string `mapstructure:"action"`
+	MaxLength int    `mapstructure:"max_length"`
+}
+
+type RecorderConfig struct {
+	Path       string  `mapstructure:"path"`
+	SampleRate float64 `mapstructure:"sample_rate"`
+	BufferSize int     `mapstructure:"buffer_size"`
+}
+
+// TracingConfig configures exporting traces to an OTLP/HTTP endpoint, such as
+// an OpenTelemetry collector.
+type TracingConfig struct {
+	Endpoint    string            `mapstructure:"endpoint"`
+	ServiceName string            `mapstructure:"service_name"`
+	SampleRate  float64           `mapstructure:"sample_rate"`
+	Headers     map[string]string `mapstructure:"headers"`
 }
 
 type RedisConfig struct {
 	URL      string `mapstructure:"url"`
 	Password string `mapstructure:"password"`
 	DB       int    `mapstructure:"db"`
+	// AllowDegraded lets chaind start and report itself ready while Redis
+	// is unreachable, serving every request from the backends.
+	AllowDegraded bool `mapstructure:"allow_degraded"`
+}
+
+type MetricsConfig struct {
+	ListenAddr     string `mapstructure:"listen_addr"`
+	MaxHeadAgeSecs int    `mapstructure:"max_head_age_secs"`
 }
 
 type Backend struct {
@@ -64,6 +201,81 @@ type Backend struct {
 	URL  string          `mapstructure:"url"`
 	Name string          `mapstructure:"name"`
 	Main bool            `mapstructure:"main"`
+	Tags []string        `mapstructure:"tags"`
+}
+
+// InDefaultPool returns true if the backend should serve requests that
+// aren't matched by any route, i.e. if it's untagged or tagged as a full node.
+func (b *Backend) InDefaultPool() bool {
+	if len(b.Tags) == 0 {
+		return true
+	}
+
+	for _, tag := range b.Tags {
+		if tag == FullNodeTag {
+			return true
+		}
+	}
+
+	return false
+}
+
+type Route struct {
+	Pool      string   `mapstructure:"pool"`
+	Methods   []string `mapstructure:"methods"`
+	OlderThan uint64   `mapstructure:"older_than"`
+}
+
+type Hedge struct {
+	Method  string `mapstructure:"method"`
+	DelayMS int    `mapstructure:"delay_ms"`
+}
+
+type Quorum struct {
+	Methods   []string `mapstructure:"methods"`
+	Size      int      `mapstructure:"size"`
+	Threshold int      `mapstructure:"threshold"`
+}
+
+type Stickiness struct {
+	Header        string `mapstructure:"header"`
+	WaitMS        int    `mapstructure:"wait_ms"`
+	RetentionSecs int    `mapstructure:"retention_secs"`
+	MaxClients    int    `mapstructure:"max_clients"`
+}
+
+type TrackerConfig struct {
+	DropAfterSecs        int      `mapstructure:"drop_after_secs"`
+	RebroadcastAfterSecs int      `mapstructure:"rebroadcast_after_secs"`
+	RetentionSecs        int      `mapstructure:"retention_secs"`
+	Webhooks             []string `mapstructure:"webhooks"`
+}
+
+type NonceConfig struct {
+	DropAfterSecs int `mapstructure:"drop_after_secs"`
+}
+
+type AdminConfig struct {
+	ListenAddr string `mapstructure:"listen_addr"`
+	AuthToken  string `mapstructure:"auth_token"`
+}
+
+type CacheConfig struct {
+	FinalityDepth uint64     `mapstructure:"finality_depth"`
+	TTLs          []CacheTTL `mapstructure:"ttl"`
+}
+
+type CacheTTL struct {
+	Method      string `mapstructure:"method"`
+	TTLSecs     int    `mapstructure:"ttl_secs"`
+	NeverExpire bool   `mapstructure:"never_expire"`
+}
+
+type WarmerConfig struct {
+	Depth          uint64   `mapstructure:"depth"`
+	Concurrency    int      `mapstructure:"concurrency"`
+	Data           []string `mapstructure:"data"`
+	WatchAddresses []string `mapstructure:"watch_addresses"`
 }
 
 type ETH struct {
@@ -79,9 +291,14 @@ func init() {
 	viper.SetDefault(FlagRPCPort, 8080)
 }
 
+// ConfigFile returns the path of the config file in the home directory.
+func ConfigFile() string {
+	return path.Join(viper.GetString(FlagHome), DefaultConfigFile)
+}
+
 func ReadConfig(allowDefaults bool) (Config, error) {
 	var cfg Config
-	cfgFile := path.Join(viper.GetString(FlagHome), DefaultConfigFile)
+	cfgFile := ConfigFile()
 	if _, err := os.Stat(cfgFile); os.IsNotExist(err) {
 		if allowDefaults {
 			viper.Unmarshal(&cfg)
@@ -110,6 +327,9 @@ func ValidateConfig(cfg *Config) error {
 	}
 
 	var hasMainBackend bool
+	var hasDefaultBackend bool
+	tags := make(map[string]bool)
+	names := make(map[string]bool)
 	for _, backend := range cfg.Backends {
 		if backend.Main && hasMainBackend {
 			return validationError("cannot have more than one main backend")
@@ -129,6 +349,22 @@ func ValidateConfig(cfg *Config) error {
 		if backend.Name == "" {
 			return validationError("backend name must be defined")
 		}
+		// backends are tracked, drained and disabled by name
+		if names[backend.Name] {
+			return validationError(fmt.Sprintf("duplicate backend name %s", backend.Name))
+		}
+		names[backend.Name] = true
+
+		if backend.InDefaultPool() {
+			hasDefaultBackend = true
+		}
+		for _, tag := range backend.Tags {
+			tags[tag] = true
+		}
+	}
+
+	if !hasDefaultBackend {
+		return validationError(fmt.Sprintf("at least one backend must be untagged or tagged %s", FullNodeTag))
 	}
 
 	if cfg.ETHConfig != nil {
@@ -137,9 +373,255 @@ func ValidateConfig(cfg *Config) error {
 		}
 	}
 
+	hedgedMethods := make(map[string]bool)
+	for _, hedge := range cfg.Hedges {
+		if hedge.Method == "" {
+			return validationError("hedge method must be defined")
+		}
+		if hedge.DelayMS <= 0 {
+			return validationError(fmt.Sprintf("hedge delay for %s must be positive", hedge.Method))
+		}
+		if hedgedMethods[hedge.Method] {
+			return validationError(fmt.Sprintf("duplicate hedge for %s", hedge.Method))
+		}
+		hedgedMethods[hedge.Method] = true
+	}
+
+	if cfg.Quorum != nil {
+		if cfg.Quorum.Threshold < 1 {
+			return validationError("quorum threshold must be at least 1")
+		}
+		if cfg.Quorum.Size < cfg.Quorum.Threshold {
+			return validationError("quorum size must be at least the quorum threshold")
+		}
+		// quorum requests run within the pool they're routed to, and any
+		// request can opt into quorum mode
+		pools := []string{""}
+		for _, route := range cfg.Routes {
+			pools = append(pools, route.Pool)
+		}
+		for _, pool := range pools {
+			if size := poolSize(cfg.Backends, pool); cfg.Quorum.Size > size {
+				name := pool
+				if name == "" {
+					name = "default"
+				}
+				return validationError(fmt.Sprintf("quorum size cannot exceed the %d backends in the %s pool", size, name))
+			}
+		}
+	}
+
+	if cfg.LogAuditorConfig != nil {
+		if cfg.LogAuditorConfig.Format != "" && cfg.LogAuditorConfig.Format != "logfmt" && cfg.LogAuditorConfig.Format != "json" {
+			return validationError("log auditor format must be logfmt or json")
+		}
+	}
+
+	if cfg.AuditConfig != nil {
+		if cfg.AuditConfig.BufferSize < 0 {
+			return validationError("audit buffer_size cannot be negative")
+		}
+		if len(cfg.AuditConfig.Sinks) == 0 {
+			return validationError("must define at least one audit sink")
+		}
+		for _, sink := range cfg.AuditConfig.Sinks {
+			if err := validateAuditSink(sink); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, redaction := range cfg.Redactions {
+		if _, err := path.Match(redaction.Method, ""); err != nil || redaction.Method == "" {
+			return validationError(fmt.Sprintf("invalid redaction method pattern: %s", redaction.Method))
+		}
+		if !RedactActions.Contains(redaction.Action) {
+			return validationError(fmt.Sprintf("redaction action for %s must be drop, hash, truncate or keep", redaction.Method))
+		}
+		if redaction.Action == RedactTruncate && redaction.MaxLength <= 0 {
+			return validationError(fmt.Sprintf("redaction max_length for %s must be positive", redaction.Method))
+		}
+		for _, param := range redaction.Params {
+			if param < 0 {
+				return validationError(fmt.Sprintf("redaction params for %s cannot be negative", redaction.Method))
+			}
+		}
+	}
+
+	if cfg.RecorderConfig != nil {
+		if cfg.RecorderConfig.Path == "" {
+			return validationError("recorder path must be defined")
+		}
+		if cfg.RecorderConfig.SampleRate < 0 || cfg.RecorderConfig.SampleRate > 1 {
+			return validationError("recorder sample_rate must be between 0 and 1")
+		}
+		if cfg.RecorderConfig.BufferSize < 0 {
+			return validationError("recorder buffer_size cannot be negative")
+		}
+	}
+
+	if cfg.TracingConfig != nil {
+		endpoint, err := url.ParseRequestURI(cfg.TracingConfig.Endpoint)
+		if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+			return validationError("tracing endpoint must be an http or https url")
+		}
+		if cfg.TracingConfig.SampleRate < 0 || cfg.TracingConfig.SampleRate > 1 {
+			return validationError("tracing sample_rate must be between 0 and 1")
+		}
+	}
+
+	if cfg.Stickiness != nil {
+		if cfg.Stickiness.Header == "" {
+			return validationError("stickiness header must be defined")
+		}
+		if cfg.Stickiness.WaitMS < 0 || cfg.Stickiness.RetentionSecs < 0 {
+			return validationError("stickiness durations cannot be negative")
+		}
+		if cfg.Stickiness.MaxClients < 0 {
+			return validationError("stickiness max_clients cannot be negative")
+		}
+	}
+
+	if cfg.TrackerConfig != nil {
+		if cfg.TrackerConfig.DropAfterSecs < 0 || cfg.TrackerConfig.RebroadcastAfterSecs < 0 || cfg.TrackerConfig.RetentionSecs < 0 {
+			return validationError("tracker durations cannot be negative")
+		}
+		for _, hook := range cfg.TrackerConfig.Webhooks {
+			if _, err := url.Parse(hook); err != nil {
+				return validationError(fmt.Sprintf("invalid webhook url: %s", hook))
+			}
+		}
+	}
+
+	if cfg.NonceConfig != nil && cfg.NonceConfig.DropAfterSecs < 0 {
+		return validationError("nonce manager drop_after_secs cannot be negative")
+	}
+
+	if cfg.CacheConfig != nil {
+		cachedMethods := make(map[string]bool)
+		for _, ttl := range cfg.CacheConfig.TTLs {
+			if !CacheableMethods.Contains(ttl.Method) {
+				return validationError(fmt.Sprintf("%s responses are not cached", ttl.Method))
+			}
+			if cachedMethods[ttl.Method] {
+				return validationError(fmt.Sprintf("duplicate cache ttl for %s", ttl.Method))
+			}
+			if ttl.NeverExpire && ttl.TTLSecs != 0 {
+				return validationError(fmt.Sprintf("cache ttl for %s cannot set both ttl_secs and never_expire", ttl.Method))
+			}
+			if !ttl.NeverExpire && ttl.TTLSecs <= 0 {
+				return validationError(fmt.Sprintf("cache ttl for %s must be positive", ttl.Method))
+			}
+			cachedMethods[ttl.Method] = true
+		}
+	}
+
+	if cfg.WarmerConfig != nil {
+		if cfg.WarmerConfig.Concurrency < 0 {
+			return validationError("warmer concurrency cannot be negative")
+		}
+		if !WarmableData.ContainsAll(cfg.WarmerConfig.Data) {
+			return validationError(fmt.Sprintf("warmer data must be one of %s, %s or %s", WarmBlocks, WarmReceipts, WarmLogs))
+		}
+		for _, addr := range cfg.WarmerConfig.WatchAddresses {
+			if !isAddress(addr) {
+				return validationError(fmt.Sprintf("invalid watch address: %s", addr))
+			}
+		}
+	}
+
+	if cfg.MetricsConfig != nil {
+		if cfg.MetricsConfig.ListenAddr == "" {
+			return validationError("metrics listen_addr must be defined")
+		}
+		if cfg.MetricsConfig.MaxHeadAgeSecs < 0 {
+			return validationError("metrics max_head_age_secs cannot be negative")
+		}
+	}
+
+	if cfg.AdminConfig != nil {
+		if cfg.AdminConfig.ListenAddr == "" {
+			return validationError("admin listen_addr must be defined")
+		}
+		if cfg.AdminConfig.AuthToken == "" {
+			return validationError("admin auth_token must be defined")
+		}
+	}
+
+	for _, route := range cfg.Routes {
+		if !tags[route.Pool] {
+			return validationError(fmt.Sprintf("no backends are tagged with route pool %s", route.Pool))
+		}
+		if len(route.Methods) == 0 && route.OlderThan == 0 {
+			return validationError("routes must define methods, older_than, or both")
+		}
+		for _, method := range route.Methods {
+			if _, err := path.Match(method, ""); err != nil {
+				return validationError(fmt.Sprintf("invalid route method pattern: %s", method))
+			}
+		}
+	}
+
 	return nil
 }
 
+func validateAuditSink(sink AuditSink) error {
+	switch sink.Type {
+	case AuditSinkFile:
+		if sink.Path == "" {
+			return validationError("file audit sink path must be defined")
+		}
+		if sink.Format != "" && sink.Format != "logfmt" && sink.Format != "json" {
+			return validationError("file audit sink format must be logfmt or json")
+		}
+		if sink.MaxSizeMB < 0 || sink.MaxAgeDays < 0 || sink.MaxBackups < 0 || sink.RotateEverySecs < 0 {
+			return validationError("file audit sink rotation settings cannot be negative")
+		}
+	case AuditSinkSyslog:
+		if !SyslogNetworks.Contains(sink.Network) {
+			return validationError("syslog audit sink network must be tcp, udp, unix or unixgram")
+		}
+		if sink.Address == "" {
+			return validationError("syslog audit sink address must be defined")
+		}
+	case AuditSinkSQLite:
+		if sink.Path == "" {
+			return validationError("sqlite audit sink path must be defined")
+		}
+	default:
+		return validationError(fmt.Sprintf("invalid audit sink type: %s", sink.Type))
+	}
+
+	return nil
+}
+
+func isAddress(addr string) bool {
+	if len(addr) != 42 || !strings.HasPrefix(addr, "0x") {
+		return false
+	}
+	_, err := hex.DecodeString(addr[2:])
+	return err == nil
+}
+
+// poolSize returns the number of backends in the pool with the given name,
+// where the empty name is the default pool.
+func poolSize(backends []Backend, pool string) int {
+	var size int
+	for _, backend := range backends {
+		if pool == "" && backend.InDefaultPool() {
+			size++
+			continue
+		}
+		for _, tag := range backend.Tags {
+			if tag == pool {
+				size++
+				break
+			}
+		}
+	}
+	return size
+}
+
 func validationError(msg string) error {
 	return errors.New(fmt.Sprintf("invalid config: %s", msg))
 }
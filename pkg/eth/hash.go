@@ -0,0 +1,26 @@
+package eth
+
+import (
+	"encoding/hex"
+	"golang.org/x/crypto/sha3"
+	"github.com/kyokan/chaind/pkg/jsonrpc"
+)
+
+func Keccak256(data ...[]byte) []byte {
+	h := sha3.NewLegacyKeccak256()
+	for _, d := range data {
+		h.Write(d)
+	}
+	return h.Sum(nil)
+}
+
+// RawTransactionHash returns the hash of a hex-encoded signed transaction,
+// as it would be returned by eth_sendRawTransaction.
+func RawTransactionHash(rawTx string) (string, error) {
+	raw, err := hex.DecodeString(jsonrpc.De0x(rawTx))
+	if err != nil {
+		return "", err
+	}
+
+	return "0x" + hex.EncodeToString(Keccak256(raw)), nil
+}
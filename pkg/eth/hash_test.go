@@ -0,0 +1,22 @@
+package eth
+
+import (
+	"encoding/hex"
+	"testing"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeccak256(t *testing.T) {
+	require.Equal(t, "c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470", hex.EncodeToString(Keccak256()))
+}
+
+func TestRawTransactionHash(t *testing.T) {
+	// transaction from EIP-155
+	raw := "0xf86c098504a817c800825208943535353535353535353535353535353535353535880de0b6b3a76400008025a028ef61340bd939bc2195fe537567866003e1a15d3c71ff63e1590620aa636276a067cbe9d8997f761aecb703304b3800ccf555c9f3dc64214b297fb1966a3b6d83"
+	hash, err := RawTransactionHash(raw)
+	require.NoError(t, err)
+	require.Equal(t, "0x33469b22e9f636356c4160a87eb19df52b7412e8eac32a4a55ffe88ea8350788", hash)
+
+	_, err = RawTransactionHash("0xzz")
+	require.Error(t, err)
+}
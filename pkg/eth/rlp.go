@@ -0,0 +1,176 @@
+package eth
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errRLPTooShort = errors.New("rlp: input too short")
+
+// RLPItem is a decoded RLP item. Lists have their children populated, while
+// strings have their value in Data. Raw always holds the item's complete
+// encoding, so that items can be re-encoded without round-tripping values.
+type RLPItem struct {
+	IsList   bool
+	Data     []byte
+	Children []*RLPItem
+	Raw      []byte
+}
+
+// DecodeRLP decodes a single RLP item that spans the whole input.
+func DecodeRLP(data []byte) (*RLPItem, error) {
+	item, rest, err := decodeRLPItem(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, errors.New("rlp: trailing data after item")
+	}
+
+	return item, nil
+}
+
+func decodeRLPItem(data []byte) (*RLPItem, []byte, error) {
+	if len(data) == 0 {
+		return nil, nil, errRLPTooShort
+	}
+
+	prefix := data[0]
+	switch {
+	case prefix < 0x80:
+		return &RLPItem{Data: data[:1], Raw: data[:1]}, data[1:], nil
+	case prefix < 0xb8:
+		return decodeRLPString(data, 1, uint64(prefix-0x80))
+	case prefix < 0xc0:
+		size, err := decodeRLPLength(data, int(prefix-0xb7))
+		if err != nil {
+			return nil, nil, err
+		}
+		return decodeRLPString(data, 1+int(prefix-0xb7), size)
+	case prefix < 0xf8:
+		return decodeRLPList(data, 1, uint64(prefix-0xc0))
+	default:
+		size, err := decodeRLPLength(data, int(prefix-0xf7))
+		if err != nil {
+			return nil, nil, err
+		}
+		return decodeRLPList(data, 1+int(prefix-0xf7), size)
+	}
+}
+
+func decodeRLPLength(data []byte, lenOfLen int) (uint64, error) {
+	if len(data) < 1+lenOfLen {
+		return 0, errRLPTooShort
+	}
+
+	var buf [8]byte
+	copy(buf[8-lenOfLen:], data[1:1+lenOfLen])
+	return binary.BigEndian.Uint64(buf[:]), nil
+}
+
+func decodeRLPString(data []byte, offset int, size uint64) (*RLPItem, []byte, error) {
+	end, err := rlpEnd(data, offset, size)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &RLPItem{
+		Data: data[offset:end],
+		Raw:  data[:end],
+	}, data[end:], nil
+}
+
+func decodeRLPList(data []byte, offset int, size uint64) (*RLPItem, []byte, error) {
+	end, err := rlpEnd(data, offset, size)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	item := &RLPItem{
+		IsList: true,
+		Raw:    data[:end],
+	}
+	payload := data[offset:end]
+	for len(payload) > 0 {
+		child, rest, err := decodeRLPItem(payload)
+		if err != nil {
+			return nil, nil, err
+		}
+		item.Children = append(item.Children, child)
+		payload = rest
+	}
+
+	return item, data[end:], nil
+}
+
+// rlpEnd returns the offset of the end of an item whose payload of the given
+// size starts at offset. The size is compared to the remaining input rather
+// than added to the offset, since a long-form length can be up to 2^64-1.
+func rlpEnd(data []byte, offset int, size uint64) (uint64, error) {
+	if size > uint64(len(data)-offset) {
+		return 0, errRLPTooShort
+	}
+
+	return uint64(offset) + size, nil
+}
+
+// EncodeRLPString returns the RLP encoding of a byte string.
+func EncodeRLPString(data []byte) []byte {
+	if len(data) == 1 && data[0] < 0x80 {
+		return data
+	}
+
+	return append(rlpHeader(0x80, uint64(len(data))), data...)
+}
+
+// EncodeRLPUint returns the RLP encoding of an unsigned integer.
+func EncodeRLPUint(val uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], val)
+	i := 0
+	for i < len(buf) && buf[i] == 0 {
+		i++
+	}
+
+	return EncodeRLPString(buf[i:])
+}
+
+// EncodeRLPList returns the RLP encoding of a list whose items are already
+// encoded.
+func EncodeRLPList(items ...[]byte) []byte {
+	var payload []byte
+	for _, item := range items {
+		payload = append(payload, item...)
+	}
+
+	return append(rlpHeader(0xc0, uint64(len(payload))), payload...)
+}
+
+func rlpHeader(base byte, size uint64) []byte {
+	if size < 56 {
+		return []byte{base + byte(size)}
+	}
+
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], size)
+	i := 0
+	for i < len(buf) && buf[i] == 0 {
+		i++
+	}
+
+	return append([]byte{base + 55 + byte(8-i)}, buf[i:]...)
+}
+
+// Uint64 interprets the item as a big-endian unsigned integer.
+func (i *RLPItem) Uint64() (uint64, error) {
+	if i.IsList {
+		return 0, errors.New("rlp: expected string, got list")
+	}
+	if len(i.Data) > 8 {
+		return 0, errors.New("rlp: integer overflows uint64")
+	}
+
+	var buf [8]byte
+	copy(buf[8-len(i.Data):], i.Data)
+	return binary.BigEndian.Uint64(buf[:]), nil
+}
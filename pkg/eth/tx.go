@@ -0,0 +1,169 @@
+package eth
+
+import (
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/kyokan/chaind/pkg/jsonrpc"
+)
+
+// Transaction holds the fields of a signed transaction that chaind cares
+// about.
+type Transaction struct {
+	Hash  string
+	From  string
+	Nonce uint64
+}
+
+// DecodeRawTransaction decodes a hex-encoded signed transaction, as passed to
+// eth_sendRawTransaction, and recovers its sender. Legacy, EIP-155 and EIP-2718
+// typed transactions are supported.
+func DecodeRawTransaction(rawTx string) (*Transaction, error) {
+	raw, err := hex.DecodeString(jsonrpc.De0x(rawTx))
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) == 0 {
+		return nil, errors.New("empty transaction")
+	}
+
+	var sigHash []byte
+	var nonce, v *RLPItem
+	var recID byte
+	var r, s []byte
+	if raw[0] >= 0xc0 {
+		sigHash, nonce, v, r, s, err = decodeLegacyTx(raw)
+		if err != nil {
+			return nil, err
+		}
+		recID, err = legacyRecoveryID(v)
+	} else {
+		sigHash, nonce, v, r, s, err = decodeTypedTx(raw)
+		if err != nil {
+			return nil, err
+		}
+		var parity uint64
+		parity, err = v.Uint64()
+		recID = byte(parity)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nonceVal, err := nonce.Uint64()
+	if err != nil {
+		return nil, err
+	}
+	from, err := recoverAddress(sigHash, r, s, recID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Transaction{
+		Hash:  "0x" + hex.EncodeToString(Keccak256(raw)),
+		From:  from,
+		Nonce: nonceVal,
+	}, nil
+}
+
+// decodeLegacyTx decodes [nonce, gasPrice, gas, to, value, data, v, r, s].
+func decodeLegacyTx(raw []byte) ([]byte, *RLPItem, *RLPItem, []byte, []byte, error) {
+	item, err := DecodeRLP(raw)
+	if err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+	if !item.IsList || len(item.Children) != 9 {
+		return nil, nil, nil, nil, nil, errors.New("invalid legacy transaction")
+	}
+
+	fields := item.Children
+	v := fields[6]
+	var signed [][]byte
+	for _, field := range fields[:6] {
+		signed = append(signed, field.Raw)
+	}
+
+	vVal, err := v.Uint64()
+	if err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+	// EIP-155 transactions also sign over the chain ID
+	if vVal >= 35 {
+		chainID := (vVal - 35) / 2
+		signed = append(signed, EncodeRLPUint(chainID), EncodeRLPUint(0), EncodeRLPUint(0))
+	}
+
+	return Keccak256(EncodeRLPList(signed...)), fields[0], v, fields[7].Data, fields[8].Data, nil
+}
+
+// decodeTypedTx decodes an EIP-2718 envelope. Every typed transaction puts the
+// nonce second and the signature last, and signs over its type byte followed
+// by the encoding of the remaining fields.
+func decodeTypedTx(raw []byte) ([]byte, *RLPItem, *RLPItem, []byte, []byte, error) {
+	txType := raw[0]
+	item, err := DecodeRLP(raw[1:])
+	if err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+	if !item.IsList {
+		return nil, nil, nil, nil, nil, errors.New("invalid typed transaction")
+	}
+	// EIP-4844 transactions are submitted wrapped alongside their blobs
+	if len(item.Children) > 0 && item.Children[0].IsList {
+		item = item.Children[0]
+	}
+
+	fields := item.Children
+	if len(fields) < 5 {
+		return nil, nil, nil, nil, nil, errors.New("invalid typed transaction")
+	}
+
+	sigStart := len(fields) - 3
+	var signed [][]byte
+	for _, field := range fields[:sigStart] {
+		signed = append(signed, field.Raw)
+	}
+	sigHash := Keccak256([]byte{txType}, EncodeRLPList(signed...))
+	return sigHash, fields[1], fields[sigStart], fields[sigStart+1].Data, fields[sigStart+2].Data, nil
+}
+
+func legacyRecoveryID(v *RLPItem) (byte, error) {
+	vVal, err := v.Uint64()
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case vVal == 27 || vVal == 28:
+		return byte(vVal - 27), nil
+	case vVal >= 35:
+		return byte((vVal - 35) % 2), nil
+	default:
+		return 0, errors.New("invalid signature v value")
+	}
+}
+
+func recoverAddress(sigHash []byte, r []byte, s []byte, recID byte) (string, error) {
+	if len(r) > 32 || len(s) > 32 || recID > 1 {
+		return "", errors.New("invalid signature")
+	}
+
+	// btcec expects a compact signature: a header byte followed by
+	// zero-padded r and s values
+	sig := make([]byte, 65)
+	sig[0] = 27 + recID
+	copy(sig[33-len(r):33], r)
+	copy(sig[65-len(s):65], s)
+	if new(big.Int).SetBytes(sig[1:33]).Sign() == 0 {
+		return "", errors.New("invalid signature")
+	}
+
+	pub, _, err := btcec.RecoverCompact(btcec.S256(), sig, sigHash)
+	if err != nil {
+		return "", err
+	}
+
+	addr := Keccak256(pub.SerializeUncompressed()[1:])[12:]
+	return "0x" + hex.EncodeToString(addr), nil
+}
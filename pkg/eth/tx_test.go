@@ -0,0 +1,102 @@
+package eth
+
+import (
+	"encoding/hex"
+	"testing"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/stretchr/testify/require"
+)
+
+// key and address from the EIP-155 example
+const testKey = "4646464646464646464646464646464646464646464646464646464646464646"
+const testAddr = "0x9d8a62f656a8d1615c1294fd71e9cfb3e4855a4f"
+
+func TestDecodeRawTransaction_EIP155(t *testing.T) {
+	raw := "0xf86c098504a817c800825208943535353535353535353535353535353535353535880de0b6b3a76400008025a028ef61340bd939bc2195fe537567866003e1a15d3c71ff63e1590620aa636276a067cbe9d8997f761aecb703304b3800ccf555c9f3dc64214b297fb1966a3b6d83"
+	tx, err := DecodeRawTransaction(raw)
+	require.NoError(t, err)
+	require.Equal(t, testAddr, tx.From)
+	require.Equal(t, uint64(9), tx.Nonce)
+	require.Equal(t, "0x33469b22e9f636356c4160a87eb19df52b7412e8eac32a4a55ffe88ea8350788", tx.Hash)
+}
+
+func TestDecodeRawTransaction_Typed(t *testing.T) {
+	keyBytes, err := hex.DecodeString(testKey)
+	require.NoError(t, err)
+	key, _ := btcec.PrivKeyFromBytes(btcec.S256(), keyBytes)
+
+	to, err := hex.DecodeString("3535353535353535353535353535353535353535")
+	require.NoError(t, err)
+	// EIP-1559: [chainId, nonce, maxPriorityFee, maxFee, gas, to, value, data, accessList]
+	unsigned := [][]byte{
+		EncodeRLPUint(1),
+		EncodeRLPUint(300),
+		EncodeRLPUint(1000000000),
+		EncodeRLPUint(20000000000),
+		EncodeRLPUint(21000),
+		EncodeRLPString(to),
+		EncodeRLPUint(1),
+		EncodeRLPString(nil),
+		EncodeRLPList(),
+	}
+	sigHash := Keccak256([]byte{0x02}, EncodeRLPList(unsigned...))
+	sig, err := btcec.SignCompact(btcec.S256(), key, sigHash, false)
+	require.NoError(t, err)
+
+	signed := append(unsigned,
+		EncodeRLPUint(uint64(sig[0]-27)),
+		EncodeRLPString(trimZeros(sig[1:33])),
+		EncodeRLPString(trimZeros(sig[33:65])),
+	)
+	raw := append([]byte{0x02}, EncodeRLPList(signed...)...)
+
+	tx, err := DecodeRawTransaction(hex.EncodeToString(raw))
+	require.NoError(t, err)
+	require.Equal(t, testAddr, tx.From)
+	require.Equal(t, uint64(300), tx.Nonce)
+}
+
+func TestDecodeRawTransaction_Invalid(t *testing.T) {
+	_, err := DecodeRawTransaction("0x")
+	require.Error(t, err)
+	_, err = DecodeRawTransaction("0xc0")
+	require.Error(t, err)
+	_, err = DecodeRawTransaction("0xf86c09")
+	require.Error(t, err)
+
+	// long-form lengths that overflow when added to the offset
+	for _, raw := range []string{
+		"0xbfffffffffffffffff",
+		"0xffffffffffffffffff",
+		"0xc9bfffffffffffffffff",
+		"0xf8ffffffffffffffffff",
+	} {
+		_, err = DecodeRawTransaction(raw)
+		require.Error(t, err, raw)
+	}
+}
+
+func TestRLP_RoundTrip(t *testing.T) {
+	long := make([]byte, 60)
+	encoded := EncodeRLPList(EncodeRLPUint(0), EncodeRLPUint(127), EncodeRLPUint(1024), EncodeRLPString(long), EncodeRLPList(EncodeRLPString([]byte("dog"))))
+	item, err := DecodeRLP(encoded)
+	require.NoError(t, err)
+	require.True(t, item.IsList)
+	require.Len(t, item.Children, 5)
+
+	for i, expected := range []uint64{0, 127, 1024} {
+		val, err := item.Children[i].Uint64()
+		require.NoError(t, err)
+		require.Equal(t, expected, val)
+	}
+	require.Equal(t, long, item.Children[3].Data)
+	require.Equal(t, []byte("dog"), item.Children[4].Children[0].Data)
+	require.Equal(t, encoded, item.Raw)
+}
+
+func trimZeros(b []byte) []byte {
+	for len(b) > 0 && b[0] == 0 {
+		b = b[1:]
+	}
+	return b
+}
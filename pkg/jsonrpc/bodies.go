@@ -5,6 +5,7 @@ import (
 )
 
 const Version = "2.0"
+const MethodNotFoundCode = -32601
 const InternalError = "{\"jsonrpc\":\"2.0\",\"error\":{\"code\":-32603,\"message\":\"internal error\"}}"
 
 type ErrorResponse struct {
@@ -29,4 +30,5 @@ type Response struct {
 	Jsonrpc string          `json:"jsonrpc"`
 	ID      interface{}     `json:"id"`
 	Result  json.RawMessage `json:"result"`
+	Error   *ErrorData      `json:"error,omitempty"`
 }
@@ -5,11 +5,18 @@ import (
 	"time"
 	"encoding/json"
 	"bytes"
+	"fmt"
 	"io/ioutil"
 	"sync/atomic"
 	"github.com/kyokan/chaind/pkg"
+	"errors"
 )
 
+// ErrBatchUnsupported is returned by BatchCall when the server answers a
+// batch request with a single response, which is how servers that don't
+// support batches reject them.
+var ErrBatchUnsupported = errors.New("batch requests are not supported")
+
 type Client struct {
 	url    string
 	client *http.Client
@@ -40,30 +47,97 @@ func (c *Client) Call(method string, params ... interface{}) (*Response, error)
 		Method:  method,
 		Params:  serBody,
 	}
-	serReq, err := json.Marshal(req)
-	if err != nil {
+
+	var rpcRes Response
+	if err := c.post(req, &rpcRes); err != nil {
+		return nil, err
+	}
+
+	return &rpcRes, nil
+}
+
+type BatchElem struct {
+	Method string
+	Params []interface{}
+}
+
+// BatchCall sends the calls to the server in a single batch request. The
+// responses are returned in the same order as the calls.
+func (c *Client) BatchCall(elems []BatchElem) ([]*Response, error) {
+	reqs := make([]*Request, len(elems))
+	ids := make(map[int64]int)
+	for i, elem := range elems {
+		params := elem.Params
+		if params == nil {
+			params = []interface{}{}
+		}
+		serBody, err := json.Marshal(params)
+		if err != nil {
+			return nil, err
+		}
+
+		id := atomic.AddInt64(&c.lastId, 1)
+		ids[id] = i
+		reqs[i] = &Request{
+			Version: Version,
+			ID:      id,
+			Method:  elem.Method,
+			Params:  serBody,
+		}
+	}
+
+	var raw json.RawMessage
+	if err := c.post(reqs, &raw); err != nil {
+		return nil, err
+	}
+	raw = bytes.TrimSpace(raw)
+	if len(raw) > 0 && raw[0] == '{' {
+		return nil, ErrBatchUnsupported
+	}
+	var rpcRes []*Response
+	if err := json.Unmarshal(raw, &rpcRes); err != nil {
 		return nil, err
 	}
 
+	out := make([]*Response, len(elems))
+	for _, res := range rpcRes {
+		// IDs are unmarshaled as float64s
+		id, ok := res.ID.(float64)
+		if !ok {
+			continue
+		}
+		if i, ok := ids[int64(id)]; ok {
+			out[i] = res
+		}
+	}
+	for i, res := range out {
+		if res == nil {
+			return nil, fmt.Errorf("no response to batched call %d", i)
+		}
+	}
+	return out, nil
+}
+
+func (c *Client) post(body interface{}, out interface{}) error {
+	serReq, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
 	httpReq, err := http.NewRequest("POST", c.url, bytes.NewReader(serReq))
-	httpReq.Header.Set("Content-Type", "application/json")
 	if err != nil {
-		return nil, err
+		return err
 	}
+	httpReq.Header.Set("Content-Type", "application/json")
 	res, err := c.client.Do(httpReq)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer res.Body.Close()
 	resBody, err := ioutil.ReadAll(res.Body)
 	if err != nil {
-		return nil, err
-	}
-	var rpcRes Response
-	err = json.Unmarshal(resBody, &rpcRes)
-	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return &rpcRes, nil
+	return json.Unmarshal(resBody, out)
 }
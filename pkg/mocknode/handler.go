@@ -0,0 +1,498 @@
+package mocknode
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+	"github.com/kyokan/chaind/pkg/jsonrpc"
+)
+
+const (
+	invalidParamsCode = -32602
+	internalErrorCode = -32603
+)
+
+var errMethodNotFound = &jsonrpc.ErrorData{Code: jsonrpc.MethodNotFoundCode, Message: "method not found"}
+
+type rpcError struct {
+	data *jsonrpc.ErrorData
+}
+
+func (e *rpcError) Error() string {
+	return e.data.Message
+}
+
+func invalidParams(msg string) error {
+	return &rpcError{&jsonrpc.ErrorData{Code: invalidParamsCode, Message: msg}}
+}
+
+// ServeHTTP answers single and batch JSON-RPC requests.
+func (n *Node) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		res.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	faults := n.Faults()
+	if faults.Latency > 0 {
+		time.Sleep(faults.Latency)
+	}
+	if faults.HTTPStatus != 0 && !isControlRequest(body) {
+		res.WriteHeader(faults.HTTPStatus)
+		return
+	}
+
+	var out interface{}
+	if strings.HasPrefix(strings.TrimSpace(string(body)), "[") {
+		var rpcReqs []jsonrpc.Request
+		if err := json.Unmarshal(body, &rpcReqs); err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		responses := make([]*jsonrpc.Response, len(rpcReqs))
+		for i := range rpcReqs {
+			responses[i] = n.handle(&rpcReqs[i])
+		}
+		out = responses
+	} else {
+		var rpcReq jsonrpc.Request
+		if err := json.Unmarshal(body, &rpcReq); err != nil {
+			res.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		out = n.handle(&rpcReq)
+	}
+
+	resBody, err := json.Marshal(out)
+	if err != nil {
+		res.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.Write(resBody)
+}
+
+// isControlRequest returns true for single mock_* requests, which are
+// answered even while an HTTP status fault is set so that it can be cleared.
+func isControlRequest(body []byte) bool {
+	var rpcReq jsonrpc.Request
+	return json.Unmarshal(body, &rpcReq) == nil && strings.HasPrefix(rpcReq.Method, "mock_")
+}
+
+func (n *Node) handle(rpcReq *jsonrpc.Request) *jsonrpc.Response {
+	res := &jsonrpc.Response{
+		Jsonrpc: jsonrpc.Version,
+		ID:      rpcReq.ID,
+	}
+
+	for _, method := range n.Faults().ErrorMethods {
+		if method == rpcReq.Method {
+			res.Error = &jsonrpc.ErrorData{Code: internalErrorCode, Message: "injected fault"}
+			return res
+		}
+	}
+
+	var params []json.RawMessage
+	if len(rpcReq.Params) > 0 {
+		if err := json.Unmarshal(rpcReq.Params, &params); err != nil {
+			res.Error = &jsonrpc.ErrorData{Code: invalidParamsCode, Message: "params must be an array"}
+			return res
+		}
+	}
+
+	result, err := n.call(rpcReq.Method, params)
+	if err != nil {
+		if rpcErr, ok := err.(*rpcError); ok {
+			res.Error = rpcErr.data
+		} else {
+			res.Error = &jsonrpc.ErrorData{Code: internalErrorCode, Message: err.Error()}
+		}
+		return res
+	}
+	res.Result, err = json.Marshal(result)
+	if err != nil {
+		res.Error = &jsonrpc.ErrorData{Code: internalErrorCode, Message: err.Error()}
+	}
+	return res
+}
+
+func (n *Node) call(method string, params []json.RawMessage) (interface{}, error) {
+	switch method {
+	case "mock_mine":
+		count := 1
+		if len(params) > 0 {
+			if err := json.Unmarshal(params[0], &count); err != nil {
+				return nil, invalidParams("count must be a number")
+			}
+		}
+		return jsonrpc.Uint642Hex(n.Mine(count)), nil
+	case "mock_reorg":
+		var depth int
+		if len(params) == 0 || json.Unmarshal(params[0], &depth) != nil {
+			return nil, invalidParams("depth must be a number")
+		}
+		if err := n.Reorg(depth); err != nil {
+			return nil, invalidParams(err.Error())
+		}
+		return jsonrpc.Uint642Hex(n.Head()), nil
+	case "mock_setFaults":
+		var faults struct {
+			LatencyMS    int      `json:"latency_ms"`
+			HTTPStatus   int      `json:"http_status"`
+			ErrorMethods []string `json:"error_methods"`
+			Syncing      bool     `json:"syncing"`
+		}
+		if len(params) > 0 {
+			if err := json.Unmarshal(params[0], &faults); err != nil {
+				return nil, invalidParams(err.Error())
+			}
+		}
+		n.SetFaults(Faults{
+			Latency:      time.Duration(faults.LatencyMS) * time.Millisecond,
+			HTTPStatus:   faults.HTTPStatus,
+			ErrorMethods: faults.ErrorMethods,
+			Syncing:      faults.Syncing,
+		})
+		return true, nil
+	case "mock_setBalance":
+		addr, err := stringParam(params, 0)
+		if err != nil {
+			return nil, err
+		}
+		balance, err := stringParam(params, 1)
+		if err != nil {
+			return nil, err
+		}
+		wei, err := jsonrpc.Hex2Big(balance)
+		if err != nil {
+			return nil, invalidParams("balance must be a hex number")
+		}
+		n.SetBalance(strings.ToLower(addr), wei)
+		return true, nil
+	case "web3_clientVersion":
+		return "chaind-mocknode", nil
+	case "net_version":
+		return big.NewInt(int64(n.chainID)).String(), nil
+	case "eth_chainId":
+		return jsonrpc.Uint642Hex(n.chainID), nil
+	case "eth_gasPrice":
+		return "0x3b9aca00", nil
+	case "eth_syncing":
+		return n.syncing(), nil
+	case "eth_blockNumber":
+		n.mtx.RLock()
+		defer n.mtx.RUnlock()
+		return jsonrpc.Uint642Hex(n.reportedHead()), nil
+	case "eth_getBlockByNumber":
+		return n.getBlockByNumber(params)
+	case "eth_getBlockByHash":
+		return n.getBlockByHash(params)
+	case "eth_getTransactionByHash":
+		return n.getTransaction(params, false)
+	case "eth_getTransactionReceipt":
+		return n.getTransaction(params, true)
+	case "eth_getBlockReceipts", "parity_getBlockReceipts":
+		return n.getBlockReceipts(params)
+	case "eth_getLogs":
+		return n.getLogs(params)
+	case "eth_getBalance":
+		return n.getBalance(params)
+	case "eth_getTransactionCount":
+		return n.getTransactionCount(params)
+	case "eth_getCode":
+		return "0x", nil
+	case "eth_sendRawTransaction":
+		rawTx, err := stringParam(params, 0)
+		if err != nil {
+			return nil, err
+		}
+		return n.SendRawTransaction(rawTx)
+	default:
+		return nil, &rpcError{errMethodNotFound}
+	}
+}
+
+func (n *Node) syncing() interface{} {
+	n.mtx.RLock()
+	defer n.mtx.RUnlock()
+	if !n.faults.Syncing {
+		return false
+	}
+
+	return map[string]string{
+		"startingBlock": jsonrpc.Uint642Hex(0),
+		"currentBlock":  jsonrpc.Uint642Hex(n.reportedHead()),
+		"highestBlock":  jsonrpc.Uint642Hex(n.head()),
+	}
+}
+
+func (n *Node) getBlockByNumber(params []json.RawMessage) (interface{}, error) {
+	tag, err := stringParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	full := boolParam(params, 1)
+
+	n.mtx.RLock()
+	defer n.mtx.RUnlock()
+	b, err := n.blockAt(tag)
+	if err != nil || b == nil {
+		return nil, err
+	}
+	return b.json(full), nil
+}
+
+func (n *Node) getBlockByHash(params []json.RawMessage) (interface{}, error) {
+	hash, err := stringParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	full := boolParam(params, 1)
+
+	n.mtx.RLock()
+	defer n.mtx.RUnlock()
+	for _, b := range n.blocks {
+		if b.hash == hash {
+			return b.json(full), nil
+		}
+	}
+	return nil, nil
+}
+
+func (n *Node) getTransaction(params []json.RawMessage, receipt bool) (interface{}, error) {
+	hash, err := stringParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	n.mtx.RLock()
+	defer n.mtx.RUnlock()
+	t := n.txs[hash]
+	if t == nil || t.block.number > n.reportedHead() {
+		return nil, nil
+	}
+	if receipt {
+		return t.receiptJSON(), nil
+	}
+	return t.json(), nil
+}
+
+func (n *Node) getBlockReceipts(params []json.RawMessage) (interface{}, error) {
+	tag, err := stringParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	n.mtx.RLock()
+	defer n.mtx.RUnlock()
+	b, err := n.blockAt(tag)
+	if err != nil || b == nil {
+		return nil, err
+	}
+	receipts := make([]interface{}, len(b.txs))
+	for i, t := range b.txs {
+		receipts[i] = t.receiptJSON()
+	}
+	return receipts, nil
+}
+
+func (n *Node) getLogs(params []json.RawMessage) (interface{}, error) {
+	var filter struct {
+		FromBlock string `json:"fromBlock"`
+		ToBlock   string `json:"toBlock"`
+		BlockHash string `json:"blockHash"`
+		Address   string `json:"address"`
+	}
+	if len(params) == 0 || json.Unmarshal(params[0], &filter) != nil {
+		return nil, invalidParams("invalid filter")
+	}
+
+	n.mtx.RLock()
+	defer n.mtx.RUnlock()
+	var blocks []*block
+	if filter.BlockHash != "" {
+		for _, b := range n.blocks {
+			if b.hash == filter.BlockHash {
+				blocks = append(blocks, b)
+			}
+		}
+	} else {
+		from, err := n.blockAt(orLatest(filter.FromBlock))
+		if err != nil {
+			return nil, err
+		}
+		to, err := n.blockAt(orLatest(filter.ToBlock))
+		if err != nil {
+			return nil, err
+		}
+		if from == nil || to == nil || from.number > to.number {
+			return []interface{}{}, nil
+		}
+		blocks = n.blocks[from.number : to.number+1]
+	}
+
+	logs := make([]interface{}, 0)
+	for _, b := range blocks {
+		for _, t := range b.txs {
+			if filter.Address != "" && !strings.EqualFold(filter.Address, t.to) {
+				continue
+			}
+			logs = append(logs, t.logJSON())
+		}
+	}
+	return logs, nil
+}
+
+func (n *Node) getBalance(params []json.RawMessage) (interface{}, error) {
+	addr, err := stringParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	n.mtx.RLock()
+	defer n.mtx.RUnlock()
+	balance := n.balances[strings.ToLower(addr)]
+	if balance == nil {
+		return "0x0", nil
+	}
+	return "0x" + balance.Text(16), nil
+}
+
+func (n *Node) getTransactionCount(params []json.RawMessage) (interface{}, error) {
+	addr, err := stringParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	tag, _ := stringParam(params, 1)
+
+	n.mtx.RLock()
+	defer n.mtx.RUnlock()
+	count := n.nonces[strings.ToLower(addr)]
+	if tag == "pending" {
+		for _, t := range n.pending {
+			if strings.EqualFold(t.from, addr) && t.nonce >= count {
+				count = t.nonce + 1
+			}
+		}
+	}
+	return jsonrpc.Uint642Hex(count), nil
+}
+
+// blockAt returns the block for a block number or tag, or nil if it doesn't
+// exist yet.
+func (n *Node) blockAt(tag string) (*block, error) {
+	var number uint64
+	switch tag {
+	case "latest", "pending":
+		number = n.reportedHead()
+	case "earliest":
+		number = 0
+	default:
+		var err error
+		number, err = jsonrpc.Hex2Uint64(tag)
+		if err != nil {
+			return nil, invalidParams("invalid block number")
+		}
+	}
+
+	if number > n.reportedHead() {
+		return nil, nil
+	}
+	return n.blocks[number], nil
+}
+
+func (b *block) json(full bool) map[string]interface{} {
+	txs := make([]interface{}, len(b.txs))
+	for i, t := range b.txs {
+		if full {
+			txs[i] = t.json()
+		} else {
+			txs[i] = t.hash
+		}
+	}
+
+	return map[string]interface{}{
+		"number":       jsonrpc.Uint642Hex(b.number),
+		"hash":         b.hash,
+		"parentHash":   b.parentHash,
+		"timestamp":    jsonrpc.Uint642Hex(b.timestamp),
+		"miner":        address("miner"),
+		"gasLimit":     "0x7a1200",
+		"gasUsed":      jsonrpc.Uint642Hex(uint64(len(b.txs)) * 21000),
+		"transactions": txs,
+	}
+}
+
+func (t *tx) json() map[string]interface{} {
+	return map[string]interface{}{
+		"hash":             t.hash,
+		"from":             t.from,
+		"to":               t.to,
+		"nonce":            jsonrpc.Uint642Hex(t.nonce),
+		"value":            jsonrpc.Uint642Hex(t.value),
+		"gas":              "0x5208",
+		"gasPrice":         "0x3b9aca00",
+		"input":            "0x",
+		"blockHash":        t.block.hash,
+		"blockNumber":      jsonrpc.Uint642Hex(t.block.number),
+		"transactionIndex": jsonrpc.Uint642Hex(uint64(t.index)),
+	}
+}
+
+func (t *tx) receiptJSON() map[string]interface{} {
+	return map[string]interface{}{
+		"transactionHash":   t.hash,
+		"transactionIndex":  jsonrpc.Uint642Hex(uint64(t.index)),
+		"blockHash":         t.block.hash,
+		"blockNumber":       jsonrpc.Uint642Hex(t.block.number),
+		"from":              t.from,
+		"to":                t.to,
+		"gasUsed":           "0x5208",
+		"cumulativeGasUsed": jsonrpc.Uint642Hex(uint64(t.index+1) * 21000),
+		"status":            "0x1",
+		"logs":              []interface{}{t.logJSON()},
+	}
+}
+
+// logJSON returns the single log every transaction emits, from the
+// transaction's recipient.
+func (t *tx) logJSON() map[string]interface{} {
+	return map[string]interface{}{
+		"address":          t.to,
+		"topics":           []string{hash("topic", t.from)},
+		"data":             "0x",
+		"blockHash":        t.block.hash,
+		"blockNumber":      jsonrpc.Uint642Hex(t.block.number),
+		"transactionHash":  t.hash,
+		"transactionIndex": jsonrpc.Uint642Hex(uint64(t.index)),
+		"logIndex":         jsonrpc.Uint642Hex(uint64(t.index)),
+		"removed":          false,
+	}
+}
+
+func stringParam(params []json.RawMessage, i int) (string, error) {
+	var str string
+	if i >= len(params) || json.Unmarshal(params[i], &str) != nil {
+		return "", invalidParams("missing or invalid params")
+	}
+	return str, nil
+}
+
+func boolParam(params []json.RawMessage, i int) bool {
+	var b bool
+	if i < len(params) {
+		json.Unmarshal(params[i], &b)
+	}
+	return b
+}
+
+func orLatest(tag string) string {
+	if tag == "" {
+		return "latest"
+	}
+	return tag
+}
@@ -0,0 +1,302 @@
+package mocknode
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+	"github.com/inconshreveable/log15"
+	"github.com/kyokan/chaind/pkg/eth"
+	"github.com/kyokan/chaind/pkg/log"
+)
+
+const (
+	DefaultChainID     = 1337
+	DefaultTxsPerBlock = 2
+)
+
+// Config describes the chain a Node simulates. Blocks are only mined on a
+// timer if BlockTime is set; otherwise they are mined with Mine.
+type Config struct {
+	ChainID       uint64
+	InitialHeight uint64
+	TxsPerBlock   int
+	BlockTime     time.Duration
+}
+
+// Faults are injected into every response while they are set.
+type Faults struct {
+	// Latency delays every response.
+	Latency time.Duration
+	// HTTPStatus, if set, is returned instead of a JSON-RPC response.
+	HTTPStatus int
+	// ErrorMethods are answered with an internal JSON-RPC error.
+	ErrorMethods []string
+	// Syncing makes eth_syncing report that the node is syncing, and
+	// freezes the reported head.
+	Syncing bool
+}
+
+type block struct {
+	number     uint64
+	hash       string
+	parentHash string
+	timestamp  uint64
+	txs        []*tx
+}
+
+type tx struct {
+	hash  string
+	from  string
+	to    string
+	nonce uint64
+	value uint64
+	index int
+	block *block
+}
+
+// Node simulates an Ethereum node with a deterministic chain. It serves
+// JSON-RPC over HTTP, and its faults can be changed while it runs, either
+// directly or with the mock_* JSON-RPC methods.
+type Node struct {
+	chainID     uint64
+	txsPerBlock int
+	blockTime   time.Duration
+	blocks      []*block
+	txs         map[string]*tx
+	pending     []*tx
+	nonces      map[string]uint64
+	balances    map[string]*big.Int
+	reorgs      uint64
+	faults      Faults
+	syncHeight  uint64
+	mtx         sync.RWMutex
+	quitChan    chan bool
+	logger      log15.Logger
+}
+
+func New(cfg Config) *Node {
+	if cfg.ChainID == 0 {
+		cfg.ChainID = DefaultChainID
+	}
+	if cfg.TxsPerBlock == 0 {
+		cfg.TxsPerBlock = DefaultTxsPerBlock
+	}
+
+	n := &Node{
+		chainID:     cfg.ChainID,
+		txsPerBlock: cfg.TxsPerBlock,
+		blockTime:   cfg.BlockTime,
+		txs:         make(map[string]*tx),
+		nonces:      make(map[string]uint64),
+		balances:    make(map[string]*big.Int),
+		quitChan:    make(chan bool),
+		logger:      log.NewLog("mocknode"),
+	}
+	for i := uint64(0); i <= cfg.InitialHeight; i++ {
+		n.mine()
+	}
+	return n
+}
+
+// Start mines a block every BlockTime, if set.
+func (n *Node) Start() error {
+	if n.blockTime == 0 {
+		return nil
+	}
+
+	go func() {
+		tick := time.NewTicker(n.blockTime)
+		defer tick.Stop()
+		for {
+			select {
+			case <-tick.C:
+				number := n.Mine(1)
+				n.logger.Debug("mined block", "number", number)
+			case <-n.quitChan:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (n *Node) Stop() error {
+	if n.blockTime != 0 {
+		n.quitChan <- true
+	}
+	return nil
+}
+
+// Head returns the number of the latest block.
+func (n *Node) Head() uint64 {
+	n.mtx.RLock()
+	defer n.mtx.RUnlock()
+	return n.head()
+}
+
+// Mine adds count blocks to the chain and returns the new head.
+func (n *Node) Mine(count int) uint64 {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	for i := 0; i < count; i++ {
+		n.mine()
+	}
+	return n.head()
+}
+
+// Reorg replaces the latest depth blocks with blocks that have different
+// hashes and transactions. Transactions sent with eth_sendRawTransaction
+// that were in the replaced blocks are mined again in the first new block.
+func (n *Node) Reorg(depth int) error {
+	if depth < 0 {
+		return errors.New("reorg depth cannot be negative")
+	}
+
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	if depth > len(n.blocks)-1 {
+		depth = len(n.blocks) - 1
+	}
+
+	n.reorgs++
+	orphaned := n.blocks[len(n.blocks)-depth:]
+	n.blocks = n.blocks[:len(n.blocks)-depth]
+	var resent []*tx
+	for _, b := range orphaned {
+		for _, t := range b.txs {
+			delete(n.txs, t.hash)
+			if t.index >= n.txsPerBlock {
+				t.block = nil
+				resent = append(resent, t)
+			} else {
+				n.nonces[t.from]--
+			}
+		}
+	}
+	n.pending = append(resent, n.pending...)
+	for i := 0; i < depth; i++ {
+		n.mine()
+	}
+	return nil
+}
+
+// SetFaults replaces the node's faults. Pass the zero value to clear them.
+func (n *Node) SetFaults(faults Faults) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	if faults.Syncing && !n.faults.Syncing {
+		n.syncHeight = n.head()
+	}
+	n.faults = faults
+}
+
+func (n *Node) Faults() Faults {
+	n.mtx.RLock()
+	defer n.mtx.RUnlock()
+	return n.faults
+}
+
+// SetBalance sets the balance of an address in wei.
+func (n *Node) SetBalance(addr string, balance *big.Int) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	n.balances[addr] = balance
+}
+
+// SendRawTransaction adds a signed transaction to the pending pool, to be
+// included in the next block, and returns its hash.
+func (n *Node) SendRawTransaction(rawTx string) (string, error) {
+	decoded, err := eth.DecodeRawTransaction(rawTx)
+	if err != nil {
+		return "", err
+	}
+
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+	if _, ok := n.txs[decoded.Hash]; ok {
+		return "", errors.New("already known")
+	}
+	for _, t := range n.pending {
+		if t.hash == decoded.Hash {
+			return "", errors.New("already known")
+		}
+	}
+	if decoded.Nonce < n.nonces[decoded.From] {
+		return "", errors.New("nonce too low")
+	}
+
+	n.pending = append(n.pending, &tx{
+		hash:  decoded.Hash,
+		from:  decoded.From,
+		to:    address("to", decoded.Hash),
+		nonce: decoded.Nonce,
+	})
+	return decoded.Hash, nil
+}
+
+func (n *Node) head() uint64 {
+	return uint64(len(n.blocks) - 1)
+}
+
+// reportedHead is the head reported by eth_blockNumber and "latest", which
+// stops advancing while the node is syncing.
+func (n *Node) reportedHead() uint64 {
+	if n.faults.Syncing && n.syncHeight < n.head() {
+		return n.syncHeight
+	}
+	return n.head()
+}
+
+func (n *Node) mine() {
+	number := uint64(len(n.blocks))
+	b := &block{
+		number:    number,
+		hash:      hash("block", n.reorgs, number),
+		timestamp: 1500000000 + number*15,
+	}
+	if number > 0 {
+		b.parentHash = n.blocks[number-1].hash
+	} else {
+		b.parentHash = "0x" + hex.EncodeToString(make([]byte, 32))
+	}
+
+	for i := 0; i < n.txsPerBlock; i++ {
+		from := address("from", i)
+		t := &tx{
+			hash:  hash("tx", n.reorgs, number, i),
+			from:  from,
+			to:    address("to", n.reorgs, number, i),
+			nonce: n.nonces[from],
+			value: number*1000 + uint64(i),
+		}
+		n.nonces[from]++
+		b.addTx(t)
+	}
+	for _, t := range n.pending {
+		n.nonces[t.from] = t.nonce + 1
+		b.addTx(t)
+	}
+	n.pending = nil
+
+	for _, t := range b.txs {
+		n.txs[t.hash] = t
+	}
+	n.blocks = append(n.blocks, b)
+}
+
+func (b *block) addTx(t *tx) {
+	t.index = len(b.txs)
+	t.block = b
+	b.txs = append(b.txs, t)
+}
+
+func hash(parts ...interface{}) string {
+	return "0x" + hex.EncodeToString(eth.Keccak256([]byte(fmt.Sprint(parts...))))
+}
+
+func address(parts ...interface{}) string {
+	return hash(parts...)[:42]
+}
@@ -0,0 +1,162 @@
+package mocknode
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"github.com/kyokan/chaind/pkg/jsonrpc"
+	"github.com/stretchr/testify/require"
+)
+
+// EIP-155 example transaction, with nonce 9
+const testRawTx = "0xf86c098504a817c800825208943535353535353535353535353535353535353535880de0b6b3a76400008025a028ef61340bd939bc2195fe537567866003e1a15d3c71ff63e1590620aa636276a067cbe9d8997f761aecb703304b3800ccf555c9f3dc64214b297fb1966a3b6d83"
+const testTxHash = "0x33469b22e9f636356c4160a87eb19df52b7412e8eac32a4a55ffe88ea8350788"
+
+func call(t *testing.T, url string, method string, params ...interface{}) *jsonrpc.Response {
+	if params == nil {
+		params = []interface{}{}
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  method,
+		"params":  params,
+	})
+	require.NoError(t, err)
+	res, err := http.Post(url, "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	var rpcRes jsonrpc.Response
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&rpcRes))
+	return &rpcRes
+}
+
+func result(t *testing.T, url string, method string, params ...interface{}) interface{} {
+	res := call(t, url, method, params...)
+	require.Nil(t, res.Error)
+	var out interface{}
+	require.NoError(t, json.Unmarshal(res.Result, &out))
+	return out
+}
+
+func TestNode_Blocks(t *testing.T) {
+	node := New(Config{InitialHeight: 10})
+	srv := httptest.NewServer(node)
+	defer srv.Close()
+
+	require.Equal(t, "0xa", result(t, srv.URL, "eth_blockNumber"))
+	block := result(t, srv.URL, "eth_getBlockByNumber", "0xa", false).(map[string]interface{})
+	require.Equal(t, "0xa", block["number"])
+	require.Len(t, block["transactions"], DefaultTxsPerBlock)
+	parent := result(t, srv.URL, "eth_getBlockByHash", block["parentHash"], true).(map[string]interface{})
+	require.Equal(t, "0x9", parent["number"])
+	require.Nil(t, result(t, srv.URL, "eth_getBlockByNumber", "0xb", false))
+
+	txHash := block["transactions"].([]interface{})[1]
+	receipt := result(t, srv.URL, "eth_getTransactionReceipt", txHash).(map[string]interface{})
+	require.Equal(t, block["hash"], receipt["blockHash"])
+	require.Equal(t, "0x1", receipt["transactionIndex"])
+	receipts := result(t, srv.URL, "eth_getBlockReceipts", "latest").([]interface{})
+	require.Equal(t, receipt, receipts[1])
+
+	logs := result(t, srv.URL, "eth_getLogs", map[string]string{"fromBlock": "0x9", "toBlock": "0xa"}).([]interface{})
+	require.Len(t, logs, 2*DefaultTxsPerBlock)
+	logs = result(t, srv.URL, "eth_getLogs", map[string]string{"blockHash": block["hash"].(string), "address": receipt["to"].(string)}).([]interface{})
+	require.Len(t, logs, 1)
+
+	require.Equal(t, "0xc", result(t, srv.URL, "mock_mine", 2))
+	require.Equal(t, uint64(12), node.Head())
+}
+
+func TestNode_Batch(t *testing.T) {
+	srv := httptest.NewServer(New(Config{}))
+	defer srv.Close()
+
+	res, err := http.Post(srv.URL, "application/json", bytes.NewReader([]byte(`[
+		{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]},
+		{"jsonrpc":"2.0","id":2,"method":"eth_notAMethod","params":[]}
+	]`)))
+	require.NoError(t, err)
+	defer res.Body.Close()
+	var responses []jsonrpc.Response
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&responses))
+	require.Len(t, responses, 2)
+	require.Equal(t, `"0x539"`, string(responses[0].Result))
+	require.Equal(t, jsonrpc.MethodNotFoundCode, responses[1].Error.Code)
+}
+
+func TestNode_Reorg(t *testing.T) {
+	node := New(Config{InitialHeight: 5})
+	srv := httptest.NewServer(node)
+	defer srv.Close()
+
+	before := result(t, srv.URL, "eth_getBlockByNumber", "0x4", false).(map[string]interface{})
+	oldTx := before["transactions"].([]interface{})[0]
+	require.Equal(t, "0x5", result(t, srv.URL, "mock_reorg", 2))
+
+	after := result(t, srv.URL, "eth_getBlockByNumber", "0x4", false).(map[string]interface{})
+	require.NotEqual(t, before["hash"], after["hash"])
+	require.Equal(t, before["parentHash"], after["parentHash"])
+	require.Nil(t, result(t, srv.URL, "eth_getTransactionReceipt", oldTx))
+	latest := result(t, srv.URL, "eth_getBlockByNumber", "latest", false).(map[string]interface{})
+	require.Equal(t, after["hash"], latest["parentHash"])
+
+	require.Error(t, node.Reorg(-1))
+	res := call(t, srv.URL, "mock_reorg", -1)
+	require.NotNil(t, res.Error)
+	require.Equal(t, invalidParamsCode, res.Error.Code)
+	require.Equal(t, "0x5", result(t, srv.URL, "eth_blockNumber"))
+}
+
+func TestNode_Faults(t *testing.T) {
+	node := New(Config{InitialHeight: 3})
+	srv := httptest.NewServer(node)
+	defer srv.Close()
+
+	require.Equal(t, false, result(t, srv.URL, "eth_syncing"))
+	node.SetFaults(Faults{Syncing: true, ErrorMethods: []string{"eth_gasPrice"}})
+	node.Mine(2)
+	require.Equal(t, "0x3", result(t, srv.URL, "eth_blockNumber"))
+	syncing := result(t, srv.URL, "eth_syncing").(map[string]interface{})
+	require.Equal(t, "0x5", syncing["highestBlock"])
+	require.Equal(t, internalErrorCode, call(t, srv.URL, "eth_gasPrice").Error.Code)
+
+	require.Equal(t, true, result(t, srv.URL, "mock_setFaults", map[string]interface{}{"http_status": 503}))
+	res, err := http.Post(srv.URL, "application/json", bytes.NewReader([]byte(`{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`)))
+	require.NoError(t, err)
+	ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	require.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+
+	require.Equal(t, true, result(t, srv.URL, "mock_setFaults"))
+	require.Equal(t, Faults{}, node.Faults())
+	require.Equal(t, "0x5", result(t, srv.URL, "eth_blockNumber"))
+}
+
+func TestNode_SendRawTransaction(t *testing.T) {
+	node := New(Config{})
+	srv := httptest.NewServer(node)
+	defer srv.Close()
+
+	require.Equal(t, testTxHash, result(t, srv.URL, "eth_sendRawTransaction", testRawTx))
+	require.NotNil(t, call(t, srv.URL, "eth_sendRawTransaction", testRawTx).Error)
+	from := "0x9d8a62f656a8d1615c1294fd71e9cfb3e4855a4f"
+	require.Equal(t, "0xa", result(t, srv.URL, "eth_getTransactionCount", from, "pending"))
+	require.Equal(t, "0x0", result(t, srv.URL, "eth_getTransactionCount", from, "latest"))
+	require.Nil(t, result(t, srv.URL, "eth_getTransactionReceipt", testTxHash))
+
+	node.Mine(1)
+	receipt := result(t, srv.URL, "eth_getTransactionReceipt", testTxHash).(map[string]interface{})
+	require.Equal(t, "0x1", receipt["blockNumber"])
+	require.Equal(t, "0xa", result(t, srv.URL, "eth_getTransactionCount", from, "latest"))
+
+	require.NoError(t, node.Reorg(1))
+	receipt = result(t, srv.URL, "eth_getTransactionReceipt", testTxHash).(map[string]interface{})
+	require.Equal(t, "0x1", receipt["blockNumber"])
+	require.NotNil(t, call(t, srv.URL, "eth_sendRawTransaction", testRawTx).Error)
+}